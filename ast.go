@@ -0,0 +1,435 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Node is an expression tree node. Leaves are NUMBER and IDENTIFIER
+// tokens; OPERATOR and FUNCTION nodes hold their operands in Args.
+type Node struct {
+	Token Token
+	Args  []*Node
+	// body is the term of a series as a tree, the Token's Body before it
+	// was flattened, which MarshalJSON writes.
+	body *Node
+}
+
+// buildAST turns a postfix token stream into an expression tree.
+func (c *Calculator) buildAST(postfix []Token) (*Node, error) {
+	var stack []*Node
+
+	for _, token := range postfix {
+		switch token.Type {
+		case NUMBER, STRING, IDENTIFIER, TARGET:
+			stack = append(stack, &Node{Token: token})
+		case OPERATOR:
+			arity := 2
+			if c.operators[token.Op].unary {
+				arity = 1
+			}
+			if len(stack) < arity {
+				return nil, fmt.Errorf("not enough operands for operator %s", token.Value)
+			}
+			n := &Node{Token: token, Args: append([]*Node(nil), stack[len(stack)-arity:]...)}
+			stack = append(stack[:len(stack)-arity], n)
+		case FUNCTION:
+			if len(stack) < token.Args {
+				return nil, fmt.Errorf("not enough arguments for function %s", token.Value)
+			}
+			args := append([]*Node(nil), stack[len(stack)-token.Args:]...)
+			n := &Node{Token: token, Args: args}
+			if token.Body != nil {
+				body, err := c.buildAST(token.Body)
+				if err != nil {
+					return nil, err
+				}
+				n.body = body
+			}
+			stack = append(stack[:len(stack)-token.Args], n)
+		}
+	}
+
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("invalid expression")
+	}
+
+	return stack[0], nil
+}
+
+// parse tokenizes input and returns its expression tree.
+func (c *Calculator) parse(input string) (*Node, error) {
+	tokens, err := c.tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	postfix, err := c.toPostfix(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.buildAST(postfix)
+}
+
+// postfix flattens the tree back into the token stream evaluatePostfix
+// expects.
+func (n *Node) postfix() []Token {
+	var tokens []Token
+	for _, arg := range n.Args {
+		tokens = append(tokens, arg.postfix()...)
+	}
+	return append(tokens, n.Token)
+}
+
+// format renders n as an infix expression, adding parentheses only where
+// precedence requires them.
+func (c *Calculator) format(n *Node) string {
+	switch n.Token.Type {
+	case OPERATOR:
+		prec := c.operators[n.Token.Op].precedence
+		if c.operators[n.Token.Op].unary {
+			operand := c.format(n.Args[0])
+			if c.needsParens(n.Args[0], prec, false) || strings.HasPrefix(operand, "-") {
+				operand = "(" + operand + ")"
+			}
+			return n.Token.Value + operand
+		}
+		left := c.format(n.Args[0])
+		if c.needsParens(n.Args[0], prec, false) || (c.chains(n) && !n.Token.Chain) {
+			left = "(" + left + ")"
+		}
+		right := c.format(n.Args[1])
+		if c.needsParens(n.Args[1], prec, true) {
+			right = "(" + right + ")"
+		}
+		if c.operators[n.Token.Op].sequence {
+			return left + ", " + right
+		}
+		return left + " " + n.Token.Value + " " + right
+	case FUNCTION:
+		args := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = c.format(arg)
+			// A sequence as an argument keeps its parentheses, or its
+			// comma would separate arguments.
+			if arg.Token.Type == OPERATOR && c.operators[arg.Token.Op].sequence {
+				args[i] = "(" + args[i] + ")"
+			}
+		}
+		if n.Token.Value == setFunction {
+			return "{" + strings.Join(args, ", ") + "}"
+		}
+		if n.Token.Body != nil {
+			// collectSeries has checked that the term builds.
+			body, _ := c.buildAST(n.Token.Body)
+			args = append(args, c.format(body))
+		}
+		return n.Token.Value + "(" + strings.Join(args, ", ") + ")"
+	case STRING:
+		return `"` + n.Token.Value + `"`
+	default:
+		return n.Token.Value
+	}
+}
+
+func (c *Calculator) needsParens(child *Node, prec int, right bool) bool {
+	if child.Token.Type == NUMBER && strings.Contains(child.Token.Value, "/") {
+		// A fraction from rational mode reads back as a division.
+		return precMul < prec || (precMul == prec && right)
+	}
+	if child.Token.Type == NUMBER && strings.HasPrefix(child.Token.Value, "-") {
+		// A negative literal reads back as a negation.
+		return c.operators[c.unaryIDs["-"]].precedence < prec
+	}
+	if child.Token.Type != OPERATOR {
+		return false
+	}
+	op := c.operators[child.Token.Op]
+	if op.unary {
+		return op.precedence < prec
+	}
+	// Equal precedence needs parentheses on the side the operator does not
+	// associate towards: a - (b - c), but (a ^ b) ^ c.
+	return op.precedence < prec || (op.precedence == prec && right != op.rightAssoc)
+}
+
+// chains reports whether the binary operator node n is a comparison whose
+// left operand is a comparison it would chain with if written without
+// parentheses.
+func (c *Calculator) chains(n *Node) bool {
+	left := n.Args[0]
+	if left.Token.Type != OPERATOR || len(left.Args) != 2 {
+		return false
+	}
+	op, leftOp := c.operators[n.Token.Op], c.operators[left.Token.Op]
+	return chainable(op) && chainable(leftOp) && op.precedence == leftOp.precedence
+}
+
+// Explain renders the expression tree of input, indented one level per
+// depth, followed by each reduction from the innermost operation outwards,
+// e.g. for 2 * (3 + 4):
+//
+//	*
+//	  2
+//	  +
+//	    3
+//	    4
+//
+//	3 + 4 = 7
+//	2 * 7 = 14
+func (c *Calculator) Explain(input string) (_ string, err error) {
+	defer recoverPanic(&err)
+
+	root, err := c.parse(input)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	writeTree(&sb, root, 0)
+	sb.WriteString("\n")
+
+	if _, err := c.reduce(&sb, root); err != nil {
+		return "", err
+	}
+
+	return sb.String(), nil
+}
+
+// Postfix returns input in Reverse Polish Notation, the order the
+// evaluator applies it in: 3 + 4 * 2 is "3 4 2 * +". Prefix minus is
+// written neg to tell it from subtraction, prefix plus is left out, and a
+// call of a function that takes any number of arguments carries its
+// count, as in mean/3. Chained comparisons such as 1 < x < 10 have no
+// postfix form and are an error, as are series.
+func (c *Calculator) Postfix(input string) (_ string, err error) {
+	defer recoverPanic(&err)
+
+	tokens, err := c.tokenize(input)
+	if err != nil {
+		return "", err
+	}
+	postfix, err := c.toPostfix(tokens)
+	if err != nil {
+		return "", err
+	}
+	if _, err := c.buildAST(postfix); err != nil {
+		return "", err
+	}
+
+	var words []string
+	for _, t := range postfix {
+		unary := t.Type == OPERATOR && c.operators[t.Op].unary
+		switch {
+		case t.Chain:
+			return "", errorAt(t.Pos, "chained comparison at position %d has no RPN form", t.Pos)
+		case t.Body != nil:
+			return "", errorAt(t.Pos, "series at position %d has no RPN form", t.Pos)
+		case unary && t.Value == "+":
+			// Prefix plus changes nothing.
+		case unary && t.Value == "-":
+			words = append(words, "neg")
+		case t.Type == STRING:
+			words = append(words, strconv.Quote(t.Value))
+		case t.Type == FUNCTION && c.functions[t.Value].arity < 0:
+			words = append(words, t.Value+"/"+strconv.Itoa(t.Args))
+		default:
+			words = append(words, t.Value)
+		}
+	}
+	return strings.Join(words, " "), nil
+}
+
+// FreeVariables returns the distinct variables input reads, in order of
+// first use, leaving out constants, functions and variables it assigns to
+// before reading them: for r = 2 * pi * radius + sin(x) that is radius and
+// x, and for y = 2, x * y it is x.
+// Macro invocations and eval contribute the variables they expand to.
+func (c *Calculator) FreeVariables(input string) (_ []string, err error) {
+	defer recoverPanic(&err)
+
+	root, err := c.parse(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	var visit func(n *Node, bound map[string]bool)
+	visit = func(n *Node, bound map[string]bool) {
+		for _, arg := range n.Args {
+			visit(arg, bound)
+		}
+		if n.Token.Body != nil {
+			// The term of a series also reads variables, except its
+			// index.
+			inner := map[string]bool{n.Args[0].Token.Value: true}
+			for name := range bound {
+				inner[name] = true
+			}
+			body, _ := c.buildAST(n.Token.Body)
+			visit(body, inner)
+		}
+		t := n.Token
+		if t.Type == OPERATOR && c.operators[t.Op].assign {
+			// Operands are visited in the order they are evaluated, so
+			// what follows reads the value assigned.
+			bound[n.Args[0].Token.Value] = true
+			return
+		}
+		if t.Type != IDENTIFIER || seen[t.Value] || bound[t.Value] {
+			return
+		}
+		if _, ok := c.constants[t.Value]; ok {
+			return
+		}
+		if c.Complex && t.Value == "i" {
+			if _, ok := c.vars["i"]; !ok {
+				return
+			}
+		}
+		seen[t.Value] = true
+		names = append(names, t.Value)
+	}
+	visit(root, make(map[string]bool))
+	return names, nil
+}
+
+func writeTree(sb *strings.Builder, n *Node, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	sb.WriteString(n.Token.Value)
+	sb.WriteString("\n")
+	for _, arg := range n.Args {
+		writeTree(sb, arg, depth+1)
+	}
+}
+
+// reduce evaluates n bottom-up, writing one line per operator or function
+// with its operands substituted by their computed values.
+func (c *Calculator) reduce(sb *strings.Builder, n *Node) (Value, error) {
+	if len(n.Args) == 0 {
+		return c.evaluatePostfix([]Token{n.Token})
+	}
+
+	if n.Token.Chain {
+		return c.reduceChain(sb, n)
+	}
+
+	step := &Node{Token: n.Token, Args: make([]*Node, len(n.Args))}
+	values := make([]Value, len(n.Args))
+	for i, arg := range n.Args {
+		if arg.Token.Type == TARGET {
+			step.Args[i] = arg
+			continue
+		}
+		v, err := c.reduce(sb, arg)
+		if err != nil {
+			return Value{}, err
+		}
+		step.Args[i] = c.valueNode(v)
+		values[i] = v
+	}
+
+	result, err := c.evaluateStep(step, values)
+	if err != nil {
+		return Value{}, err
+	}
+	switch {
+	case n.Token.Type == OPERATOR && c.operators[n.Token.Op].assign:
+		// "b = 6" already shows the result.
+		fmt.Fprintf(sb, "%s\n", c.format(step))
+	case n.Token.Type == FUNCTION && n.Token.Value == setFunction:
+		// A set literal evaluates to itself.
+	case n.Token.Type == OPERATOR && c.operators[n.Token.Op].unary:
+		// "-(4) = -4" rather than "-4 = -4", which reads as a literal.
+		fmt.Fprintf(sb, "%s(%s) = %v\n", n.Token.Value, c.format(step.Args[0]), result)
+	default:
+		fmt.Fprintf(sb, "%s = %v\n", c.format(step), result)
+	}
+
+	return result, nil
+}
+
+// reduceChain is reduce for a comparison continuing a chain: it writes the
+// previous comparisons, then this one applied to their last right operand,
+// as in 5 < 10 = 1 for 1 < x < 10.
+func (c *Calculator) reduceChain(sb *strings.Builder, n *Node) (Value, error) {
+	prev, err := c.reduce(sb, n.Args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	right, err := c.reduce(sb, n.Args[1])
+	if err != nil {
+		return Value{}, err
+	}
+	token := n.Token
+	token.Chain = false
+	step := &Node{Token: token, Args: []*Node{number(prev.middle), c.valueNode(right)}}
+	result, err := c.evaluateStep(step, []Value{{}, right})
+	if err != nil {
+		return Value{}, err
+	}
+	fmt.Fprintf(sb, "%s = %v\n", c.format(step), result)
+	if prev.Num == 0 {
+		result.Num = 0
+	}
+	return result, nil
+}
+
+// valueNode returns a literal node holding v. A fraction outside
+// rational mode, where 1/2 does not parse as a literal, is a division,
+// and a tuple is shown as it prints.
+func (c *Calculator) valueNode(v Value) *Node {
+	switch v.Kind {
+	case StringValue:
+		return &Node{Token: Token{Type: STRING, Value: v.Str}}
+	case TupleValue:
+		return &Node{Token: Token{Type: NUMBER, Value: v.String()}}
+	case ComplexValue:
+		if imag(v.Complex) != 0 {
+			return &Node{Token: Token{Type: NUMBER, Value: strconv.FormatComplex(v.Complex, 'g', -1, 128)}}
+		}
+		return number(real(v.Complex))
+	case RationalValue:
+		if !c.Rational && !v.Rat.IsInt() {
+			return &Node{Token: Token{Type: OPERATOR, Value: "/", Op: c.binarySymbols["/"]}, Args: []*Node{
+				{Token: Token{Type: NUMBER, Value: v.Rat.Num().String()}},
+				{Token: Token{Type: NUMBER, Value: v.Rat.Denom().String()}},
+			}}
+		}
+		return &Node{Token: Token{Type: NUMBER, Value: v.Rat.RatString()}}
+	case CustomValue:
+		return &Node{Token: Token{Type: NUMBER, Value: v.Custom.String()}}
+	case setValue:
+		members := make([]*Node, len(v.members))
+		for i, m := range v.members {
+			members[i] = number(m)
+		}
+		return call(setFunction, members...)
+	}
+	return &Node{Token: Token{Type: NUMBER, Value: strconv.FormatFloat(v.Num, 'g', -1, 64)}}
+}
+
+// evaluateStep evaluates step, an operator or function with its operands
+// replaced by literals of values. An operand that valueNode cannot write
+// as a literal evaluating to it again, a tuple or a fraction outside
+// rational mode, is read from a placeholder variable instead, so the step
+// gives what evaluation gave.
+func (c *Calculator) evaluateStep(step *Node, values []Value) (Value, error) {
+	eval := &Node{Token: step.Token, Args: make([]*Node, len(step.Args))}
+	for i, arg := range step.Args {
+		eval.Args[i] = arg
+		v := values[i]
+		if v.Kind != TupleValue && (v.Kind != RationalValue || c.Rational) {
+			continue
+		}
+		// No input can name a variable starting with a NUL.
+		name := "\x00" + strconv.Itoa(i)
+		c.vars[name] = v
+		defer delete(c.vars, name)
+		eval.Args[i] = &Node{Token: Token{Type: IDENTIFIER, Value: name}}
+	}
+	return c.evaluatePostfix(eval.postfix())
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func ExampleCalculator_Explain() {
+	c := NewCalculator()
+	s, err := c.Explain("2 * (3 + 4)")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Print(s)
+	// Output:
+	// *
+	//   2
+	//   +
+	//     3
+	//     4
+	//
+	// 3 + 4 = 7
+	// 2 * 7 = 14
+}
+
+func TestExplainUnaryStep(t *testing.T) {
+	c := NewCalculator()
+	s, err := c.Explain("-(2^2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"2 ^ 2 = 4\n", "-(4) = -4\n"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("Explain(%q) = %q, want a line %q", "-(2^2)", s, want)
+		}
+	}
+}
+
+func TestPostfix(t *testing.T) {
+	c := NewCalculator()
+	for input, want := range map[string]string{
+		"3 + 4 * 2":     "3 4 2 * +",
+		"(1 + 2) * 3":   "1 2 + 3 *",
+		"2 ^ 3 ^ 2":     "2 3 2 ^ ^",
+		"10 - 4 - 3":    "10 4 - 3 -",
+		"sqrt(16) + -2": "16 sqrt 2 neg +",
+		"+2 * 3":        "2 3 *",
+		"mean(1, 2, 3)": "1 2 3 mean/3",
+		"x = 3":         "x 3 =",
+	} {
+		got, err := c.Postfix(input)
+		if err != nil {
+			t.Errorf("Postfix(%q): %v", input, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Postfix(%q) = %q, want %q", input, got, want)
+		}
+	}
+	for input, want := range map[string]string{
+		"1 < x < 10":         "chained comparison at position 6 has no RPN form",
+		"series(i, 1, 3, i)": "series at position 0 has no RPN form",
+	} {
+		if _, err := c.Postfix(input); err == nil || err.Error() != want {
+			t.Errorf("Postfix(%q) error = %v, want %q", input, err, want)
+		}
+	}
+}
+
+func TestRPNFlag(t *testing.T) {
+	if out, _, _ := run("", "--rpn", "3 + 4 * 2"); out != "RPN = 3 4 2 * +\n" {
+		t.Errorf("output = %q", out)
+	}
+}
+
+func TestFreeVariables(t *testing.T) {
+	c := NewCalculator()
+	if err := c.Define("area(w) = w * height"); err != nil {
+		t.Fatal(err)
+	}
+	for input, want := range map[string]string{
+		"r = 2 * pi * radius + sin(x)": "radius x",
+		"y = 2, x * y":                 "x",
+		"a + b * a - e":                "a b",
+		"max(a, b) + sqrt(c)":          "a b c",
+		"pi * 2":                       "",
+		"series(i, 1, n, i * k)":       "n k",
+		"area(3) + w":                  "height w",
+	} {
+		names, err := c.FreeVariables(input)
+		if err != nil {
+			t.Errorf("FreeVariables(%q): %v", input, err)
+			continue
+		}
+		if got := strings.Join(names, " "); got != want {
+			t.Errorf("FreeVariables(%q) = %q, want %q", input, got, want)
+		}
+	}
+	if _, err := c.FreeVariables("1 +"); err == nil {
+		t.Error("FreeVariables(\"1 +\") succeeded")
+	}
+}
+
+func TestExplainTuple(t *testing.T) {
+	c := NewCalculator()
+	s, err := c.Explain("x = polar(3, 4)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair := "(5, 0.9272952180016122)"
+	for _, want := range []string{"polar(3, 4) = " + pair + "\n", "x = " + pair + "\n"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("Explain = %q, want a line %q", s, want)
+		}
+	}
+	// The reduction assigns what evaluation does.
+	if v, err := c.Evaluate("x"); err != nil || v.String() != pair {
+		t.Errorf("x after Explain = %v, %v, want %s", v, err, pair)
+	}
+	if _, err := c.Explain("polar(3, 4) + 1"); err == nil || !strings.Contains(err.Error(), "got tuple "+pair) {
+		t.Errorf("Explain(polar(3, 4) + 1) error = %v, want got tuple", err)
+	}
+}
+
+func TestExplainFraction(t *testing.T) {
+	c := NewCalculator()
+	s, err := c.Explain("2 ^ tofraction(0.5) + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Outside rational mode 1/2 is no literal, so it is written as the
+	// division it is.
+	for _, want := range []string{"tofraction(0.5) = 1/2\n", "2 ^ (1 / 2) = 1.4142135623730951\n"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("Explain = %q, want a line %q", s, want)
+		}
+	}
+	if _, err := c.Explain("x = tofraction(0.5)"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Evaluate("x"); err != nil || v.Kind != RationalValue || v.String() != "1/2" {
+		t.Errorf("x after Explain = %#v, %v, want the fraction 1/2", v, err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// nodeJSON is the JSON form of a Node. Exactly one of the first six
+// fields is set, saying what the node is; operators and functions have
+// their operands in Args, and a series its term in Body. So 2 * (x + 1)
+// is
+//
+//	{"operator": "*", "args": [
+//	  {"number": "2"},
+//	  {"operator": "+", "args": [{"identifier": "x"}, {"number": "1"}]}]}
+//
+// Numbers are strings, so a literal such as 0.1 is kept as written.
+type nodeJSON struct {
+	Number     *string `json:"number,omitempty"`
+	String     *string `json:"string,omitempty"`
+	Identifier *string `json:"identifier,omitempty"`
+	Target     *string `json:"target,omitempty"`
+	Operator   *string `json:"operator,omitempty"`
+	Function   *string `json:"function,omitempty"`
+	// Chain marks a comparison continuing a chain such as 1 < x < 10.
+	Chain bool    `json:"chain,omitempty"`
+	Args  []*Node `json:"args,omitempty"`
+	Body  *Node   `json:"body,omitempty"`
+}
+
+// MarshalJSON writes n as structured JSON that UnmarshalJSON reads back,
+// independent of how the expression was written: the operators by their
+// own symbols, with a prefix one told from a binary one by its single
+// operand, and no positions or labels.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	t := n.Token
+	var j nodeJSON
+	switch t.Type {
+	case NUMBER:
+		j.Number = &t.Value
+	case STRING:
+		j.String = &t.Value
+	case IDENTIFIER:
+		j.Identifier = &t.Value
+	case TARGET:
+		j.Target = &t.Value
+	case OPERATOR:
+		j.Operator, j.Chain, j.Args = &t.Value, t.Chain, n.Args
+	case FUNCTION:
+		j.Function, j.Args = &t.Value, n.Args
+		if t.Body != nil {
+			if n.body == nil {
+				return nil, fmt.Errorf("series %s has no term", t.Value)
+			}
+			j.Body = n.body
+		}
+	default:
+		return nil, fmt.Errorf("cannot encode %v", t)
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON reads a node written by MarshalJSON. It checks only the
+// shape of the tree; whether its operators and functions exist is up to
+// the Calculator that evaluates it.
+func (n *Node) UnmarshalJSON(data []byte) error {
+	var j nodeJSON
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&j); err != nil {
+		return err
+	}
+
+	kinds := 0
+	for _, s := range []*string{j.Number, j.String, j.Identifier, j.Target, j.Operator, j.Function} {
+		if s != nil {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return errors.New("node must have exactly one of number, string, identifier, target, operator and function")
+	}
+	for _, arg := range j.Args {
+		if arg == nil {
+			return errors.New("node has a null operand")
+		}
+	}
+	if j.Args != nil && j.Operator == nil && j.Function == nil {
+		return errors.New("only operators and functions take args")
+	}
+	if j.Body != nil && j.Function == nil {
+		return errors.New("only a series takes a body")
+	}
+	if j.Chain && (j.Operator == nil || len(j.Args) != 2) {
+		return errors.New("only a binary operator can chain")
+	}
+
+	*n = Node{Args: j.Args}
+	switch {
+	case j.Number != nil:
+		n.Token = Token{Type: NUMBER, Value: *j.Number}
+	case j.String != nil:
+		n.Token = Token{Type: STRING, Value: *j.String}
+	case j.Identifier != nil:
+		n.Token = Token{Type: IDENTIFIER, Value: *j.Identifier}
+	case j.Target != nil:
+		n.Token = Token{Type: TARGET, Value: *j.Target}
+	case j.Operator != nil:
+		if len(j.Args) != 1 && len(j.Args) != 2 {
+			return fmt.Errorf("operator %s takes 1 or 2 operands, got %d", *j.Operator, len(j.Args))
+		}
+		n.Token = Token{Type: OPERATOR, Value: *j.Operator, Chain: j.Chain}
+	case j.Function != nil:
+		n.Token = Token{Type: FUNCTION, Value: *j.Function, Args: len(j.Args)}
+		if j.Body != nil {
+			n.body = j.Body
+			n.Token.Body = j.Body.postfix()
+		}
+	}
+	return nil
+}
+
+// Parse returns the expression tree of input, which EvaluateNode
+// evaluates and encoding/json turns into structured JSON and back.
+func (c *Calculator) Parse(input string) (_ *Node, err error) {
+	defer recoverPanic(&err)
+	return c.parse(input)
+}
+
+// EvaluateNode evaluates an expression tree, from Parse or read from JSON,
+// as Evaluate does the expression it was parsed from. The operators are
+// found by their symbols, so a tree can be evaluated by a Calculator other
+// than the one that parsed it, even one that has removed or aliased them.
+func (c *Calculator) EvaluateNode(n *Node) (_ Value, err error) {
+	defer recoverPanic(&err)
+
+	root, err := c.resolve(n)
+	if err != nil {
+		return Value{}, err
+	}
+	return c.evaluatePostfix(root.postfix())
+}
+
+// resolve returns a copy of n with its operator tokens pointing into c's
+// operator table and the Body of each series made from its tree.
+func (c *Calculator) resolve(n *Node) (*Node, error) {
+	if n == nil {
+		return nil, errors.New("missing expression node")
+	}
+	r := &Node{Token: n.Token}
+	if n.Args != nil {
+		r.Args = make([]*Node, len(n.Args))
+		for i, arg := range n.Args {
+			a, err := c.resolve(arg)
+			if err != nil {
+				return nil, err
+			}
+			r.Args[i] = a
+		}
+	}
+
+	t := &r.Token
+	switch t.Type {
+	case OPERATOR:
+		ids, kind := c.operatorIDs, "binary"
+		if len(r.Args) == 1 {
+			ids, kind = c.unaryIDs, "prefix"
+		}
+		id, ok := ids[t.Value]
+		if !ok || (len(r.Args) != 1 && len(r.Args) != 2) {
+			return nil, fmt.Errorf("unknown %s operator %s", kind, t.Value)
+		}
+		t.Op = id
+		if t.Chain {
+			left := r.Args[0].Token
+			if !c.operators[id].boolean || left.Type != OPERATOR || !c.operators[left.Op].boolean {
+				return nil, fmt.Errorf("operator %s cannot continue a chain of comparisons", t.Value)
+			}
+		}
+	case FUNCTION:
+		t.Args = len(r.Args)
+		if n.body != nil {
+			body, err := c.resolve(n.body)
+			if err != nil {
+				return nil, err
+			}
+			r.body, t.Body = body, body.postfix()
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNodeJSON(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{{"x = 4", "4"}})
+	for _, input := range []string{
+		"2 * (x + 1)",
+		"-x^2",
+		"0.1 + 0.2",
+		"1 < x < 10",
+		"mean(1, 2, 3) * pi",
+		"series(i, 1, 5, i^2)",
+		"fixed(2.005, 2)",
+		"y = 3, y + x",
+	} {
+		node, err := c.Parse(input)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", input, err)
+		}
+		data, err := json.Marshal(node)
+		if err != nil {
+			t.Fatalf("Marshal(%q): %v", input, err)
+		}
+		var back Node
+		if err := json.Unmarshal(data, &back); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", data, err)
+		}
+		again, err := json.Marshal(&back)
+		if err != nil || string(again) != string(data) {
+			t.Errorf("%q encodes as %s, then as %s, %v", input, data, again, err)
+		}
+		got, err := c.EvaluateNode(&back)
+		if err != nil {
+			t.Errorf("EvaluateNode(%s): %v", data, err)
+			continue
+		}
+		want, err := c.Evaluate(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("%q from JSON = %v, from text %v", input, got, want)
+		}
+	}
+
+	node, err := c.Parse("2 * (x + 1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"operator":"*","args":[{"number":"2"},{"operator":"+","args":[{"identifier":"x"},{"number":"1"}]}]}`; string(data) != want {
+		t.Errorf("2 * (x + 1) encodes as %s, want %s", data, want)
+	}
+
+	for _, input := range []string{
+		`{}`,
+		`{"number":"1","identifier":"x"}`,
+		`{"number":"1","args":[]}`,
+		`{"operator":"+","args":[null,{"number":"1"}]}`,
+		`{"operator":"+","args":[{"number":"1"},{"number":"2"},{"number":"3"}]}`,
+		`{"number":"1","extra":true}`,
+	} {
+		var n Node
+		if err := json.Unmarshal([]byte(input), &n); err == nil {
+			t.Errorf("Unmarshal(%s) succeeded", input)
+		}
+	}
+	var n Node
+	if err := json.Unmarshal([]byte(`{"operator":"@","args":[{"number":"1"},{"number":"2"}]}`), &n); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.EvaluateNode(&n); err == nil || err.Error() != "unknown binary operator @" {
+		t.Errorf("EvaluateNode of an unknown operator error = %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// CalculateWithBounds is Calculate, also returning a bound on the
+// floating-point error of the result: how far it may be from what exact
+// arithmetic on the same literals would give, to first order. A literal
+// the float64 cannot hold exactly, such as 0.1, starts with half a unit
+// in the last place (ulp) of error, as do the constants; variables set
+// before the call count as exact. Each operation then passes on the error
+// of its operands, found by evaluating it again with each operand moved
+// a little either way, and adds its own rounding: none for
+// integer results of integer operands, comparisons and changes of sign,
+// half an ulp for the other operators and one for functions.
+//
+// The bound shows how many digits to trust: (1 + 1e-15) - 1 is
+// 1.1102230246251565e-15 with a bound of about 1.1e-16, so only its first
+// digit is right. It is an estimate, not a proof: chained comparisons
+// and functions such as rand count as exact, and the operations are
+// taken to be smooth over the bound, which a comparison or floor near a
+// step is not. Complex mode and Numbers are not supported; rational mode
+// is exact, so its bound is 0.
+func (c *Calculator) CalculateWithBounds(input string) (_ float64, bound float64, err error) {
+	defer recoverPanic(&err)
+
+	if c.Complex {
+		return 0, 0, errors.New("error bounds are not supported in complex mode")
+	}
+	if c.Numbers != nil && !c.Rational {
+		return 0, 0, errors.New("error bounds are not supported with a number system")
+	}
+	if c.Rational {
+		x, err := c.Calculate(input)
+		return x, 0, err
+	}
+	root, err := c.parse(input)
+	if err != nil {
+		return 0, 0, err
+	}
+	// Every operation is evaluated several times over; only what the
+	// expression itself does belongs in the trace.
+	trace := c.Trace
+	c.Trace = nil
+	defer func() { c.Trace = trace }()
+
+	b := &bounder{c: c, vars: make(map[string]float64)}
+	v, bound, err := b.bound(root)
+	if err != nil {
+		return 0, 0, err
+	}
+	x, err := v.number("result")
+	return x, bound, err
+}
+
+// bounder evaluates an expression tree for CalculateWithBounds, keeping
+// with each value the bound on its error.
+type bounder struct {
+	c *Calculator
+	// vars holds the bounds of the variables the expression has
+	// assigned so far.
+	vars map[string]float64
+}
+
+// bound evaluates n and returns its value and error bound.
+func (b *bounder) bound(n *Node) (Value, float64, error) {
+	c := b.c
+	t := n.Token
+	if len(n.Args) == 0 && t.Type != FUNCTION {
+		v, err := c.evaluatePostfix([]Token{t})
+		if err != nil || v.Kind != NumberValue {
+			return v, 0, err
+		}
+		switch t.Type {
+		case NUMBER:
+			if exact, ok := new(big.Rat).SetString(t.Value); ok && exact.Cmp(new(big.Rat).SetFloat64(v.Num)) == 0 {
+				return v, 0, nil
+			}
+		case IDENTIFIER:
+			if e, ok := b.vars[t.Value]; ok {
+				return v, e, nil
+			}
+			if _, ok := c.vars[t.Value]; ok {
+				return v, 0, nil
+			}
+		}
+		return v, ulp(v.Num) / 2, nil
+	}
+	if t.Body != nil {
+		return b.series(n)
+	}
+	if t.Chain {
+		// reduceChain writes its steps for Explain, which are dropped.
+		var sb strings.Builder
+		v, err := c.reduceChain(&sb, n)
+		return v, 0, err
+	}
+
+	step := &Node{Token: t, Args: make([]*Node, len(n.Args))}
+	values := make([]Value, len(n.Args))
+	bounds := make([]float64, len(n.Args))
+	for i, arg := range n.Args {
+		if arg.Token.Type == TARGET {
+			step.Args[i] = arg
+			continue
+		}
+		v, e, err := b.bound(arg)
+		if err != nil {
+			return Value{}, 0, err
+		}
+		step.Args[i] = c.valueNode(v)
+		values[i], bounds[i] = v, e
+	}
+	result, err := c.evaluateStep(step, values)
+	if err != nil || result.Kind != NumberValue {
+		return result, 0, err
+	}
+
+	var op operator
+	if t.Type == OPERATOR {
+		op = c.operators[t.Op]
+		switch {
+		case op.assign:
+			b.vars[n.Args[0].Token.Value] = bounds[1]
+			return result, bounds[1], nil
+		case op.sequence:
+			return result, bounds[1], nil
+		}
+	}
+	if t.Type == FUNCTION && c.functions[t.Value].volatile {
+		return result, 0, nil
+	}
+
+	r := result.Num
+	if math.IsInf(r, 0) || math.IsNaN(r) {
+		return result, math.Inf(1), nil
+	}
+	total := 0.0
+	integers := r == math.Trunc(r) && math.Abs(r) <= maxExactInt
+	for i, v := range values {
+		if v.Kind != NumberValue {
+			continue
+		}
+		if v.Num != math.Trunc(v.Num) {
+			integers = false
+		}
+		if bounds[i] == 0 {
+			continue
+		}
+		total += b.spread(step, i, v.Num, bounds[i], r)
+	}
+	switch {
+	case op.boolean, op.unary && (op.symbol == "-" || op.symbol == "+"), integers:
+	case t.Type == FUNCTION:
+		total += ulp(r)
+	default:
+		total += ulp(r) / 2
+	}
+	return result, total, nil
+}
+
+// spread returns how far step, whose value is r, moves when its operand i
+// moves from x by up to e: the part of the operand's error that carries
+// through. An e too small to move x at all, as half an ulp is, would show
+// nothing, so the operand is moved by at least sqrtEpsilon times x and
+// the change scaled back down, which is a finite difference. A side on
+// which step fails, as sqrt does below zero, is skipped; if both fail the
+// error is unbounded.
+func (b *bounder) spread(step *Node, i int, x, e, r float64) float64 {
+	arg := step.Args[i]
+	defer func() { step.Args[i] = arg }()
+	h := math.Max(e, sqrtEpsilon*math.Abs(x))
+	spread, defined := 0.0, false
+	for _, end := range []float64{x - h, x + h} {
+		step.Args[i] = number(end)
+		v, err := b.c.evaluatePostfix(step.postfix())
+		if err != nil || v.Kind != NumberValue {
+			continue
+		}
+		defined = true
+		spread = math.Max(spread, math.Abs(v.Num-r)*(e/h))
+	}
+	if !defined || math.IsNaN(spread) {
+		return math.Inf(1)
+	}
+	return spread
+}
+
+// sqrtEpsilon is the square root of the float64 machine epsilon, 2^-26,
+// the usual step of a finite difference: small enough to follow the
+// function and large enough to stay clear of its rounding.
+const sqrtEpsilon = 1.0 / (1 << 26)
+
+// series is bound for a series: the bounds of its terms, each evaluated
+// with the exact index, and the rounding of each addition.
+func (b *bounder) series(n *Node) (Value, float64, error) {
+	c := b.c
+	var limits [2]float64
+	for i, arg := range n.Args[1:] {
+		v, _, err := b.bound(arg)
+		if err != nil {
+			return Value{}, 0, err
+		}
+		if limits[i], err = v.number("bound"); err != nil {
+			return Value{}, 0, err
+		}
+	}
+	if _, err := seriesTerms(limits[0], limits[1]); err != nil {
+		return Value{}, 0, fmt.Errorf("%s: %w", n.Token.Value, err)
+	}
+	body, err := c.buildAST(n.Token.Body)
+	if err != nil {
+		return Value{}, 0, err
+	}
+
+	index := Value{Kind: targetValue, Str: n.Args[0].Token.Value}
+	previous, had := c.vars[index.Str]
+	previousBound, hadBound := b.vars[index.Str]
+	defer func() {
+		if had {
+			c.vars[index.Str] = previous
+		} else {
+			delete(c.vars, index.Str)
+		}
+		if hadBound {
+			b.vars[index.Str] = previousBound
+		} else {
+			delete(b.vars, index.Str)
+		}
+	}()
+
+	sum, total := 0.0, 0.0
+	for k := limits[0]; k <= limits[1]; k++ {
+		if err := c.assign(index, Value{Num: k}); err != nil {
+			return Value{}, 0, err
+		}
+		b.vars[index.Str] = 0
+		term, e, err := b.bound(body)
+		if err != nil {
+			return Value{}, 0, err
+		}
+		x, err := term.number("term")
+		if err != nil {
+			return Value{}, 0, err
+		}
+		sum, err = c.checkOverflow(sum+x, sum, x)
+		if err != nil {
+			return Value{}, 0, err
+		}
+		total += e
+		if sum != math.Trunc(sum) || x != math.Trunc(x) {
+			total += ulp(sum) / 2
+		}
+	}
+	return Value{Num: sum}, total, nil
+}
+
+// ulp is the unit in the last place of x: the gap from |x| to the next
+// float64 away from zero.
+func ulp(x float64) float64 {
+	x = math.Abs(x)
+	return math.Nextafter(x, math.Inf(1)) - x
+}
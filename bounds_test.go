@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalculateWithBounds(t *testing.T) {
+	c := NewCalculator()
+	for _, tc := range []struct {
+		input    string
+		result   float64
+		min, max float64
+	}{
+		// Exact integers and their sums carry no error.
+		{"1 + 2", 3, 0, 0},
+		{"0.1 + 0.2", 0.30000000000000004, 1e-17, 1e-16},
+		// Cancellation leaves a relative error near 10%.
+		{"(1 + 1e-15) - 1", 1.1102230246251565e-15, 5e-17, 2e-16},
+		{"1e16 + 1 - 1e16", 0, 1, 4},
+		{"sqrt(2) * sqrt(2)", 2.0000000000000004, 1e-16, 2e-15},
+	} {
+		result, bound, err := c.CalculateWithBounds(tc.input)
+		if err != nil {
+			t.Errorf("CalculateWithBounds(%q) error: %v", tc.input, err)
+			continue
+		}
+		if result != tc.result || bound < tc.min || bound > tc.max {
+			t.Errorf("CalculateWithBounds(%q) = %v ± %v, want %v within [%v, %v]", tc.input, result, bound, tc.result, tc.min, tc.max)
+		}
+	}
+
+	// The ill-conditioned expression reports a larger bound, relative to
+	// its result, than the well-conditioned one with the same inputs.
+	good, goodBound, err := c.CalculateWithBounds("(1 + 1e-15) + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, badBound, err := c.CalculateWithBounds("(1 + 1e-15) - 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if badBound/math.Abs(bad) <= 1e6*goodBound/math.Abs(good) {
+		t.Errorf("relative bounds %v and %v, want the cancellation's far larger", badBound/bad, goodBound/good)
+	}
+
+	c.Rational = true
+	if _, bound, err := c.CalculateWithBounds("1/3 + 1/6"); err != nil || bound != 0 {
+		t.Errorf("rational bound = %v, %v, want 0", bound, err)
+	}
+	c.Rational, c.Complex = false, true
+	if _, _, err := c.CalculateWithBounds("1 + 2"); err == nil {
+		t.Errorf("CalculateWithBounds in complex mode succeeded")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+)
+
+// resultCache remembers the results of pure expressions, least recently
+// used first out.
+type resultCache struct {
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type cacheEntry struct {
+	key   string
+	value Value
+}
+
+// cacheKey identifies an expression by its token stream rather than its
+// text, so 3+4 and " 3 + 4 " share an entry, together with the settings
+// that change how those tokens evaluate, MaxDepth included, since a hit
+// must fail as the expression would. It reports false for expressions
+// whose result can change between evaluations: those that read or assign
+// variables or call registered functions or ones such as rand and ans.
+// Nothing is cached with Numbers set, whose settings the key cannot hold.
+func (c *Calculator) cacheKey(tokens []Token) (string, bool) {
+	if c.Numbers != nil {
+		return "", false
+	}
+	for i, t := range tokens {
+		if t.Type != IDENTIFIER {
+			continue
+		}
+		if i+1 < len(tokens) && tokens[i+1].Type == LPAREN {
+			f, ok := c.functions[t.Value]
+			if !ok || f.registered || f.volatile {
+				return "", false
+			}
+			continue
+		}
+		if _, ok := c.constants[t.Value]; !ok {
+			return "", false
+		}
+	}
+	return fmt.Sprintf("%v %v %v %v %v %v %v %v", c.Complex, c.Rational, c.Overflow, c.Epsilon, c.Rounding, c.NaN, c.MaxDepth, Tokens(tokens)), true
+}
+
+// cached returns the cached result for key, if there is one.
+func (c *Calculator) cached(key string) (Value, bool) {
+	if c.cache == nil {
+		return Value{}, false
+	}
+	e, ok := c.cache.entries[key]
+	if !ok {
+		return Value{}, false
+	}
+	c.cache.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).value, true
+}
+
+// store caches v under key, evicting the least recently used entry once
+// there are CacheSize of them.
+func (c *Calculator) store(key string, v Value) {
+	if c.CacheSize <= 0 {
+		return
+	}
+	if c.cache == nil {
+		c.cache = &resultCache{entries: make(map[string]*list.Element), order: list.New()}
+	}
+	for c.cache.order.Len() >= c.CacheSize {
+		oldest := c.cache.order.Back()
+		c.cache.order.Remove(oldest)
+		delete(c.cache.entries, oldest.Value.(*cacheEntry).key)
+	}
+	c.cache.entries[key] = c.cache.order.PushFront(&cacheEntry{key: key, value: v})
+}
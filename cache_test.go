@@ -0,0 +1,98 @@
+package main
+
+import "testing"
+
+// cacheLen returns the number of results c has cached.
+func cacheLen(c *Calculator) int {
+	if c.cache == nil {
+		return 0
+	}
+	return c.cache.order.Len()
+}
+
+func TestCacheNormalizesWhitespace(t *testing.T) {
+	c := NewCalculator()
+	c.CacheSize = 10
+	checkEval(t, c, []evalCase{{"3+4", "7"}, {" 3 + 4 ", "7"}, {"3 +\t4", "7"}})
+	if n := cacheLen(c); n != 1 {
+		t.Errorf("%d cache entries, want 1 shared by 3+4 and \" 3 + 4 \"", n)
+	}
+	checkEval(t, c, []evalCase{{"4 + 3", "7"}})
+	if n := cacheLen(c); n != 2 {
+		t.Errorf("%d cache entries, want 2", n)
+	}
+}
+
+func TestCacheSkipsImpureExpressions(t *testing.T) {
+	c := NewCalculator()
+	c.CacheSize = 10
+	checkEval(t, c, []evalCase{
+		{"x = 1", "1"},
+		{"x + 1", "2"},
+		{"x = 5", "5"},
+		{"x + 1", "6"},
+	})
+	if _, err := c.Evaluate("rand() + randint(1, 6)"); err != nil {
+		t.Fatal(err)
+	}
+	if n := cacheLen(c); n != 0 {
+		t.Errorf("%d cache entries, want none", n)
+	}
+	checkEval(t, c, []evalCase{{"pi * 2", "6.283185307179586"}, {"sqrt(16)", "4"}})
+	if n := cacheLen(c); n != 2 {
+		t.Errorf("%d cache entries, want 2 for the constant and pure function", n)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCalculator()
+	c.CacheSize = 2
+	checkEval(t, c, []evalCase{{"1 + 1", "2"}, {"2 + 2", "4"}, {"1 + 1", "2"}, {"3 + 3", "6"}})
+	if n := cacheLen(c); n != 2 {
+		t.Fatalf("%d cache entries, want 2", n)
+	}
+	for input, want := range map[string]bool{"1 + 1": true, "2 + 2": false, "3 + 3": true} {
+		tokens, err := c.tokenize(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, _ := c.cacheKey(tokens)
+		if _, ok := c.cached(key); ok != want {
+			t.Errorf("%s cached: %v, want %v", input, ok, want)
+		}
+	}
+}
+
+func TestCacheKeepsRoundingModesApart(t *testing.T) {
+	c := NewCalculator()
+	c.CacheSize = 10
+	checkEval(t, c, []evalCase{{"fixed(2.5, 0)", "3"}})
+	c.Rounding = RoundHalfEven
+	checkEval(t, c, []evalCase{{"fixed(2.5, 0)", "2"}})
+	c.Rounding = RoundHalfUp
+	checkEval(t, c, []evalCase{{"fixed(2.5, 0)", "3"}})
+	if n := cacheLen(c); n != 2 {
+		t.Errorf("%d cache entries, want one per rounding mode", n)
+	}
+}
+
+func TestCacheKeepsNaNPoliciesApart(t *testing.T) {
+	c := NewCalculator()
+	c.CacheSize = 10
+	checkEvalError(t, c, "ln(-1)", "result is not a number")
+	c.NaN = NaNPropagate
+	checkEval(t, c, []evalCase{{"ln(-1)", "NaN"}})
+	c.NaN = NaNError
+	checkEvalError(t, c, "ln(-1)", "result is not a number")
+}
+
+func TestCacheKeepsMaxDepth(t *testing.T) {
+	c := NewCalculator()
+	c.CacheSize = 10
+	checkEval(t, c, []evalCase{{"((((((1))))))", "1"}})
+	// A cached result does not bypass a tighter limit.
+	c.MaxDepth = 5
+	checkEvalError(t, c, "((((((1))))))", "expression nested too deeply")
+	c.MaxDepth = DefaultMaxDepth
+	checkEval(t, c, []evalCase{{"((((((1))))))", "1"}})
+}
@@ -0,0 +1,1111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// operator is an operator known to a Calculator. Operators are stored in
+// a slice and referenced by id from their tokens, so the evaluator
+// dispatches by index instead of looking symbols up in a map.
+type operator struct {
+	symbol     string
+	precedence int
+	rightAssoc bool
+	// unary operators are prefix operators such as negation and use
+	// applyUnary instead of apply.
+	unary bool
+	// assign marks =, which the evaluator handles itself.
+	assign bool
+	// sequence marks the comma operator, whose value is its right operand
+	// whatever the kinds of the two.
+	sequence bool
+	// boolean marks comparisons, whose 1 or 0 result is a truth value.
+	boolean bool
+	// member marks in, whose right operand is a set rather than a number.
+	member     bool
+	apply      func(a, b float64) (float64, error)
+	applyUnary func(x float64) (float64, error)
+}
+
+// Operator precedence levels, loosest first.
+const (
+	precSequence = iota + 1 // ,
+	precAssign              // =
+	precEqual               // == != <>
+	precCompare             // < <= > >= in
+	precBitOr               // |
+	precBitAnd              // &
+	precShift               // << >>
+	precMinMax              // min max
+	precAdd                 // + - +% -%
+	precMul                 // * /
+	precUnary               // prefix - +
+	precPow                 // ^
+	precRadical             // prefix √ ∛
+)
+
+// OverflowMode selects what happens when an operation on finite operands
+// produces a result too large for a float64.
+type OverflowMode int
+
+const (
+	// OverflowIEEE lets the result become +Inf or -Inf, as IEEE 754 does.
+	OverflowIEEE OverflowMode = iota
+	// OverflowError fails the evaluation with ErrOverflow.
+	OverflowError
+	// OverflowSaturate clamps the result to math.MaxFloat64 (or its
+	// negation), so 1e308 * 10 evaluates to 1.7976931348623157e+308.
+	OverflowSaturate
+)
+
+// NaNPolicy selects what happens when a function or prefix operator given
+// numbers returns NaN, as ln(-1), sqrt(-1) and √-1 do.
+type NaNPolicy int
+
+const (
+	// NaNError fails the evaluation with ErrNaN, naming the function and
+	// its arguments.
+	NaNError NaNPolicy = iota
+	// NaNPropagate lets NaN be the result, as IEEE 754 does.
+	NaNPropagate
+)
+
+// ParseNaNPolicy maps the names "error" and "propagate" to their
+// NaNPolicy.
+func ParseNaNPolicy(name string) (NaNPolicy, error) {
+	switch name {
+	case "error":
+		return NaNError, nil
+	case "propagate":
+		return NaNPropagate, nil
+	}
+	return 0, fmt.Errorf("unknown NaN policy %q, want error or propagate", name)
+}
+
+// ImplicitMultiply is a set of the juxtapositions that imply
+// multiplication.
+type ImplicitMultiply int
+
+const (
+	// ImplicitNumberParen allows a number before a parenthesis, 2(3).
+	ImplicitNumberParen ImplicitMultiply = 1 << iota
+	// ImplicitParenParen allows a parenthesis after a parenthesis, (1)(2).
+	ImplicitParenParen
+	// ImplicitNumberIdent allows a number before an identifier, 2x, 2 pi
+	// or 2sqrt(4).
+	ImplicitNumberIdent
+
+	// ImplicitAll is the default: every juxtaposition multiplies.
+	ImplicitAll = ImplicitNumberParen | ImplicitParenParen | ImplicitNumberIdent
+)
+
+// ParseImplicitMultiply maps a comma-separated list of number-paren,
+// paren-paren and number-ident, or all or none, to an ImplicitMultiply.
+func ParseImplicitMultiply(list string) (ImplicitMultiply, error) {
+	var set ImplicitMultiply
+	for _, name := range strings.Split(list, ",") {
+		switch strings.TrimSpace(name) {
+		case "all":
+			set |= ImplicitAll
+		case "none":
+		case "number-paren":
+			set |= ImplicitNumberParen
+		case "paren-paren":
+			set |= ImplicitParenParen
+		case "number-ident":
+			set |= ImplicitNumberIdent
+		default:
+			return 0, fmt.Errorf("unknown implicit multiplication %q, want number-paren, paren-paren, number-ident, all or none", name)
+		}
+	}
+	return set, nil
+}
+
+// DefaultPolicy selects which inputs Evaluate answers with
+// Calculator.Default instead of an error.
+type DefaultPolicy int
+
+const (
+	// DefaultNone rejects empty and invalid input, as usual.
+	DefaultNone DefaultPolicy = iota
+	// DefaultEmpty answers empty or blank input with Default but still
+	// rejects invalid input.
+	DefaultEmpty
+	// DefaultInvalid answers empty input and input that does not parse,
+	// such as 1 + or (2, with Default. Expressions that parse but fail to
+	// evaluate, such as 1/0 or unknown(1), and input over the MaxLength,
+	// MaxTokens or MaxLiteralLength limits are still errors.
+	DefaultInvalid
+)
+
+// ErrOverflow is returned in OverflowError mode.
+var ErrOverflow = errors.New("numeric overflow")
+
+// ErrNaN is returned with NaNError for a function or prefix operator whose
+// result is NaN.
+var ErrNaN = errors.New("result is not a number")
+
+// ErrInputTooLong is returned for expressions longer than MaxLength.
+var ErrInputTooLong = errors.New("expression too long")
+
+// ErrTooManyTokens is returned for expressions of more than MaxTokens
+// tokens.
+var ErrTooManyTokens = errors.New("too many tokens")
+
+// ErrLiteralTooLong is returned for number literals longer than
+// MaxLiteralLength.
+var ErrLiteralTooLong = errors.New("number literal too long")
+
+// ErrUnterminatedComment is returned for a /* comment without its */.
+var ErrUnterminatedComment = errors.New("unterminated comment")
+
+// ErrMaxDepthExceeded is returned for expressions nested deeper than
+// MaxDepth.
+var ErrMaxDepthExceeded = errors.New("expression nested too deeply")
+
+// ErrCanceled is returned by EvaluateContext when its context is done
+// before the evaluation finishes. The error also wraps the context's, so
+// errors.Is reports context.DeadlineExceeded after a timeout.
+var ErrCanceled = errors.New("evaluation canceled")
+
+// PositionError is an error about the character at a rune index of the
+// expression. Its message is that of the error it wraps, which already
+// names the position.
+type PositionError struct {
+	Pos int
+	Err error
+}
+
+func (e *PositionError) Error() string { return e.Err.Error() }
+
+func (e *PositionError) Unwrap() error { return e.Err }
+
+// errorAt is fmt.Errorf for an error about position pos.
+func errorAt(pos int, format string, args ...interface{}) error {
+	return &PositionError{Pos: pos, Err: fmt.Errorf(format, args...)}
+}
+
+// ErrInternal is wrapped by the error returned when evaluation panics,
+// which is a bug in the calculator or in a registered function.
+var ErrInternal = errors.New("internal error")
+
+// recoverPanic turns a panic into an ErrInternal error in *err. The public
+// evaluation methods defer it so that no panic reaches their caller.
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("%w: panic: %v", ErrInternal, r)
+	}
+}
+
+// DefaultMaxLiteralLength is the MaxLiteralLength of a new Calculator. It
+// is far more digits than a float64 can hold.
+const DefaultMaxLiteralLength = 1000
+
+// DefaultMaxDepth is the MaxDepth of a new Calculator: deeper than any
+// expression written by hand, and shallow enough that the recursive walks
+// of Explain, Derivative and Simplify stay cheap.
+const DefaultMaxDepth = 10000
+
+// ParseOverflowMode maps the names "ieee", "error" and "saturate" to their
+// OverflowMode.
+func ParseOverflowMode(name string) (OverflowMode, error) {
+	switch name {
+	case "ieee":
+		return OverflowIEEE, nil
+	case "error":
+		return OverflowError, nil
+	case "saturate":
+		return OverflowSaturate, nil
+	}
+	return 0, fmt.Errorf("unknown overflow mode %q", name)
+}
+
+// Calculator holds the operator and function tables used to evaluate
+// expressions.
+type Calculator struct {
+	// Overflow selects how results that exceed the float64 range are
+	// handled. Operations that already have an infinite operand, such as
+	// inf + 1, are not overflows and always yield Inf.
+	Overflow OverflowMode
+
+	// NaN selects how a function returning NaN for arguments that are
+	// not NaN themselves is handled. The default, NaNError, makes ln(-1)
+	// an error; NaN arguments, such as inf - inf, always give NaN.
+	NaN NaNPolicy
+
+	// MaxLength caps the length of an expression in characters; longer
+	// input is rejected with ErrInputTooLong before it is tokenized. Zero
+	// means no limit.
+	MaxLength int
+
+	// MaxTokens caps the number of tokens an expression may have,
+	// counting implied multiplications and expanded macros, which bounds
+	// the work after tokenizing however the input is spaced. Zero means no
+	// limit.
+	MaxTokens int
+
+	// Trace, when set, receives a line of JSON for every operator and
+	// function applied, giving the operands, the result and the stack
+	// afterwards. Complex and rational modes are not traced.
+	Trace io.Writer
+
+	// Complex evaluates in complex numbers, so sqrt(-1) is i. The constant
+	// i is the imaginary unit unless a variable named i shadows it, and the
+	// bitwise operators and string functions are unavailable.
+	Complex bool
+
+	// Rational evaluates exactly in fractions, so 1/3 + 1/6 is 1/2 and
+	// results print in lowest terms. Only arithmetic, comparisons, min, max
+	// and assignment are available, ^ needs an integer exponent, and the
+	// constants have no value. Complex takes precedence.
+	Rational bool
+
+	// Numbers, when set, evaluates in a NumberSystem such as a decimal
+	// type rather than in float64, so its 0.1 + 0.2 == 0.3 can hold
+	// exactly. As in rational mode only arithmetic, comparisons, min, max
+	// and assignment are available, and ^ only if the system implements
+	// NumberPower. Complex and Rational take precedence.
+	Numbers NumberSystem
+
+	// Epsilon is the tolerance of the comparisons. Zero, the default,
+	// compares exactly; otherwise a and b are equal when |a-b| is at most
+	// Epsilon times the larger of 1, |a| and |b|, so 0.1+0.2 == 0.3 holds
+	// with an Epsilon of 1e-9.
+	Epsilon float64
+
+	// UnicodeDigits accepts decimal digits from scripts other than ASCII
+	// in number literals, so the Arabic-Indic ٤٢ reads as 42. The digits of
+	// one literal must all come from the same script.
+	UnicodeDigits bool
+
+	// SignedLiterals makes a prefix - or + written right before a number
+	// part of the number, so -2 ^ 2 is (-2) ^ 2 = 4 rather than -4. The
+	// sign is taken only where it is prefix, as in 3 * -5 but not 3 - 5 or
+	// 3 -5, and only with no space before the digits: - 5 is a negation.
+	SignedLiterals bool
+
+	// Rounding is how fixed and --currency round ties and the digits they
+	// drop; the default is RoundHalfUp.
+	Rounding RoundingMode
+
+	// InputBase, when set to a base from 2 to 36 other than 10, reads
+	// integer literals in that base, so with 16 FF + 1 is 256. Names made
+	// only of digits of the base are numbers too, so in base 16 e is 14. A
+	// 0x, 0o or 0b prefix selects another base for one literal unless its
+	// letter is a digit of InputBase, and fractions are an error.
+	InputBase int
+
+	// CacheSize, when positive, makes Evaluate remember the results of up
+	// to that many expressions that use no variables and no random or
+	// registered functions. Entries are keyed by token stream, so
+	// expressions differing only in spacing share one.
+	CacheSize int
+
+	// UseDefault selects which input Evaluate answers with Default
+	// instead of an error; see DefaultPolicy. The zero value, DefaultNone,
+	// never does.
+	UseDefault DefaultPolicy
+	Default    float64
+
+	// Implicit selects which juxtapositions imply multiplication; the
+	// others are errors. NewCalculator sets ImplicitAll.
+	Implicit ImplicitMultiply
+
+	// MaxLiteralLength caps the digits (and decimal point) of a number
+	// literal, not counting any exponent. Zero means no limit.
+	MaxLiteralLength int
+
+	// MaxDepth caps how deeply an expression may nest: the brackets,
+	// calls and operators open at once while it is parsed, and the
+	// intermediate values held at once while it is evaluated. Deeper
+	// expressions fail with ErrMaxDepthExceeded. Zero means no limit.
+	MaxDepth int
+
+	// History holds earlier results, oldest first, for ans: ans(-1) is the
+	// last one and ans(-2) the one before. The REPL appends every result
+	// it prints; library callers manage it themselves.
+	History []Value
+
+	operators   []operator
+	operatorIDs map[string]int
+	unaryIDs    map[string]int
+	maxOpLen    int
+	functions   map[string]function
+	aliases     map[string]string
+	constants   map[string]float64
+	vars        map[string]Value
+	macros      map[string]macro
+	exprs       map[string][]Token
+	rng         *rand.Rand
+	cache       *resultCache
+	// labels collects the labelled values during EvaluateLabeled; while
+	// it is nil, labels are dropped as the input is tokenized.
+	labels map[string]Value
+	// ctx is the context of EvaluateContext while it runs, which
+	// checkStep watches.
+	ctx context.Context
+	// envs are the environments of EvaluateWith while it runs.
+	envs []map[string]float64
+
+	// binarySymbols and prefixSymbols are the symbols input may use for
+	// the operators, which AliasOperator and RemoveOperator change;
+	// operatorIDs and unaryIDs keep the builtin symbols for building
+	// expressions internally.
+	binarySymbols map[string]int
+	prefixSymbols map[string]int
+}
+
+// NewCalculator returns a Calculator with the builtin operators and
+// functions registered.
+func NewCalculator() *Calculator {
+	c := &Calculator{
+		MaxLiteralLength: DefaultMaxLiteralLength,
+		MaxDepth:         DefaultMaxDepth,
+		Implicit:         ImplicitAll,
+		operatorIDs:      make(map[string]int),
+		unaryIDs:         make(map[string]int),
+		binarySymbols:    make(map[string]int),
+		prefixSymbols:    make(map[string]int),
+		functions:        make(map[string]function, len(functions)),
+		aliases:          make(map[string]string),
+		constants:        make(map[string]float64, len(constants)),
+		vars:             make(map[string]Value),
+		macros:           make(map[string]macro),
+		exprs:            make(map[string][]Token),
+		rng:              rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	c.registerOperator(operator{symbol: "+", precedence: precAdd, apply: func(a, b float64) (float64, error) {
+		return a + b, nil
+	}})
+	c.registerOperator(operator{symbol: "-", precedence: precAdd, apply: func(a, b float64) (float64, error) {
+		return a - b, nil
+	}})
+	c.registerOperator(operator{symbol: "*", precedence: precMul, apply: func(a, b float64) (float64, error) {
+		return a * b, nil
+	}})
+	c.registerOperator(operator{symbol: "/", precedence: precMul, apply: func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return a / b, nil
+	}})
+	// a +% b is a increased by b percent and a -% b a decreased by it.
+	// They bind like + and -, left to right, so 100 +% 10 +% 5 applies
+	// both changes in turn and is 115.5.
+	c.registerOperator(operator{symbol: "+%", precedence: precAdd, apply: func(a, b float64) (float64, error) {
+		return a + a*b/100, nil
+	}})
+	c.registerOperator(operator{symbol: "-%", precedence: precAdd, apply: func(a, b float64) (float64, error) {
+		return a - a*b/100, nil
+	}})
+	// Negation binds tighter than * but looser than ^, so -2^2 is -4.
+	c.registerOperator(operator{symbol: "-", precedence: precUnary, unary: true, applyUnary: func(x float64) (float64, error) {
+		return -x, nil
+	}})
+	c.registerOperator(operator{symbol: "+", precedence: precUnary, unary: true, applyUnary: func(x float64) (float64, error) {
+		return x, nil
+	}})
+	c.registerOperator(operator{symbol: "^", precedence: precPow, rightAssoc: true, apply: pow})
+	// The radicals bind tightest of all, so √9 + 16 is 3 + 16 and √9^2 is
+	// (√9)^2; parenthesize to take the root of more, as in √(9 + 16).
+	c.registerOperator(operator{symbol: "√", precedence: precRadical, unary: true, applyUnary: func(x float64) (float64, error) {
+		return math.Sqrt(x), nil
+	}})
+	c.registerOperator(operator{symbol: "∛", precedence: precRadical, unary: true, applyUnary: func(x float64) (float64, error) {
+		return math.Cbrt(x), nil
+	}})
+	// Assignment is right-associative and binds loosest, so a = b = 5
+	// assigns 5 to b and then to a.
+	c.registerOperator(operator{symbol: "=", precedence: precAssign, rightAssoc: true, assign: true})
+	// A comma outside the arguments of a call evaluates its operands in
+	// turn and keeps the last, binding looser still so that x = 3, x + 1
+	// is 4. The lexer always reads a comma as COMMA; toPostfix makes it
+	// this operator.
+	c.registerOperator(operator{symbol: ",", precedence: precSequence, sequence: true})
+	// The keyword operators min and max bind looser than arithmetic, so
+	// 1 + 2 max 3 is 3, and chain left to right.
+	c.registerOperator(operator{symbol: "min", precedence: precMinMax, apply: func(a, b float64) (float64, error) {
+		return math.Min(a, b), nil
+	}})
+	c.registerOperator(operator{symbol: "max", precedence: precMinMax, apply: func(a, b float64) (float64, error) {
+		return math.Max(a, b), nil
+	}})
+	// Comparisons yield 1 for true and 0 for false, and numbers equal
+	// within Epsilon are neither less nor greater than each other.
+	c.registerOperator(operator{symbol: "==", precedence: precEqual, boolean: true, apply: func(a, b float64) (float64, error) {
+		return truth(c.equal(a, b)), nil
+	}})
+	notEqual := func(a, b float64) (float64, error) {
+		return truth(!c.equal(a, b)), nil
+	}
+	c.registerOperator(operator{symbol: "!=", precedence: precEqual, boolean: true, apply: notEqual})
+	// <> is != as SQL and Pascal write it.
+	c.registerOperator(operator{symbol: "<>", precedence: precEqual, boolean: true, apply: notEqual})
+	c.registerOperator(operator{symbol: "<", precedence: precCompare, boolean: true, apply: func(a, b float64) (float64, error) {
+		return truth(a < b && !c.equal(a, b)), nil
+	}})
+	c.registerOperator(operator{symbol: "<=", precedence: precCompare, boolean: true, apply: func(a, b float64) (float64, error) {
+		return truth(a < b || c.equal(a, b)), nil
+	}})
+	c.registerOperator(operator{symbol: ">", precedence: precCompare, boolean: true, apply: func(a, b float64) (float64, error) {
+		return truth(a > b && !c.equal(a, b)), nil
+	}})
+	c.registerOperator(operator{symbol: ">=", precedence: precCompare, boolean: true, apply: func(a, b float64) (float64, error) {
+		return truth(a > b || c.equal(a, b)), nil
+	}})
+	// x in {a, b, c} is 1 if x == a, x == b or x == c.
+	c.registerOperator(operator{symbol: "in", precedence: precCompare, boolean: true, member: true})
+	c.registerOperator(operator{symbol: "&", precedence: precBitAnd, apply: bitwise("&", func(a, b int64) (int64, error) {
+		return a & b, nil
+	})})
+	c.registerOperator(operator{symbol: "|", precedence: precBitOr, apply: bitwise("|", func(a, b int64) (int64, error) {
+		return a | b, nil
+	})})
+	c.registerOperator(operator{symbol: "<<", precedence: precShift, apply: bitwise("<<", func(a, b int64) (int64, error) {
+		if b < 0 {
+			return 0, fmt.Errorf("negative shift count %d", b)
+		}
+		return a << uint64(b), nil
+	})})
+	c.registerOperator(operator{symbol: ">>", precedence: precShift, apply: bitwise(">>", func(a, b int64) (int64, error) {
+		if b < 0 {
+			return 0, fmt.Errorf("negative shift count %d", b)
+		}
+		return a >> uint64(b), nil
+	})})
+
+	for name, f := range functions {
+		c.functions[name] = f
+	}
+	for name, v := range constants {
+		c.constants[name] = v
+	}
+	for name, v := range physicalConstants {
+		c.constants["phys."+name] = v
+	}
+
+	// The random functions draw from c.rng rather than the global source
+	// so that Seed makes a Calculator reproducible.
+	c.functions["rand"] = function{arity: 0, volatile: true, fn: func(args []float64) (float64, error) {
+		return c.rng.Float64(), nil
+	}}
+	c.functions["randint"] = function{arity: 2, volatile: true, fn: func(args []float64) (float64, error) {
+		lo, hi := math.Ceil(args[0]), math.Floor(args[1])
+		if lo > hi {
+			return 0, fmt.Errorf("empty range [%v, %v]", args[0], args[1])
+		}
+		return lo + float64(c.rng.Int63n(int64(hi-lo)+1)), nil
+	}}
+	// fixed(x, n) rounds x to n decimal places, so fixed(3.14159, 2) is
+	// 3.14, by the Calculator's rounding mode.
+	c.functions["fixed"] = function{arity: 2, fn: c.fixed}
+	// ans(-n) is the nth most recent result in History.
+	c.functions["ans"] = function{arity: 1, volatile: true, valueFn: func(args []Value) (Value, error) {
+		n, err := args[0].number("argument 1")
+		if err != nil {
+			return Value{}, err
+		}
+		if n != math.Trunc(n) || n >= 0 {
+			return Value{}, fmt.Errorf("index must be a negative integer, got %v", n)
+		}
+		if -n > float64(len(c.History)) {
+			return Value{}, fmt.Errorf("index %v is out of range, there are %d results", n, len(c.History))
+		}
+		return c.History[len(c.History)+int(n)], nil
+	}}
+	for name, symbol := range operatorFunctions {
+		op := c.operators[c.operatorIDs[symbol]]
+		c.functions[name] = function{arity: 2, fn: func(args []float64) (float64, error) {
+			return op.apply(args[0], args[1])
+		}}
+	}
+
+	return c
+}
+
+// operatorFunctions are the arithmetic operators callable as functions,
+// so that add(3, 4) is 3 + 4. They share the operators' implementations,
+// errors included: div(1, 0) is a division by zero.
+var operatorFunctions = map[string]string{
+	"add": "+",
+	"sub": "-",
+	"mul": "*",
+	"div": "/",
+	"pow": "^",
+}
+
+// bitwise adapts an operator on 64-bit integers to float64 operands,
+// rejecting operands that are not integers rather than truncating them.
+func bitwise(symbol string, fn func(a, b int64) (int64, error)) func(a, b float64) (float64, error) {
+	return func(a, b float64) (float64, error) {
+		for _, v := range []float64{a, b} {
+			if v != math.Trunc(v) || math.IsInf(v, 0) {
+				return 0, fmt.Errorf("bitwise '%s' requires integer operands, got %v", symbol, v)
+			}
+			if v < math.MinInt64 || v >= math.MaxInt64 {
+				return 0, fmt.Errorf("bitwise '%s' operand %v overflows 64 bits", symbol, v)
+			}
+		}
+		result, err := fn(int64(a), int64(b))
+		return float64(result), err
+	}
+}
+
+// registerOperator adds an operator and returns the id its tokens carry.
+func (c *Calculator) registerOperator(op operator) int {
+	id := len(c.operators)
+	c.operators = append(c.operators, op)
+	if op.unary {
+		c.unaryIDs[op.symbol] = id
+		c.prefixSymbols[op.symbol] = id
+	} else {
+		c.operatorIDs[op.symbol] = id
+		c.binarySymbols[op.symbol] = id
+	}
+	if n := len([]rune(op.symbol)); n > c.maxOpLen {
+		c.maxOpLen = n
+	}
+	return id
+}
+
+// AliasOperator makes alias another symbol for the operator symbol, so
+// that after AliasOperator("×", "*") and AliasOperator(":", "/") 6 × 2 : 3
+// is 4. An alias of a symbol that is both binary and prefix, such as -,
+// is both too. symbol may be a builtin one that RemoveOperator removed,
+// so the two calls can come in either order. The alias is either a word,
+// like the keyword operators min and max, which is only possible for
+// binary operators, or made entirely of symbol characters: no letters,
+// digits, spaces, brackets, commas, quotes or dots. It must not already
+// be a symbol (or, for a word, a function or constant). Expressions are
+// still printed, by Explain and Postfix for example, with the builtin
+// symbols.
+func (c *Calculator) AliasOperator(alias, symbol string) error {
+	binary, isBinary := c.binarySymbols[symbol]
+	prefix, isPrefix := c.prefixSymbols[symbol]
+	if !isBinary && !isPrefix {
+		// A builtin symbol names its operator even once removed.
+		binary, isBinary = c.operatorIDs[symbol]
+		prefix, isPrefix = c.unaryIDs[symbol]
+	}
+	if !isBinary && !isPrefix {
+		return fmt.Errorf("unknown operator %q", symbol)
+	}
+	runes := []rune(alias)
+	if len(runes) == 0 {
+		return fmt.Errorf("invalid operator symbol %q", alias)
+	}
+	word := isIdentStart(runes[0])
+	for _, r := range runes {
+		if word != isIdentPart(r) || unicode.IsSpace(r) || strings.ContainsRune("(){},\".", r) {
+			return fmt.Errorf("invalid operator symbol %q", alias)
+		}
+	}
+	if strings.Contains(alias, "//") || strings.Contains(alias, "/*") {
+		return fmt.Errorf("invalid operator symbol %q: it would start a comment", alias)
+	}
+	if word {
+		if isPrefix {
+			return fmt.Errorf("invalid operator symbol %q: prefix operators cannot be words", alias)
+		}
+		_, isFunc := c.functions[alias]
+		_, isConst := c.constants[alias]
+		_, isMacro := c.macros[alias]
+		if isFunc || isConst || isMacro {
+			return fmt.Errorf("%w: %s", ErrBuiltinName, alias)
+		}
+	}
+	_, taken := c.binarySymbols[alias]
+	if _, ok := c.prefixSymbols[alias]; ok || taken {
+		return fmt.Errorf("operator %q already exists", alias)
+	}
+
+	if isBinary {
+		c.binarySymbols[alias] = binary
+	}
+	if isPrefix {
+		c.prefixSymbols[alias] = prefix
+	}
+	if len(runes) > c.maxOpLen {
+		c.maxOpLen = len(runes)
+	}
+	return nil
+}
+
+// RemoveOperator stops input from using symbol, in both its binary and
+// prefix forms, so that with * removed and × added by AliasOperator only
+// × multiplies. Other symbols for the same operator keep working, and
+// juxtaposition still multiplies as Implicit says.
+func (c *Calculator) RemoveOperator(symbol string) error {
+	_, isBinary := c.binarySymbols[symbol]
+	_, isPrefix := c.prefixSymbols[symbol]
+	if !isBinary && !isPrefix {
+		return fmt.Errorf("unknown operator %q", symbol)
+	}
+	delete(c.binarySymbols, symbol)
+	delete(c.prefixSymbols, symbol)
+	return nil
+}
+
+// pow is a^b. math.Pow already gets the sign right for a negative base
+// with an integer exponent, (-2)^3 = -8, but gives NaN for a fractional
+// one. pow takes the real root when the exponent is the reciprocal of an
+// odd integer, so (-8)^(1/3) = -2, and reports an error for any other
+// fractional exponent of a negative base.
+func pow(a, b float64) (float64, error) {
+	if a >= 0 || b == math.Trunc(b) || math.IsInf(b, 0) || math.IsNaN(b) {
+		return math.Pow(a, b), nil
+	}
+	n := math.Round(1 / b)
+	if math.Mod(n, 2) != 0 && math.Abs(1/b-n) < 1e-9 {
+		// One Newton step on r^m = -a cleans up the rounding in 1/m, so
+		// the root of a perfect power comes out exact.
+		m := math.Abs(n)
+		r := math.Pow(-a, 1/m)
+		if r != 0 && !math.IsInf(r, 0) {
+			r -= (math.Pow(r, m) + a) / (m * math.Pow(r, m-1))
+		}
+		if n < 0 {
+			r = 1 / r
+		}
+		return -r, nil
+	}
+	return 0, fmt.Errorf("negative base %v with fractional exponent %v has no real result", a, b)
+}
+
+// equal compares a and b within c.Epsilon.
+func (c *Calculator) equal(a, b float64) bool {
+	if a == b {
+		return true
+	}
+	scale := math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+	return math.Abs(a-b) <= c.Epsilon*scale
+}
+
+// chainable reports whether op is a comparison that chains with others of
+// its precedence, as < does in 1 < x < 10. in does not: its right operand
+// is a set.
+func chainable(op operator) bool {
+	return op.boolean && !op.member
+}
+
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// matchOperator returns the id and length of the longest operator symbol
+// starting at runes[i], looking only at prefix operators when unary is set
+// and only at binary ones otherwise.
+func (c *Calculator) matchOperator(runes []rune, i int, unary bool) (int, int, bool) {
+	ids := c.binarySymbols
+	if unary {
+		ids = c.prefixSymbols
+	}
+	for n := c.maxOpLen; n > 0; n-- {
+		if i+n > len(runes) {
+			continue
+		}
+		if id, ok := ids[string(runes[i:i+n])]; ok {
+			return id, n, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Precedence reports the precedence of the operator op and whether it is
+// one. Higher values bind tighter: , is 1 and √ the highest. For a symbol
+// that is both binary and prefix, such as -, it is the binary precedence;
+// the prefix form always binds tighter than * and /.
+func (c *Calculator) Precedence(op string) (int, bool) {
+	id, ok := c.binarySymbols[op]
+	if !ok {
+		id, ok = c.prefixSymbols[op]
+	}
+	if !ok {
+		return 0, false
+	}
+	return c.operators[id].precedence, true
+}
+
+// lookup returns the value of a variable or constant, searching the
+// environments of EvaluateWith after the variables.
+func (c *Calculator) lookup(name string) (Value, error) {
+	if v, ok := c.vars[name]; ok {
+		return v, nil
+	}
+	for _, env := range c.envs {
+		if num, ok := env[name]; ok {
+			return Value{Num: num}, nil
+		}
+	}
+	if num, ok := c.constants[name]; ok {
+		return Value{Num: num}, nil
+	}
+	if _, ok := c.exprs[name]; ok {
+		return Value{}, fmt.Errorf("%s holds an expression, use eval(%s) to evaluate it", name, name)
+	}
+	return Value{}, fmt.Errorf("unknown identifier '%s'", name)
+}
+
+// assign stores v in the variable named by target, which must be an
+// assignment target that is not a constant.
+func (c *Calculator) assign(target, v Value) error {
+	if target.Kind != targetValue {
+		return fmt.Errorf("cannot assign to %v, the left side of = must be a variable", target)
+	}
+	if _, ok := c.constants[target.Str]; ok {
+		return fmt.Errorf("cannot assign to constant %s", target.Str)
+	}
+	if v.Kind == targetValue {
+		return fmt.Errorf("cannot assign %s before it has a value", v.Str)
+	}
+	v.Format = FormatDefault
+	c.vars[target.Str] = v
+	delete(c.exprs, target.Str)
+	return nil
+}
+
+// Seed reseeds the random number source used by rand and randint. Without
+// a call to Seed a Calculator is seeded from the clock, so its random
+// results differ from run to run.
+func (c *Calculator) Seed(seed int64) {
+	c.rng = rand.New(rand.NewSource(seed))
+}
+
+// checkOverflow applies the Overflow mode to the result of an operation on
+// operands.
+func (c *Calculator) checkOverflow(result float64, operands ...float64) (float64, error) {
+	if !math.IsInf(result, 0) || c.Overflow == OverflowIEEE {
+		return result, nil
+	}
+	for _, v := range operands {
+		if math.IsInf(v, 0) {
+			return result, nil
+		}
+	}
+	if c.Overflow == OverflowError {
+		return 0, ErrOverflow
+	}
+	return math.Copysign(math.MaxFloat64, result), nil
+}
+
+// Evaluate evaluates an infix expression whose result may be a number or
+// a string.
+func (c *Calculator) Evaluate(input string) (_ Value, err error) {
+	defer recoverPanic(&err)
+
+	if c.UseDefault != DefaultNone && strings.TrimSpace(input) == "" {
+		return Value{Num: c.Default}, nil
+	}
+	if c.UseDefault == DefaultInvalid {
+		_, err := c.parse(input)
+		limit := errors.Is(err, ErrInputTooLong) || errors.Is(err, ErrTooManyTokens) || errors.Is(err, ErrLiteralTooLong)
+		if err != nil && !limit {
+			return Value{Num: c.Default}, nil
+		}
+	}
+
+	tokens, err := c.tokenize(input)
+	if err != nil {
+		return Value{}, err
+	}
+
+	key, pure := "", false
+	if c.CacheSize > 0 && c.Trace == nil {
+		key, pure = c.cacheKey(tokens)
+		if v, ok := c.cached(key); ok && pure {
+			return v, nil
+		}
+	}
+
+	var v Value
+	var ok bool
+	if c.Trace == nil && c.ctx == nil {
+		v, ok, err = c.evaluateFlat(tokens)
+	}
+	if !ok {
+		var postfix []Token
+		postfix, err = c.toPostfix(tokens)
+		if err != nil {
+			return Value{}, err
+		}
+		v, err = c.evaluatePostfix(postfix)
+	}
+	if err == nil && pure {
+		c.store(key, v)
+	}
+	return v, err
+}
+
+// EvaluateContext is Evaluate that gives up with an ErrCanceled error once
+// ctx is done, so that a deadline bounds how long input may take. The
+// context is checked before each token is parsed and evaluated, the
+// terms of a series included; a single function call already running is
+// not interrupted.
+func (c *Calculator) EvaluateContext(ctx context.Context, input string) (Value, error) {
+	defer func(previous context.Context) { c.ctx = previous }(c.ctx)
+	c.ctx = ctx
+	if err := c.checkStep(0, Token{}); err != nil {
+		// A context already done gives up before a cached result.
+		return Value{}, err
+	}
+	return c.Evaluate(input)
+}
+
+// EvaluateWith is Evaluate with variables also read from envs, an ordered
+// list of environments such as user settings before defaults. A name is
+// looked up first among the Calculator's variables, including any input
+// assigns, then in each of envs in turn, the first that defines it
+// winning. So with envs {rate: 7} and {rate: 5, fee: 2}, rate + fee is 9.
+// The environments are only read: an assignment sets a variable of the
+// Calculator as usual, which then comes before envs here and in later
+// calls. A constant cannot be redefined by an environment.
+func (c *Calculator) EvaluateWith(input string, envs ...map[string]float64) (Value, error) {
+	for i, env := range envs {
+		for name := range env {
+			if _, ok := c.constants[name]; ok {
+				return Value{}, fmt.Errorf("environment %d: cannot redefine constant %s", i+1, name)
+			}
+		}
+	}
+	defer func(previous []map[string]float64) { c.envs = previous }(c.envs)
+	c.envs = envs
+	return c.Evaluate(input)
+}
+
+// EvaluateLabeled is Evaluate that also returns the values of the labelled
+// subexpressions of input. A label is a string after a colon, :"name",
+// following an operand, and binds tighter than any operator: in
+// (a + b):"sum" * c it names a + b, and in a + b:"b" only b. The returned
+// map holds each label's value; a label evaluated more than once, as in
+// the term of a series, holds its last value. Evaluate and the other
+// methods ignore labels. Labels are not supported in complex and rational
+// modes or with Numbers set.
+func (c *Calculator) EvaluateLabeled(input string) (_ Value, _ map[string]Value, err error) {
+	defer recoverPanic(&err)
+	defer func() { c.labels = nil }()
+
+	if c.Complex || c.Rational || c.Numbers != nil {
+		return Value{}, nil, fmt.Errorf("labels are not supported in complex or rational mode or with a number system")
+	}
+	c.labels = make(map[string]Value)
+	tokens, err := c.tokenize(input)
+	if err != nil {
+		return Value{}, nil, err
+	}
+	postfix, err := c.toPostfix(tokens)
+	if err != nil {
+		return Value{}, nil, err
+	}
+	v, err := c.evaluatePostfix(postfix)
+	if err != nil {
+		return Value{}, nil, err
+	}
+	return v, c.labels, nil
+}
+
+// Stats describes the work done to evaluate an expression.
+type Stats struct {
+	// Tokens is the number of tokens the expression was split into,
+	// including any implied multiplications.
+	Tokens int
+	// Operations is the number of operators and function calls applied,
+	// and of terms a series added up.
+	Operations int
+	// MaxStackDepth is the largest number of intermediate values held at
+	// once while evaluating.
+	MaxStackDepth int
+}
+
+// CalculateWithStats is Calculate, also reporting the work it took. For
+// 2 * (3 + 4) the stats are 7 tokens, 2 operations and a stack depth of 3.
+func (c *Calculator) CalculateWithStats(input string) (_ float64, _ Stats, err error) {
+	defer recoverPanic(&err)
+
+	var stats Stats
+
+	tokens, err := c.tokenize(input)
+	if err != nil {
+		return 0, stats, err
+	}
+	stats.Tokens = len(tokens)
+
+	postfix, err := c.toPostfix(tokens)
+	if err != nil {
+		return 0, stats, err
+	}
+
+	v, err := c.evaluate(postfix, &stats)
+	if err != nil {
+		return 0, stats, err
+	}
+	result, err := v.number("result")
+	return result, stats, err
+}
+
+// OperandCount returns how many number literals and identifiers input has
+// as operands, counting every occurrence: 2 * x + x is 3 and sin(x) + pi
+// is 2, as a function name is not an operand. It only tokenizes input, so
+// macros count as what they expand to, an assignment target such as the
+// x of x = 3 counts too, and input that does not parse, such as 1 +, is
+// not an error. Strings are not counted.
+func (c *Calculator) OperandCount(input string) (_ int, err error) {
+	defer recoverPanic(&err)
+
+	tokens, err := c.tokenize(input)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i, t := range tokens {
+		switch t.Type {
+		case NUMBER:
+			count++
+		case IDENTIFIER:
+			if i+1 < len(tokens) && tokens[i+1].Type == LPAREN {
+				continue
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// EstimateCost returns the number of operations evaluating input would
+// take, as CalculateWithStats counts them, without evaluating it, so
+// that a service can turn away expensive input first. Every operator and
+// function call is one operation, whatever the function does. A series
+// costs its bounds, and its term and the addition of it once per term;
+// bounds that are not constant, such as series(i, 1, n, i), are taken to
+// be the most terms a series may have. Bounds that are constant but
+// invalid are an error, as evaluating them would be.
+func (c *Calculator) EstimateCost(input string) (_ int, err error) {
+	defer recoverPanic(&err)
+
+	root, err := c.parse(input)
+	if err != nil {
+		return 0, err
+	}
+	return c.cost(root)
+}
+
+// cost is EstimateCost for the expression n.
+func (c *Calculator) cost(n *Node) (int, error) {
+	total := 0
+	if n.Token.Type == OPERATOR || n.Token.Type == FUNCTION {
+		total = 1
+	}
+	for _, arg := range n.Args {
+		k, err := c.cost(arg)
+		if err != nil {
+			return 0, err
+		}
+		total += k
+	}
+	if n.Token.Body == nil {
+		return total, nil
+	}
+
+	terms := maxSeriesTerms
+	from, fromConst := numberValue(c.simplify(n.Args[1]))
+	to, toConst := numberValue(c.simplify(n.Args[2]))
+	if fromConst && toConst {
+		k, err := seriesTerms(from, to)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", n.Token.Value, err)
+		}
+		terms = k
+	}
+	body, err := c.buildAST(n.Token.Body)
+	if err != nil {
+		return 0, err
+	}
+	term, err := c.cost(body)
+	if err != nil {
+		return 0, err
+	}
+	// Each term is also added to the sum.
+	term++
+	if terms > (math.MaxInt-total)/term {
+		// Series nested in series can multiply past what an int holds.
+		return math.MaxInt, nil
+	}
+	return total + terms*term, nil
+}
+
+// Calculate evaluates an infix expression with a numeric result.
+func (c *Calculator) Calculate(input string) (float64, error) {
+	v, err := c.Evaluate(input)
+	if err != nil {
+		return 0, err
+	}
+	return v.number("result")
+}
+
+// MustCalculate is Calculate on a new Calculator that panics if the
+// expression fails, like regexp.MustCompile. It is meant for expressions
+// fixed in the program; never pass it untrusted input.
+func MustCalculate(input string) float64 {
+	result, err := NewCalculator().Calculate(input)
+	if err != nil {
+		panic(`calc: MustCalculate(` + strconv.Quote(input) + `): ` + err.Error())
+	}
+	return result
+}
+
+// Result is the outcome of one expression of a batch.
+type Result struct {
+	Value Value
+	Err   error
+}
+
+// EvaluateBatch evaluates exprs in order like a sheet of calculations:
+// variables from env are set first, and assignments in earlier expressions
+// are visible to later ones. A failing expression does not stop the batch;
+// its Result holds the error, and the returned error is the first such
+// failure. Afterwards env, if not nil, is updated with every numeric
+// variable, so it holds the final state of the sheet.
+func (c *Calculator) EvaluateBatch(exprs []string, env map[string]float64) ([]Result, error) {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := c.assign(Value{Kind: targetValue, Str: name}, Value{Num: env[name]}); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]Result, len(exprs))
+	var first error
+	for i, expr := range exprs {
+		v, err := c.Evaluate(expr)
+		results[i] = Result{Value: v, Err: err}
+		if err != nil && first == nil {
+			first = fmt.Errorf("expression %d: %w", i+1, err)
+		}
+	}
+
+	if env != nil {
+		for name, v := range c.vars {
+			if num, err := v.number(name); err == nil {
+				env[name] = num
+			}
+		}
+	}
+	return results, first
+}
@@ -0,0 +1,679 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOperatorTokensCarryIDs(t *testing.T) {
+	c := NewCalculator()
+	tokens, err := c.tokenize("1 + 2 * 3 - 4 / 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tok := range tokens {
+		if tok.Type != OPERATOR {
+			continue
+		}
+		if got := c.operators[tok.Op].symbol; got != tok.Value {
+			t.Errorf("token %v has id %d, which is operator %q", tok, tok.Op, got)
+		}
+	}
+	checkEval(t, c, []evalCase{{"1 + 2 * 3 - 4 / 2", "5"}})
+}
+
+// addChain returns 1 + 1 + ... with n terms.
+func addChain(n int) string {
+	return strings.TrimSuffix(strings.Repeat("1 + ", n), " + ")
+}
+
+// BenchmarkEvaluateAddChain measures operator dispatch over a long chain
+// of +: through Evaluate, and through the postfix evaluator alone, which
+// looks each operator up by the id its token carries.
+func BenchmarkEvaluateAddChain(b *testing.B) {
+	expr := addChain(100)
+	b.Run("Evaluate", func(b *testing.B) {
+		c := NewCalculator()
+		for i := 0; i < b.N; i++ {
+			if _, err := c.Evaluate(expr); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Postfix", func(b *testing.B) {
+		c := NewCalculator()
+		tokens, err := c.tokenize(expr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		postfix, err := c.toPostfix(tokens)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := c.evaluatePostfix(postfix); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestSeed(t *testing.T) {
+	sequence := func(seed int64) []string {
+		c := NewCalculator()
+		c.Seed(seed)
+		var values []string
+		for i := 0; i < 5; i++ {
+			for _, input := range []string{"rand()", "randint(1, 100)"} {
+				v, err := c.Evaluate(input)
+				if err != nil {
+					t.Fatal(err)
+				}
+				values = append(values, v.String())
+			}
+		}
+		return values
+	}
+	a, b := sequence(7), sequence(7)
+	if strings.Join(a, " ") != strings.Join(b, " ") {
+		t.Errorf("seed 7 gave %v, then %v", a, b)
+	}
+	if c := sequence(8); strings.Join(a, " ") == strings.Join(c, " ") {
+		t.Errorf("seeds 7 and 8 both gave %v", a)
+	}
+}
+
+func TestBitwiseRequiresIntegers(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"6 & 3", "2"},
+		{"6 | 3", "7"},
+		{"1 << 4", "16"},
+		{"16 >> 2", "4"},
+	})
+	for _, tc := range []struct {
+		input string
+		pos   int
+		want  string
+	}{
+		{"2.5 & 1", 4, "bitwise '&' requires integer operands, got 2.5 at position 4"},
+		{"1 | 0.5", 2, "bitwise '|' requires integer operands, got 0.5 at position 2"},
+		{"1.5 << 2", 4, "bitwise '<<' requires integer operands, got 1.5 at position 4"},
+		{"8 >> 0.25", 2, "bitwise '>>' requires integer operands, got 0.25 at position 2"},
+	} {
+		_, err := c.Evaluate(tc.input)
+		var perr *PositionError
+		if !errors.As(err, &perr) {
+			t.Errorf("Evaluate(%q) error = %v, want a PositionError", tc.input, err)
+			continue
+		}
+		if perr.Pos != tc.pos || err.Error() != tc.want {
+			t.Errorf("Evaluate(%q) error = %q at %d, want %q at %d", tc.input, err, perr.Pos, tc.want, tc.pos)
+		}
+	}
+}
+
+func TestCalculateWithStats(t *testing.T) {
+	c := NewCalculator()
+	for _, tc := range []struct {
+		input  string
+		result float64
+		stats  Stats
+	}{
+		{"2 * (3 + 4)", 14, Stats{Tokens: 7, Operations: 2, MaxStackDepth: 3}},
+		{"1 + 2 + 3", 6, Stats{Tokens: 5, Operations: 2, MaxStackDepth: 2}},
+		{"sqrt(16) * 3", 12, Stats{Tokens: 6, Operations: 2, MaxStackDepth: 2}},
+		{"42", 42, Stats{Tokens: 1, Operations: 0, MaxStackDepth: 1}},
+	} {
+		result, stats, err := c.CalculateWithStats(tc.input)
+		if err != nil {
+			t.Errorf("CalculateWithStats(%q): %v", tc.input, err)
+			continue
+		}
+		if result != tc.result || stats != tc.stats {
+			t.Errorf("CalculateWithStats(%q) = %v, %+v, want %v, %+v", tc.input, result, stats, tc.result, tc.stats)
+		}
+	}
+}
+
+func TestEpsilon(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"0.1 + 0.2 == 0.3", "0"},
+		{"0.1 + 0.2 != 0.3", "1"},
+		{"1 <= 0.9999999999", "0"},
+	})
+
+	c.Epsilon = 1e-9
+	checkEval(t, c, []evalCase{
+		{"0.1 + 0.2 == 0.3", "1"},
+		{"0.1 + 0.2 != 0.3", "0"},
+		{"0.1 + 0.2 <> 0.3", "0"},
+		// Values within the tolerance are neither less nor greater.
+		{"1 <= 0.9999999999", "1"},
+		{"0.9999999999 < 1", "0"},
+		{"1 < 1.0000001", "1"},
+		// The tolerance is relative to the operands for large ones.
+		{"1e20 == 1e20 + 1e10", "1"},
+		{"1 == 1.1", "0"},
+	})
+}
+
+func TestNegativeBasePower(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"(-2)^3", "-8"},
+		{"(-2)^2", "4"},
+		{"(-2)^-1", "-0.5"},
+		{"(-2)^0", "1"},
+		// Odd roots of negative numbers are real.
+		{"(-8)^(1/3)", "-2"},
+		{"(-27)^(1/3)", "-3"},
+		{"(-32)^(1/5)", "-2"},
+		{"(-8)^(-1/3)", "-0.5"},
+		// ^ binds tighter than the prefix minus.
+		{"-2^2", "-4"},
+	})
+	checkEvalError(t, c, "(-8)^0.5", "negative base -8 with fractional exponent 0.5 has no real result at position 4")
+	checkEvalError(t, c, "(-8)^(2/3)", "negative base -8 with fractional exponent 0.6666666666666666 has no real result")
+}
+
+func TestPrecedence(t *testing.T) {
+	c := NewCalculator()
+	for op, want := range map[string]int{
+		",": precSequence, "=": precAssign,
+		"==": precEqual, "!=": precEqual, "<>": precEqual,
+		"<": precCompare, "<=": precCompare, ">": precCompare, ">=": precCompare, "in": precCompare,
+		"|": precBitOr, "&": precBitAnd, "<<": precShift, ">>": precShift,
+		"min": precMinMax, "max": precMinMax,
+		"+": precAdd, "-": precAdd, "+%": precAdd, "-%": precAdd,
+		"*": precMul, "/": precMul,
+		"^": precPow, "√": precRadical, "∛": precRadical,
+	} {
+		if got, ok := c.Precedence(op); !ok || got != want {
+			t.Errorf("Precedence(%q) = %d, %v, want %d, true", op, got, ok, want)
+		}
+	}
+	if got, _ := c.Precedence(","); got != 1 {
+		t.Errorf("Precedence(\",\") = %d, want 1", got)
+	}
+	for _, op := range []string{"%", "**", "sqrt", ""} {
+		if got, ok := c.Precedence(op); ok {
+			t.Errorf("Precedence(%q) = %d, true, want not an operator", op, got)
+		}
+	}
+}
+
+func TestMustCalculate(t *testing.T) {
+	if got := MustCalculate("2 * (3 + 4)"); got != 14 {
+		t.Errorf("MustCalculate = %v, want 14", got)
+	}
+
+	defer func() {
+		r := recover()
+		want := `calc: MustCalculate("1 / 0"): division by zero at position 2`
+		if r != want {
+			t.Errorf("panic = %v, want %q", r, want)
+		}
+	}()
+	MustCalculate("1 / 0")
+	t.Error("MustCalculate(\"1 / 0\") did not panic")
+}
+
+func TestEvaluateBatch(t *testing.T) {
+	c := NewCalculator()
+	env := map[string]float64{"price": 20, "qty": 3}
+	results, err := c.EvaluateBatch([]string{
+		"subtotal = price * qty",
+		"tax = subtotal * 0.25",
+		"total = subtotal + tax",
+		"total / 0",
+		"discount = total - 5",
+	}, env)
+	if err == nil || err.Error() != "expression 4: division by zero at position 6" {
+		t.Errorf("error = %v, want the failure of expression 4", err)
+	}
+	want := []string{"60", "15", "75", "", "70"}
+	for i, r := range results {
+		if (r.Err != nil) != (want[i] == "") {
+			t.Errorf("expression %d: error %v", i+1, r.Err)
+			continue
+		}
+		if r.Err == nil && r.Value.String() != want[i] {
+			t.Errorf("expression %d = %v, want %s", i+1, r.Value, want[i])
+		}
+	}
+	// The batch carries on past the failure, and env ends with the final
+	// state of the sheet.
+	for name, v := range map[string]float64{"price": 20, "qty": 3, "subtotal": 60, "tax": 15, "total": 75, "discount": 70} {
+		if env[name] != v {
+			t.Errorf("env[%q] = %v, want %v", name, env[name], v)
+		}
+	}
+
+	if _, err := NewCalculator().EvaluateBatch([]string{"x = 1", "x + 1"}, nil); err != nil {
+		t.Errorf("batch without env: %v", err)
+	}
+	if _, err := NewCalculator().EvaluateBatch(nil, map[string]float64{"pi": 3}); err == nil {
+		t.Error("an env redefining pi succeeded")
+	}
+}
+
+func TestPanicBecomesError(t *testing.T) {
+	c := NewCalculator()
+	boom := func(args []float64) (float64, error) {
+		var m map[string]float64
+		m["x"] = args[0] // assignment to a nil map panics
+		return 0, nil
+	}
+	if err := c.RegisterFunction("boom", 1, boom); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := map[string]func() error{
+		"Evaluate":  func() error { _, err := c.Evaluate("1 + boom(2)"); return err },
+		"Calculate": func() error { _, err := c.Calculate("boom(2)"); return err },
+		"CalculateWithStats": func() error {
+			_, _, err := c.CalculateWithStats("boom(2)")
+			return err
+		},
+		"Explain":     func() error { _, err := c.Explain("boom(2) * 3"); return err },
+		"EvaluateRPN": func() error { _, err := c.EvaluateRPN("2 boom"); return err },
+	}
+	for name, check := range checks {
+		err := check()
+		if !errors.Is(err, ErrInternal) {
+			t.Errorf("%s error = %v, want ErrInternal", name, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), "internal error: panic: assignment to entry in nil map") {
+			t.Errorf("%s error = %q", name, err)
+		}
+	}
+
+	// The calculator still works afterwards.
+	checkEval(t, c, []evalCase{{"1 + 2", "3"}})
+}
+
+func TestMinMaxOperators(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"3 max 5", "5"},
+		{"3 min 5", "3"},
+		{"1 max 2 max 3", "3"},
+		{"3 max 2 max 1", "3"},
+		{"5 min 2 min 9", "2"},
+		{"(3 min 4) max 2", "3"},
+		{"-1 max -2", "-1"},
+		// Arithmetic binds tighter, comparisons looser.
+		{"1 + 2 max 2.5", "3"},
+		{"2 * 3 max 5", "6"},
+		{"10 min 2 * 3", "6"},
+		{"1 max 2 == 2", "1"},
+	})
+	checkEvalError(t, c, "3 max", "not enough operands for operator max")
+}
+
+func TestComparisonsAreBoolean(t *testing.T) {
+	c := NewCalculator()
+	for input, want := range map[string]NumberFormat{
+		"3 > 2":       FormatBool,
+		"2 == 2":      FormatBool,
+		"1 < 2 < 3":   FormatBool,
+		"3 + 2":       FormatDefault,
+		"(3 > 2) + 1": FormatDefault,
+	} {
+		v, err := c.Evaluate(input)
+		if err != nil {
+			t.Errorf("Evaluate(%q): %v", input, err)
+			continue
+		}
+		if v.Format != want {
+			t.Errorf("Evaluate(%q) has format %v, want %v", input, v.Format, want)
+		}
+	}
+}
+
+func TestNotEqualAlias(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"3 <> 4", "1"},
+		{"3 <> 3", "0"},
+		{"3 != 4", "1"},
+		{"(1 + 2 <> 3) == (1 + 2 != 3)", "1"},
+	})
+	tokens, err := c.tokenize("3<>4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Tokens(tokens).String(); got != `NUMBER("3") OPERATOR("<>") NUMBER("4")` {
+		t.Errorf("3<>4 tokens = %s", got)
+	}
+}
+
+func TestDefaultPolicies(t *testing.T) {
+	c := NewCalculator()
+	checkEvalError(t, c, "", "")
+	checkEvalError(t, c, "1 +", "")
+
+	c.Default = -1
+	c.UseDefault = DefaultEmpty
+	checkEval(t, c, []evalCase{{"", "-1"}, {"   \t", "-1"}, {"1 + 1", "2"}})
+	checkEvalError(t, c, "1 +", "")
+	checkEvalError(t, c, "(2", "")
+
+	c.UseDefault = DefaultInvalid
+	checkEval(t, c, []evalCase{{"", "-1"}, {"1 +", "-1"}, {"(2", "-1"}, {"1 + 1", "2"}})
+	// Input that parses but fails, and input over a limit, still fail.
+	checkEvalError(t, c, "1/0", "division by zero")
+	checkEvalError(t, c, "unknown(1)", "unknown function 'unknown'")
+	c.MaxLength = 3
+	if _, err := c.Evaluate("1 + 2 +"); !errors.Is(err, ErrInputTooLong) {
+		t.Errorf("error = %v, want ErrInputTooLong", err)
+	}
+}
+
+func TestAns(t *testing.T) {
+	c := NewCalculator()
+	checkEvalError(t, c, "ans(-1)", "index -1 is out of range, there are 0 results")
+	c.History = []Value{{Num: 10}, {Num: 20}, {Num: 30}}
+	checkEval(t, c, []evalCase{
+		{"ans(-1)", "30"},
+		{"ans(-2)", "20"},
+		{"ans(-3)", "10"},
+		{"ans(-1) - ans(-3)", "20"},
+	})
+	checkEvalError(t, c, "ans(-4)", "index -4 is out of range, there are 3 results")
+	checkEvalError(t, c, "ans(0)", "index must be a negative integer, got 0")
+	checkEvalError(t, c, "ans(-1.5)", "index must be a negative integer, got -1.5")
+}
+
+func TestRemapOperators(t *testing.T) {
+	c := NewCalculator()
+	for _, names := range [][2]string{{"×", "*"}, {":", "/"}} {
+		if err := c.AliasOperator(names[0], names[1]); err != nil {
+			t.Fatalf("AliasOperator(%q, %q): %v", names[0], names[1], err)
+		}
+	}
+	if err := c.RemoveOperator("*"); err != nil {
+		t.Fatal(err)
+	}
+	checkEval(t, c, []evalCase{
+		{"6 × 2 : 3", "4"},
+		{"2 + 3 × 4", "14"},
+		{"8 / 2", "4"},
+		// Juxtaposition still multiplies.
+		{"2(3)", "6"},
+	})
+	checkEvalError(t, c, "6 * 2", "invalid character")
+
+	// A removed builtin symbol can be aliased again.
+	if err := c.AliasOperator("**", "*"); err != nil {
+		t.Fatal(err)
+	}
+	checkEval(t, c, []evalCase{{"3 ** 4", "12"}})
+
+	for _, tc := range []struct{ alias, symbol string }{
+		{"%%", "nothere"},
+		{"×", "+"},
+		{"+", "-"},
+		{"a b", "+"},
+		{"(", "+"},
+		{"", "+"},
+		// - is prefix as well as binary, and prefix operators cannot be
+		// words.
+		{"minus", "-"},
+	} {
+		if err := c.AliasOperator(tc.alias, tc.symbol); err == nil {
+			t.Errorf("AliasOperator(%q, %q) succeeded", tc.alias, tc.symbol)
+		}
+	}
+	if err := c.AliasOperator("sqrt", "/"); !errors.Is(err, ErrBuiltinName) {
+		t.Errorf("AliasOperator(sqrt) error = %v, want ErrBuiltinName", err)
+	}
+	for _, symbol := range []string{"*", "@"} {
+		if err := c.RemoveOperator(symbol); err == nil {
+			t.Errorf("RemoveOperator(%q) succeeded", symbol)
+		}
+	}
+}
+
+func TestEvaluateLabeled(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{{"a = 1", "1"}, {"b = 2", "2"}, {"c = 4", "4"}})
+	for _, tc := range []struct {
+		input  string
+		result string
+		labels string
+	}{
+		{`(a + b):"sum" * c`, "12", "map[sum:3]"},
+		{`(a + b):"sum" * (c - 1):"less"`, "9", "map[less:3 sum:3]"},
+		// A label binds tighter than any operator.
+		{`a + b:"b"`, "3", "map[b:2]"},
+		{`-2:"two"^2`, "-4", "map[two:2]"},
+		// The last value of a label evaluated more than once.
+		{`series(i, 1, 3, i:"i")`, "6", "map[i:3]"},
+		{"a + b", "3", "map[]"},
+	} {
+		v, labels, err := c.EvaluateLabeled(tc.input)
+		if err != nil {
+			t.Errorf("EvaluateLabeled(%q) error: %v", tc.input, err)
+			continue
+		}
+		if v.String() != tc.result || fmt.Sprint(labels) != tc.labels {
+			t.Errorf("EvaluateLabeled(%q) = %v, %v, want %s, %s", tc.input, v, labels, tc.result, tc.labels)
+		}
+	}
+	// Evaluate ignores labels.
+	checkEval(t, c, []evalCase{{`(a + b):"sum" * c`, "12"}})
+
+	c.Rational = true
+	if _, _, err := c.EvaluateLabeled(`1:"one"`); err == nil {
+		t.Errorf("EvaluateLabeled in rational mode succeeded")
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	c := NewCalculator()
+	for _, tc := range []struct {
+		input string
+		cost  int
+	}{
+		{"42", 0},
+		{"1 + 2", 1},
+		{"2 * (3 + 4)", 2},
+		{"sqrt(16) * 3", 2},
+		{"-x + 1", 2},
+		// The call and five terms of a ^ and an addition.
+		{"series(i, 1, 5, i^2)", 11},
+		{"series(i, 1, 3, series(j, 1, 2, j))", 13},
+		// The inner bound i is not a constant, so it counts as the most
+		// terms a series may have.
+		{"series(i, 1, 3, series(j, 1, i, j))", 1 + 3*(1+maxSeriesTerms+1)},
+		{"series(i, 5, 1, i)", 1},
+	} {
+		got, err := c.EstimateCost(tc.input)
+		if err != nil {
+			t.Errorf("EstimateCost(%q) error: %v", tc.input, err)
+			continue
+		}
+		if got != tc.cost {
+			t.Errorf("EstimateCost(%q) = %d, want %d", tc.input, got, tc.cost)
+		}
+	}
+
+	// The estimate is what CalculateWithStats counts.
+	for _, input := range []string{"2 * (3 + 4)", "sqrt(16) * 3", "series(i, 1, 5, i^2)"} {
+		cost, err := c.EstimateCost(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, stats, err := c.CalculateWithStats(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cost != stats.Operations {
+			t.Errorf("EstimateCost(%q) = %d, but %d operations", input, cost, stats.Operations)
+		}
+	}
+
+	// Bounds that are not constant cost the most terms a series may have,
+	// and the product of nested ones does not overflow.
+	if got, err := c.EstimateCost("series(i, 1, n, i)"); err != nil || got != 1+maxSeriesTerms {
+		t.Errorf("EstimateCost with variable bounds = %d, %v, want %d", got, err, 1+maxSeriesTerms)
+	}
+	nested := "k"
+	for _, index := range []string{"k", "j", "i", "h"} {
+		nested = "series(" + index + ", 1, 1000000, " + nested + ")"
+	}
+	if got, err := c.EstimateCost(nested); err != nil || got != math.MaxInt {
+		t.Errorf("EstimateCost of four nested series = %d, %v, want MaxInt", got, err)
+	}
+	if _, err := c.EstimateCost("1 +"); err == nil {
+		t.Errorf("EstimateCost of a malformed expression succeeded")
+	}
+	if _, err := c.EstimateCost("series(i, 1, 2000000, i)"); err == nil {
+		t.Errorf("EstimateCost of a series over the limit succeeded")
+	}
+}
+
+func TestPercentChange(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"100 +% 10", "110"},
+		{"100 -% 10", "90"},
+		{"100 +% 10 +% 5", "115.5"},
+		// Increase then decrease by the same percent is not a round trip.
+		{"100 +% 10 -% 10", "99"},
+		{"200 -% 25 +% 50", "225"},
+		{"80 -% 20 -% 50", "32"},
+		// Everything before them at their level is the base.
+		{"2 * 100 +% 10", "220"},
+		{"100 + 50 +% 10", "165"},
+		{"100 + (50 +% 10)", "155"},
+		{"100 +% 10 * 2", "120"},
+		{"100 +% 15%", "100.15"},
+	})
+
+	c.Rational = true
+	checkEval(t, c, []evalCase{{"100 +% 10 +% 5", "231/2"}, {"100 +% 10 -% 10", "99"}})
+	c.Rational = false
+	c.Numbers = decimalSystem{}
+	checkEval(t, c, []evalCase{{"19.99 +% 10 -% 10", "19.7901"}})
+}
+
+func TestEvaluateContext(t *testing.T) {
+	c := NewCalculator()
+	v, err := c.EvaluateContext(context.Background(), "1 + 2")
+	if err != nil || v.String() != "3" {
+		t.Errorf("EvaluateContext(1 + 2) = %v, %v", v, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.EvaluateContext(ctx, "1 + 2")
+	if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled error = %v, want ErrCanceled and context.Canceled", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.EvaluateContext(ctx, "series(i, 1, 1000000, series(j, 1, 1000, j))")
+	if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("timed out error = %v, want ErrCanceled and context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("stopping took %v", d)
+	}
+
+	// The context does not outlive the call.
+	checkEval(t, c, []evalCase{{"series(i, 1, 1000, i)", "500500"}})
+}
+
+func TestOperandCount(t *testing.T) {
+	c := NewCalculator()
+	if err := c.Define("sq(x) = x * x"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		input string
+		count int
+	}{
+		{"42", 1},
+		{"1 + 2 * 3", 3},
+		{"2 * x + x", 3},
+		// A function name is not an operand.
+		{"sin(x) + pi", 2},
+		{"sqrt(16) * 2", 2},
+		{"x = 3", 2},
+		{`"a" + "b"`, 0},
+		// Macros count as what they expand to.
+		{"sq(3)", 2},
+		{"1 +", 1},
+		{"", 0},
+	} {
+		got, err := c.OperandCount(tc.input)
+		if err != nil {
+			t.Errorf("OperandCount(%q) error: %v", tc.input, err)
+			continue
+		}
+		if got != tc.count {
+			t.Errorf("OperandCount(%q) = %d, want %d", tc.input, got, tc.count)
+		}
+	}
+	if _, err := c.OperandCount("1 $ 2"); err == nil {
+		t.Errorf("OperandCount of an unknown character succeeded")
+	}
+}
+
+func TestEvaluateWith(t *testing.T) {
+	c := NewCalculator()
+	user := map[string]float64{"rate": 7}
+	defaults := map[string]float64{"rate": 5, "fee": 2}
+	for _, tc := range []struct {
+		input string
+		want  string
+	}{
+		// rate is the user's, and fee falls through to the defaults.
+		{"rate + fee", "9"},
+		{"fee * 10", "20"},
+		{"rate * (fee + 1)", "21"},
+		{"rate + pi > 10", "1"},
+	} {
+		v, err := c.EvaluateWith(tc.input, user, defaults)
+		if err != nil {
+			t.Errorf("EvaluateWith(%q) error: %v", tc.input, err)
+			continue
+		}
+		if got := v.String(); got != tc.want {
+			t.Errorf("EvaluateWith(%q) = %s, want %s", tc.input, got, tc.want)
+		}
+	}
+	if v, err := c.EvaluateWith("rate", defaults, user); err != nil || v.String() != "5" {
+		t.Errorf("rate with the defaults first = %v, %v, want 5", v, err)
+	}
+
+	// The environments are only read, and only while the call runs.
+	if _, err := c.EvaluateWith("fee = 3", user, defaults); err != nil {
+		t.Fatal(err)
+	}
+	if defaults["fee"] != 2 {
+		t.Errorf("assignment changed the environment: fee = %v", defaults["fee"])
+	}
+	if v, err := c.EvaluateWith("rate + fee", user, defaults); err != nil || v.String() != "10" {
+		t.Errorf("rate + fee after fee = 3 = %v, %v, want 10", v, err)
+	}
+	checkEvalError(t, c, "rate", "unknown identifier 'rate'")
+
+	_, err := c.EvaluateWith("pi", map[string]float64{"pi": 3})
+	if err == nil || !strings.Contains(err.Error(), "environment 1: cannot redefine constant pi") {
+		t.Errorf("redefining pi: %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// cbreak is only implemented on Linux; elsewhere the REPL reads plain
+// lines.
+func cbreak(f *os.File) (func(), error) {
+	return nil, errors.New("line editing is not supported on this system")
+}
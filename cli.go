@@ -0,0 +1,652 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Run is the command-line interface: it parses args (including the program
+// name in args[0]), evaluates the expression given as arguments, or else
+// the one in the CALC_EXPR environment variable, or else every line read
+// from in, prompting only if in is a terminal, and writes results to out
+// and errors, including those in flags, to errout, so that a script can
+// pipe the results and still see the errors. The return value is the
+// process exit code.
+func Run(in io.Reader, out, errout io.Writer, args []string) int {
+	name := "calc"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(errout)
+	rpn := fs.Bool("rpn", false, "print the expression in Reverse Polish Notation instead of evaluating it")
+	rpnInput := fs.Bool("rpn-input", false, "read expressions in Reverse Polish Notation, as in 3 4 +")
+	trace := fs.String("trace", "", "append a JSON line per evaluation step to this file (- for standard error)")
+	explain := fs.Bool("explain", false, "print the parse tree and each reduction step")
+	rounding := fs.String("rounding", "half-up", "rounding of fixed() and --currency: half-up, half-even, half-down, toward-zero or away-from-zero")
+	nan := fs.String("nan", "error", "functions and prefix operators returning NaN, as ln(-1) and √-1 do: error or propagate")
+	overflow := fs.String("overflow", "ieee", "overflow handling: ieee, error or saturate")
+	maxLength := fs.Int("max-length", 0, "reject expressions longer than this many characters (0 = no limit)")
+	maxTokens := fs.Int("max-tokens", 0, "reject expressions of more than this many tokens (0 = no limit)")
+	maxDepth := fs.Int("max-depth", DefaultMaxDepth, "reject expressions nested more than this many levels deep (0 = no limit)")
+	timeout := fs.Duration("timeout", 0, "give up on an expression that takes longer than this, e.g. 100ms (0 = no limit, or 10s with --serve)")
+	complexMode := fs.Bool("complex", false, "evaluate in complex numbers, with i as the imaginary unit")
+	rational := fs.Bool("rational", false, "evaluate exactly in fractions and print results in lowest terms, as in 1/3")
+	approx := fs.Bool("approx", false, "with --rational, also print the decimal value of fractional results")
+	epsilon := fs.Float64("epsilon", 0, "tolerance of the comparison operators (0 = exact)")
+	baseInput := fs.Int("base-input", 10, "read integer literals in this base, from 2 to 36, so with 16 FF is 255")
+	signedLiterals := fs.Bool("signed-literals", false, "read a prefix - or + right before a number as part of it, so -2^2 is 4")
+	unicodeDigits := fs.Bool("unicode-digits", false, "accept decimal digits from non-ASCII scripts, such as ٤٢")
+	currencyCode := fs.String("currency", "", "format results as amounts of this currency, e.g. USD")
+	thousands := fs.String("thousands", "", "separator between groups of three digits in results, e.g. ,")
+	implicit := fs.String("implicit", "all", "juxtapositions that multiply: number-paren, paren-paren, number-ident, all or none")
+	boolean := fs.Bool("bool", false, "print the results of comparisons as true or false")
+	labels := fs.Bool("labels", false, "after each result, print the values of its labelled subexpressions, as in (a + b):\"sum\"")
+	assert := fs.Bool("assert", false, "print nothing for true (non-zero) results, and \"assertion failed\" and exit 1 for false ones")
+	color := fs.String("color", "auto", "echo each expression with ANSI colors: always, never or auto (on a terminal)")
+	quiet := fs.Bool("quiet", false, "print bare results and errors, without the prompt or labels")
+	multiline := fs.Bool("multiline", false, "continue an expression on the next line while it ends in an operator or comma or has unclosed brackets")
+	macros := fs.String("macros", "", "file of macro definitions, one per line")
+	serveAddr := fs.String("serve", "", "serve GET /calc?expr=... as JSON on this address, e.g. :8080, instead of reading input")
+	showVersion := fs.Bool("version", false, "print the version and exit")
+	seed := fs.Int64("seed", 0, "seed for rand() so results are reproducible (default: random)")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if *showVersion {
+		fmt.Fprintln(out, "calc", Version())
+		return 0
+	}
+
+	c := NewCalculator()
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			c.Seed(*seed)
+		}
+	})
+	mode, err := ParseOverflowMode(*overflow)
+	if err != nil {
+		fmt.Fprintln(errout, "Error:", err)
+		return 2
+	}
+	c.Overflow = mode
+	c.NaN, err = ParseNaNPolicy(*nan)
+	if err != nil {
+		fmt.Fprintln(errout, "Error:", err)
+		return 2
+	}
+	c.Rounding, err = ParseRoundingMode(*rounding)
+	if err != nil {
+		fmt.Fprintln(errout, "Error:", err)
+		return 2
+	}
+	c.Implicit, err = ParseImplicitMultiply(*implicit)
+	if err != nil {
+		fmt.Fprintln(errout, "Error:", err)
+		return 2
+	}
+	c.MaxLength = *maxLength
+	c.MaxTokens = *maxTokens
+	c.MaxDepth = *maxDepth
+	c.Complex = *complexMode
+	c.Rational = *rational
+	c.Epsilon = *epsilon
+	c.UnicodeDigits = *unicodeDigits
+	c.SignedLiterals = *signedLiterals
+	if *baseInput < 2 || *baseInput > 36 {
+		fmt.Fprintf(errout, "Error: invalid input base %d, want 2 to 36\n", *baseInput)
+		return 2
+	}
+	c.InputBase = *baseInput
+	switch *trace {
+	case "":
+	case "-":
+		c.Trace = errout
+	default:
+		f, err := os.OpenFile(*trace, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			fmt.Fprintln(errout, "Error:", err)
+			return 2
+		}
+		defer f.Close()
+		c.Trace = f
+	}
+	if *macros != "" {
+		f, err := os.Open(*macros)
+		if err != nil {
+			fmt.Fprintln(errout, "Error:", err)
+			return 2
+		}
+		err = c.LoadMacros(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(errout, "Error: %s: %v\n", *macros, err)
+			return 2
+		}
+	}
+
+	if *timeout < 0 {
+		fmt.Fprintf(errout, "Error: invalid timeout %v, want a positive duration or 0\n", *timeout)
+		return 2
+	}
+
+	if *assert && (*rpn || *explain) {
+		fmt.Fprintln(errout, "Error: --assert cannot be combined with --rpn or --explain")
+		return 2
+	}
+
+	r := &repl{calc: c, out: out, errout: errout, explain: *explain, rpn: *rpn, rpnInput: *rpnInput, boolean: *boolean, quiet: *quiet, approx: *approx, assert: *assert, labels: *labels, timeout: *timeout, base: 10}
+	r.color, err = parseColor(*color, out)
+	if err != nil {
+		fmt.Fprintln(errout, "Error:", err)
+		return 2
+	}
+	if *thousands != "" {
+		if utf8.RuneCountInString(*thousands) != 1 || strings.ContainsAny(*thousands, "0123456789.-+e") {
+			fmt.Fprintf(errout, "Error: invalid thousands separator %q, want a single character other than a digit, sign, e or .\n", *thousands)
+			return 2
+		}
+		r.thousands = *thousands
+	}
+	if *currencyCode != "" {
+		cur, err := parseCurrency(*currencyCode)
+		if err != nil {
+			fmt.Fprintln(errout, "Error:", err)
+			return 2
+		}
+		r.currency = &cur
+	}
+
+	if *serveAddr != "" {
+		r.confirm("Serving on %s", *serveAddr)
+		if err := serve(*serveAddr, c, *timeout); err != nil {
+			fmt.Fprintln(errout, "Error:", err)
+			return 1
+		}
+		return 0
+	}
+
+	expr := strings.Join(fs.Args(), " ")
+	if expr == "" {
+		expr = os.Getenv("CALC_EXPR")
+	}
+	if expr != "" {
+		if err := r.evaluate(expr); err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	// Piped input gets no prompt, only a line of output per expression.
+	prompt := !r.quiet && isTerminal(in)
+	if prompt {
+		fmt.Fprintln(out, "Enter a math expression:")
+	}
+	read, restore := lineSource(in, out)
+	defer restore()
+	// pending is the start of an expression continued on later lines in
+	// multiline mode.
+	var pending string
+	for {
+		continuation := ""
+		if pending != "" && prompt {
+			continuation = "... "
+		}
+		text, err := read(continuation)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintln(errout, "Error:", err)
+			return 1
+		}
+		line := strings.TrimSpace(text)
+		if pending != "" {
+			// A blank line gives up on the continuation, evaluating
+			// what there is so its error is reported.
+			if line == "" {
+				r.evaluate(pending)
+				pending = ""
+				continue
+			}
+			// The newline ends any // comment on the earlier line.
+			line, pending = pending+"\n"+line, ""
+		} else {
+			switch line {
+			case "":
+				continue
+			case "exit", "quit":
+				return 0
+			}
+			if tokens, err := c.lex(line, false); err == nil && len(tokens) == 0 {
+				// A line of comments is skipped like a blank one.
+				continue
+			}
+			if r.command(line) {
+				continue
+			}
+		}
+		if *multiline && !r.rpnInput && c.incomplete(line) {
+			pending = line
+			continue
+		}
+		r.evaluate(line)
+	}
+	if pending != "" {
+		r.evaluate(pending)
+	}
+	if r.assert && r.failed {
+		return 1
+	}
+
+	return 0
+}
+
+// repl evaluates input lines for Run and prints their results.
+type repl struct {
+	calc *Calculator
+	// out receives results and errout errors.
+	out, errout io.Writer
+	explain     bool
+	// rpn prints each expression in postfix form instead of its value.
+	rpn bool
+	// rpnInput reads expressions in postfix form.
+	rpnInput bool
+	// boolean prints comparison results as true or false.
+	boolean bool
+	// quiet drops the prompt, the "Result = " and "Error: " labels and
+	// command confirmations, for use in pipelines.
+	quiet bool
+	// approx follows a fractional result of rational mode with its
+	// decimal value.
+	approx bool
+	// assert checks each result instead of printing it: zero fails.
+	assert bool
+	// labels follows each result with its labelled subexpressions.
+	labels bool
+	// timeout, when set, bounds how long each expression may take.
+	timeout time.Duration
+	// failed records that an expression failed, for the exit status of
+	// assert mode.
+	failed bool
+	// color echoes each expression, syntax-highlighted, before its
+	// result.
+	color bool
+	// base is the radix integer results are displayed in.
+	base int
+	// thousands, when set, separates groups of three digits in decimal
+	// results.
+	thousands string
+	// currency, when set, formats decimal results as amounts of money.
+	currency *currency
+}
+
+var displayBases = map[string]int{
+	"bin": 2,
+	"oct": 8,
+	"dec": 10,
+	"hex": 16,
+}
+
+var basePrefixes = map[int]string{
+	2:  "0b",
+	8:  "0o",
+	16: "0x",
+}
+
+// command runs line if it is a session command rather than an expression
+// and reports whether it was one. The commands are "?" or "help", which
+// lists what expressions may use, "def ...", which
+// defines a macro, "NAME := EXPR", which stores EXPR unevaluated for
+// eval(NAME), and "base NAME", which switches the display base of later
+// integer results between bin, oct, dec and hex.
+func (r *repl) command(line string) bool {
+	if line == "?" || line == "help" {
+		r.help()
+		return true
+	}
+	if i := strings.Index(line, ":="); i >= 0 {
+		name := strings.TrimSpace(line[:i])
+		if err := r.calc.Bind(name, line[i+2:]); err != nil {
+			r.fail(err)
+		} else {
+			r.confirm("Stored %s", name)
+		}
+		return true
+	}
+
+	if strings.HasPrefix(line, "def ") {
+		if err := r.calc.Define(line); err != nil {
+			r.fail(err)
+		} else {
+			r.confirm("Defined %s", strings.TrimSpace(strings.SplitN(line[4:], "=", 2)[0]))
+		}
+		return true
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 2 || fields[0] != "base" {
+		return false
+	}
+	base, ok := displayBases[fields[1]]
+	if !ok {
+		if strings.ContainsAny(fields[1], "(),") {
+			return false
+		}
+		r.fail(fmt.Errorf("unknown base %q, want bin, oct, dec or hex", fields[1]))
+		return true
+	}
+	r.base = base
+	r.confirm("Display base set to %s", fields[1])
+	return true
+}
+
+// help prints the operators by precedence, loosest first, and the
+// functions, constants and macros, all read from the calculator so the
+// list stays current.
+func (r *repl) help() {
+	c := r.calc
+	// Each operator is listed by the symbols input may use for it, its
+	// own first, then any aliases.
+	spellings := make(map[int][]string)
+	for _, symbols := range []map[string]int{c.binarySymbols, c.prefixSymbols} {
+		for symbol, id := range symbols {
+			if symbol != c.operators[id].symbol {
+				spellings[id] = append(spellings[id], symbol)
+			}
+		}
+	}
+	levels := make(map[int][]string)
+	var precs []int
+	for id, op := range c.operators {
+		symbols := spellings[id]
+		sort.Strings(symbols)
+		symbolIDs := c.binarySymbols
+		if op.unary {
+			symbolIDs = c.prefixSymbols
+		}
+		if own, ok := symbolIDs[op.symbol]; ok && own == id {
+			symbols = append([]string{op.symbol}, symbols...)
+		}
+		if len(symbols) == 0 {
+			continue
+		}
+		if _, ok := levels[op.precedence]; !ok {
+			precs = append(precs, op.precedence)
+		}
+		levels[op.precedence] = append(levels[op.precedence], symbols...)
+	}
+	sort.Ints(precs)
+	fmt.Fprintln(r.out, "Operators, loosest first:")
+	for _, p := range precs {
+		label := ""
+		if p == precUnary {
+			label = "prefix "
+		}
+		fmt.Fprintf(r.out, "  %2d  %s%s\n", p, label, strings.Join(levels[p], " "))
+	}
+
+	var funcs []string
+	for name, f := range c.functions {
+		if name == setFunction {
+			continue
+		}
+		if f.arity < 0 {
+			funcs = append(funcs, name+"(...)")
+		} else {
+			funcs = append(funcs, fmt.Sprintf("%s(%d)", name, f.arity))
+		}
+	}
+	if c.Complex {
+		for name := range complexFunctions {
+			if _, ok := c.functions[name]; !ok {
+				funcs = append(funcs, name+"(1)")
+			}
+		}
+	}
+	r.list("Functions, with their number of arguments:", funcs)
+
+	var aliases []string
+	for alias, name := range c.aliases {
+		aliases = append(aliases, alias+"="+name)
+	}
+	if len(aliases) > 0 {
+		r.list("Aliases:", aliases)
+	}
+
+	var consts []string
+	for name := range c.constants {
+		consts = append(consts, name)
+	}
+	if c.Complex {
+		consts = append(consts, "i")
+	}
+	r.list("Constants:", consts)
+
+	var macros []string
+	for name, m := range c.macros {
+		macros = append(macros, name+"("+strings.Join(m.params, ", ")+")")
+	}
+	if len(macros) > 0 {
+		r.list("Macros:", macros)
+	}
+}
+
+// list prints a sorted list under a heading, wrapped to fit 72 columns.
+func (r *repl) list(heading string, items []string) {
+	sort.Strings(items)
+	fmt.Fprintln(r.out, heading)
+	line := " "
+	for _, item := range items {
+		if len(line)+1+len(item) > 72 {
+			fmt.Fprintln(r.out, line)
+			line = " "
+		}
+		line += " " + item
+	}
+	fmt.Fprintln(r.out, line)
+}
+
+// format renders a result in the current display base, or as currency
+// when that is set and the base is decimal. Only integers can be shown in
+// another base; anything else falls back to decimal with a note saying so.
+func (r *repl) format(v Value) string {
+	if r.boolean && (v.Kind == NumberValue || v.Kind == RationalValue) && v.Format == FormatBool {
+		num, _ := v.number("result")
+		return strconv.FormatBool(num != 0)
+	}
+	if r.approx && v.Kind == RationalValue && !v.Rat.IsInt() {
+		num, _ := v.number("result")
+		return v.String() + " ≈ " + strconv.FormatFloat(num, 'g', -1, 64)
+	}
+	if r.base == 10 && r.currency != nil && v.Kind == NumberValue {
+		return r.currency.format(v.Num, r.calc.Rounding)
+	}
+	if r.base == 10 && r.thousands != "" && v.Kind == NumberValue {
+		return grouped(v, r.thousands)
+	}
+	if r.base == 10 || v.Kind != NumberValue {
+		return v.String()
+	}
+	if v.Num != math.Trunc(v.Num) || math.Abs(v.Num) >= 1<<63 {
+		return v.String() + " (not an integer, shown in decimal)"
+	}
+	n := int64(v.Num)
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	return sign + basePrefixes[r.base] + strings.ToUpper(strconv.FormatInt(n, r.base))
+}
+
+// evaluate prints the result of one expression, or the error it produced.
+// In assert mode a true result prints nothing and a false one prints
+// "assertion failed" and returns errAssertion.
+func (r *repl) evaluate(input string) (err error) {
+	defer func() {
+		if err != nil {
+			r.failed = true
+		}
+	}()
+	if r.timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+		defer cancel()
+		r.calc.ctx = ctx
+		defer func() { r.calc.ctx = nil }()
+	}
+	if r.rpn {
+		rpn, err := r.calc.Postfix(input)
+		if err != nil {
+			r.failAt(input, err)
+			return err
+		}
+		if r.quiet {
+			fmt.Fprintln(r.out, rpn)
+		} else {
+			fmt.Fprintf(r.out, "RPN = %s\n", rpn)
+		}
+		return nil
+	}
+	if r.explain {
+		explanation, err := r.calc.Explain(input)
+		if err != nil {
+			r.failAt(input, err)
+			return err
+		}
+		fmt.Fprint(r.out, explanation)
+		return nil
+	}
+
+	evaluate := r.calc.Evaluate
+	var labels map[string]Value
+	if r.rpnInput {
+		evaluate = r.calc.EvaluateRPN
+	} else {
+		if r.color && !r.quiet {
+			if tokens, err := r.calc.scan(input); err == nil {
+				fmt.Fprintf(r.out, "  %s\n", r.calc.highlight(tokens))
+			}
+		}
+		if r.labels {
+			evaluate = func(input string) (v Value, err error) {
+				v, labels, err = r.calc.EvaluateLabeled(input)
+				return v, err
+			}
+		}
+	}
+	result, err := evaluate(input)
+	if err != nil {
+		r.failAt(input, err)
+		return err
+	}
+	r.calc.History = append(r.calc.History, result)
+	if r.assert {
+		return r.check(input, result)
+	}
+	if r.quiet {
+		fmt.Fprintln(r.out, r.format(result))
+	} else {
+		fmt.Fprintf(r.out, "Result = %s\n", r.format(result))
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(r.out, "  %s = %s\n", name, r.format(labels[name]))
+	}
+	return nil
+}
+
+// errAssertion is returned by evaluate for a false assertion.
+var errAssertion = errors.New("assertion failed")
+
+// check is evaluate's test of an assertion: result must be a number other
+// than zero and NaN, so that true comparisons pass.
+func (r *repl) check(input string, result Value) error {
+	x, err := result.number("assertion")
+	if err != nil {
+		r.fail(err)
+		return err
+	}
+	if x != 0 && !math.IsNaN(x) {
+		return nil
+	}
+	if r.quiet {
+		fmt.Fprintln(r.errout, errAssertion)
+	} else {
+		fmt.Fprintf(r.errout, "%s: %s\n", errAssertion, input)
+	}
+	return errAssertion
+}
+
+// fail prints err to errout, labelled unless quiet. An expression that
+// ran out of time says so in terms of --timeout.
+func (r *repl) fail(err error) {
+	if r.timeout > 0 && errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("evaluation timed out after %v", r.timeout)
+	}
+	if r.quiet {
+		fmt.Fprintln(r.errout, err)
+	} else {
+		fmt.Fprintln(r.errout, "Error:", err)
+	}
+}
+
+// failAt is fail for an error evaluating input. Unless quiet, an error
+// about a particular position is followed by input, or the line of it
+// holding that position, with a caret under that character. The padding
+// before the caret copies any tabs in input, so the caret lines up
+// however wide the terminal draws them.
+func (r *repl) failAt(input string, err error) {
+	r.fail(err)
+	var pe *PositionError
+	if r.quiet || !errors.As(err, &pe) {
+		return
+	}
+	runes := []rune(input)
+	if pe.Pos < 0 || pe.Pos > len(runes) {
+		return
+	}
+	start, end := 0, len(runes)
+	for i, ch := range runes {
+		if ch == '\n' {
+			if i < pe.Pos {
+				start = i + 1
+			} else {
+				end = i
+				break
+			}
+		}
+	}
+	pad := make([]rune, pe.Pos-start)
+	for i, ch := range runes[start:pe.Pos] {
+		pad[i] = ' '
+		if ch == '\t' {
+			pad[i] = '\t'
+		}
+	}
+	fmt.Fprintf(r.errout, "  %s\n  %s^\n", string(runes[start:end]), string(pad))
+}
+
+// confirm prints the acknowledgement of a command, which quiet omits.
+func (r *repl) confirm(format string, args ...interface{}) {
+	if !r.quiet {
+		fmt.Fprintf(r.out, format+"\n", args...)
+	}
+}
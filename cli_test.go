@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// run runs the command line with args on input, returning its standard
+// output, standard error and exit code.
+func run(input string, args ...string) (stdout, stderr string, code int) {
+	var out, errout bytes.Buffer
+	code = Run(strings.NewReader(input), &out, &errout, append([]string{"calc"}, args...))
+	return out.String(), errout.String(), code
+}
+
+func TestSeedFlag(t *testing.T) {
+	first, _, code := run("", "--seed", "42", "--quiet", "rand() + randint(1, 6)")
+	if code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	second, _, _ := run("", "--seed", "42", "--quiet", "rand() + randint(1, 6)")
+	if first != second {
+		t.Errorf("--seed 42 printed %q, then %q", first, second)
+	}
+}
+
+func TestRunOneShot(t *testing.T) {
+	out, errout, code := run("", "2 * (3 + 4)")
+	if code != 0 || out != "Result = 14\n" || errout != "" {
+		t.Errorf("Run(2 * (3 + 4)) = %q, %q, exit %d", out, errout, code)
+	}
+
+	out, errout, code = run("", "1/0")
+	if code != 1 || out != "" || !strings.HasPrefix(errout, "Error: division by zero at position 1\n") {
+		t.Errorf("Run(1/0) = %q, %q, exit %d", out, errout, code)
+	}
+
+	if _, _, code = run("", "--bogus"); code != 2 {
+		t.Errorf("Run(--bogus) exit %d, want 2", code)
+	}
+}
+
+func TestRunREPL(t *testing.T) {
+	t.Setenv("CALC_EXPR", "")
+	out, errout, code := run("1 + 2\nx = 4\n\nx * 2\n1/0\nexit\n5\n")
+	if code != 0 {
+		t.Errorf("exit code %d", code)
+	}
+	// Piped input gets no prompt; an error does not end the session, and
+	// nothing after exit is read.
+	if want := "Result = 3\nResult = 4\nResult = 8\n"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	if !strings.HasPrefix(errout, "Error: division by zero") {
+		t.Errorf("errors = %q", errout)
+	}
+
+	if out, _, _ = run("1 + 2\n3 * 4\n", "--quiet"); out != "3\n12\n" {
+		t.Errorf("--quiet output = %q", out)
+	}
+}
+
+func TestREPLDisplayBase(t *testing.T) {
+	t.Setenv("CALC_EXPR", "")
+	out, errout, _ := run("255\nbase hex\n255\n-255\n2.5\nbase bin\n5\nbase oct\n8\nbase dec\n255\n")
+	want := "Result = 255\n" +
+		"Display base set to hex\n" +
+		"Result = 0xFF\n" +
+		"Result = -0xFF\n" +
+		"Result = 2.5 (not an integer, shown in decimal)\n" +
+		"Display base set to bin\n" +
+		"Result = 0b101\n" +
+		"Display base set to oct\n" +
+		"Result = 0o10\n" +
+		"Display base set to dec\n" +
+		"Result = 255\n"
+	if out != want || errout != "" {
+		t.Errorf("output = %q, errors %q, want %q", out, errout, want)
+	}
+}
+
+func TestREPLUnknownBase(t *testing.T) {
+	t.Setenv("CALC_EXPR", "")
+	out, errout, _ := run("base foo\n10\n")
+	if out != "Result = 10\n" || errout != "Error: unknown base \"foo\", want bin, oct, dec or hex\n" {
+		t.Errorf("output = %q, errors %q", out, errout)
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	t.Setenv("CALC_EXPR", "")
+	out, errout, _ := run("1 + 2\n1/0\nx = 3\n", "--quiet")
+	if out != "3\n3\n" {
+		t.Errorf("output = %q, want only the numbers", out)
+	}
+	if errout != "division by zero at position 1\n" {
+		t.Errorf("errors = %q, want the bare error", errout)
+	}
+
+	if out, _, _ := run("", "--quiet", "2 * 3"); out != "6\n" {
+		t.Errorf("one-shot output = %q, want %q", out, "6\n")
+	}
+}
+
+func TestRunCALC_EXPR(t *testing.T) {
+	t.Setenv("CALC_EXPR", "6 * 7")
+	out, _, code := run("1 + 1\n")
+	if code != 0 || out != "Result = 42\n" {
+		t.Errorf("with CALC_EXPR set: %q, exit %d; want the variable evaluated and stdin ignored", out, code)
+	}
+
+	// Arguments take precedence over the variable.
+	if out, _, _ = run("", "2 + 2"); out != "Result = 4\n" {
+		t.Errorf("with an argument: %q, want the argument evaluated", out)
+	}
+
+	t.Setenv("CALC_EXPR", "1/0")
+	if _, _, code = run(""); code != 1 {
+		t.Errorf("failing CALC_EXPR exit %d, want 1", code)
+	}
+}
+
+func TestREPLHelp(t *testing.T) {
+	t.Setenv("CALC_EXPR", "")
+	for _, command := range []string{"?", "help"} {
+		out, errout, _ := run(command + "\n")
+		if errout != "" {
+			t.Errorf("%s: errors %q", command, errout)
+		}
+		for _, want := range []string{
+			"Operators, loosest first:\n",
+			"   1  ,\n",
+			"   9  + - +% -%\n",
+			"  10  * /\n",
+			"  12  ^\n",
+			"Functions, with their number of arguments:\n",
+			"sqrt(1)", "base(3)", "mean(...)", "rand(0)",
+			"Constants:\n",
+			" pi\n", "e inf ",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s output lacks %q:\n%s", command, want, out)
+			}
+		}
+	}
+}
+
+func TestCaretWithTabs(t *testing.T) {
+	t.Setenv("CALC_EXPR", "")
+	_, errout, _ := run("1 +\t2 / 0\n")
+	want := "Error: division by zero at position 6\n" +
+		"  1 +\t2 / 0\n" +
+		"     \t  ^\n"
+	if errout != want {
+		t.Errorf("errors = %q, want %q", errout, want)
+	}
+
+	// Only the line of a multi-line expression holding the error is shown.
+	_, errout, _ = run("", "--multiline", "1 +\n\t2 / 0")
+	want = "Error: division by zero at position 7\n" +
+		"  \t2 / 0\n" +
+		"  \t  ^\n"
+	if errout != want {
+		t.Errorf("multi-line errors = %q, want %q", errout, want)
+	}
+}
+
+func TestBoolFlag(t *testing.T) {
+	t.Setenv("CALC_EXPR", "")
+	out, _, _ := run("3 > 2\n3 + 2\n2 < 1\n1 < 2 < 3\n(3 > 2) + 1\n3 in {1, 3}\nisprime(7)\n", "--bool", "--quiet")
+	if want := "true\n5\nfalse\ntrue\n2\ntrue\ntrue\n"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	// Without --bool comparisons print as numbers.
+	if out, _, _ := run("", "--quiet", "3 > 2"); out != "1\n" {
+		t.Errorf("output without --bool = %q", out)
+	}
+}
+
+func TestREPLAns(t *testing.T) {
+	t.Setenv("CALC_EXPR", "")
+	out, errout, _ := run("1 + 1\n10\nans(-1) + ans(-2)\nans(-1)\nans(-9)\n", "--quiet")
+	if want := "2\n10\n12\n12\n"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	if !strings.Contains(errout, "index -9 is out of range, there are 4 results") {
+		t.Errorf("errors = %q", errout)
+	}
+}
+
+func TestRunPipedLines(t *testing.T) {
+	t.Setenv("CALC_EXPR", "")
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go func() {
+		w.WriteString("1 + 2\nfoo\n\n3 * 3\n2^10\n")
+		w.Close()
+	}()
+	var out, errout bytes.Buffer
+	if code := Run(r, &out, &errout, []string{"calc"}); code != 0 {
+		t.Errorf("exit code %d", code)
+	}
+	// A pipe gets no prompt, and the error does not end the input.
+	if want := "Result = 3\nResult = 9\nResult = 1024\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+	if want := "Error: unknown identifier 'foo'"; !strings.HasPrefix(errout.String(), want) {
+		t.Errorf("errors = %q, want %q", errout.String(), want)
+	}
+}
+
+func TestMultiline(t *testing.T) {
+	t.Setenv("CALC_EXPR", "")
+	out, errout, _ := run("3 +\n4\nfixed(3.14159,\n2)\n(1 +\n2) *\n3\n", "--multiline", "--quiet")
+	if want := "7\n3.14\n9\n"; out != want || errout != "" {
+		t.Errorf("output = %q, errors %q, want %q", out, errout, want)
+	}
+
+	// A blank line or the end of input evaluates what was typed so far.
+	out, errout, _ = run("3 +\n\n5 *\n", "--multiline", "--quiet")
+	if want := "not enough operands for operator +\nnot enough operands for operator *\n"; out != "" || errout != want {
+		t.Errorf("output = %q, errors %q, want errors %q", out, errout, want)
+	}
+
+	// Without --multiline each line stands alone.
+	out, errout, _ = run("3 +\n4\n", "--quiet")
+	if out != "4\n" || errout == "" {
+		t.Errorf("without --multiline: output = %q, errors %q", out, errout)
+	}
+}
+
+func TestAssert(t *testing.T) {
+	for _, tc := range []struct {
+		expr   string
+		stderr string
+		code   int
+	}{
+		{"2+2 == 4", "", 0},
+		{"3", "", 0},
+		{"2+2 == 5", "assertion failed: 2+2 == 5\n", 1},
+		{"0", "assertion failed: 0\n", 1},
+		{"0.1+0.2 == 0.3", "assertion failed: 0.1+0.2 == 0.3\n", 1},
+		{`"yes"`, "", 1},
+		{"1 +", "", 1},
+	} {
+		stdout, stderr, code := run("", "--assert", tc.expr)
+		if stdout != "" || code != tc.code || (tc.stderr != "" && stderr != tc.stderr) || (code != 0 && stderr == "") {
+			t.Errorf("calc --assert %q = %q, %q, exit %d, want exit %d", tc.expr, stdout, stderr, code, tc.code)
+		}
+	}
+	if _, stderr, code := run("", "--assert", "--epsilon", "1e-9", "0.1+0.2 == 0.3"); code != 0 {
+		t.Errorf("--epsilon assertion failed: %q", stderr)
+	}
+
+	t.Setenv("CALC_EXPR", "")
+	stdout, stderr, code := run("x = 2\nx * 2 == 4\nx == 3\n1 == 1\n", "--assert")
+	if stdout != "" || stderr != "assertion failed: x == 3\n" || code != 1 {
+		t.Errorf("assertions on stdin = %q, %q, exit %d", stdout, stderr, code)
+	}
+	if _, _, code := run("1 == 1\n2 > 1\n", "--assert"); code != 0 {
+		t.Errorf("passing assertions on stdin exit %d", code)
+	}
+}
+
+func TestLabelsFlag(t *testing.T) {
+	out, _, _ := run("", "--labels", `(1 + 2):"sum" * (4 - 1):"diff"`)
+	if want := "Result = 9\n  diff = 3\n  sum = 3\n"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	if out, _, _ := run("", `(1 + 2):"sum" * 3`); out != "Result = 9\n" {
+		t.Errorf("output without --labels = %q", out)
+	}
+}
+
+func TestMultilineCall(t *testing.T) {
+	t.Setenv("CALC_EXPR", "")
+	input := "mean(1,\n    2,\n    3)\nfixed(mean(1,\n  2) * (3 +\n  4),\n 1)\n"
+	out, errout, _ := run(input, "--multiline", "--quiet")
+	if want := "2\n10.5\n"; out != want || errout != "" {
+		t.Errorf("output = %q, errors %q, want %q", out, errout, want)
+	}
+}
+
+func TestOutputAndErrorStreams(t *testing.T) {
+	stdout, stderr, code := run("", "6 * 7")
+	if stdout != "Result = 42\n" || stderr != "" || code != 0 {
+		t.Errorf("success: stdout %q, stderr %q, exit %d", stdout, stderr, code)
+	}
+	stdout, stderr, code = run("", "1/0")
+	if stdout != "" || !strings.HasPrefix(stderr, "Error: division by zero") || code != 1 {
+		t.Errorf("error: stdout %q, stderr %q, exit %d", stdout, stderr, code)
+	}
+	stdout, stderr, code = run("", "--nothere", "1")
+	if stdout != "" || stderr == "" || code != 2 {
+		t.Errorf("bad flag: stdout %q, stderr %q, exit %d", stdout, stderr, code)
+	}
+
+	t.Setenv("CALC_EXPR", "")
+	stdout, stderr, _ = run("1+2\n1/0\n3\n")
+	if stdout != "Result = 3\nResult = 3\n" || strings.Contains(stderr, "Result") || !strings.Contains(stderr, "division by zero") {
+		t.Errorf("piped: stdout %q, stderr %q", stdout, stderr)
+	}
+}
+
+func TestREPLComments(t *testing.T) {
+	t.Setenv("CALC_EXPR", "")
+	out, errout, _ := run("// a heading\n1 + 2 // three\n/* only */\n1 + /* a\nb */ 2\n", "--quiet", "--multiline")
+	if out != "3\n3\n" || errout != "" {
+		t.Errorf("output = %q, errors %q", out, errout)
+	}
+	_, errout, _ = run("1 + /* open\n", "--quiet")
+	if !strings.Contains(errout, "unterminated comment") {
+		t.Errorf("errors = %q", errout)
+	}
+}
+
+func TestTimeoutFlag(t *testing.T) {
+	stdout, stderr, code := run("", "--timeout", "1ms", "series(i, 1, 1000000, series(j, 1, 1000, j))")
+	if stdout != "" || stderr != "Error: evaluation timed out after 1ms\n" || code != 1 {
+		t.Errorf("calc --timeout 1ms = %q, %q, exit %d", stdout, stderr, code)
+	}
+	if stdout, _, code := run("", "--timeout", "10s", "1 + 2"); stdout != "Result = 3\n" || code != 0 {
+		t.Errorf("calc --timeout 10s = %q, exit %d", stdout, code)
+	}
+	if _, stderr, code := run("", "--timeout", "soon", "1"); code != 2 || stderr == "" {
+		t.Errorf("calc --timeout soon = %q, exit %d", stderr, code)
+	}
+}
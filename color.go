@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ANSI escape sequences for the token classes --color distinguishes.
+const (
+	ansiReset    = "\x1b[0m"
+	ansiNumber   = "\x1b[36m"
+	ansiOperator = "\x1b[33m"
+	ansiParen    = "\x1b[35m"
+	ansiString   = "\x1b[32m"
+)
+
+// parseColor interprets the --color flag: always, never, or auto, which
+// colors only when out is a terminal.
+func parseColor(mode string, out io.Writer) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return isTerminal(out), nil
+	}
+	return false, fmt.Errorf("unknown color mode %q, want always, never or auto", mode)
+}
+
+// isTerminal reports whether stream, an input or output of Run, is a
+// terminal, as opposed to a pipe, a file or a device such as /dev/null.
+func isTerminal(stream interface{}) bool {
+	f, ok := stream.(*os.File)
+	return ok && terminal(f)
+}
+
+// highlight renders tokens as an expression with numbers, operators,
+// parentheses and strings in distinct colors. Tokens are separated by
+// spaces except inside parentheses and after prefix operators and
+// function names, so 2*sin(-x) comes out as 2 * sin(-x).
+func (c *Calculator) highlight(tokens []Token) string {
+	var sb strings.Builder
+	var prev *Token
+	for i, t := range tokens {
+		if t.Type == FUNCTION {
+			// The call a set literal is scanned as has no text of its own.
+			continue
+		}
+		if prev != nil && c.spaced(*prev, t) {
+			sb.WriteByte(' ')
+		}
+		prev = &tokens[i]
+		switch t.Type {
+		case NUMBER:
+			sb.WriteString(ansiNumber + t.Value + ansiReset)
+		case OPERATOR:
+			sb.WriteString(ansiOperator + t.Value + ansiReset)
+		case LPAREN, RPAREN:
+			sb.WriteString(ansiParen + t.Value + ansiReset)
+		case STRING:
+			sb.WriteString(ansiString + `"` + t.Value + `"` + ansiReset)
+		case LABEL:
+			sb.WriteString(":" + ansiString + `"` + t.Value + `"` + ansiReset)
+		default:
+			sb.WriteString(t.Value)
+		}
+	}
+	return sb.String()
+}
+
+// spaced reports whether highlight puts a space between prev and next.
+func (c *Calculator) spaced(prev, next Token) bool {
+	switch {
+	case prev.Type == LPAREN, next.Type == RPAREN, next.Type == COMMA, next.Type == LABEL:
+		return false
+	case prev.Type == IDENTIFIER && next.Type == LPAREN:
+		return false
+	case prev.Type == OPERATOR && c.operators[prev.Op].unary:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestColorFlag(t *testing.T) {
+	out, _, _ := run("", "--color", "always", "2 * (3 + 4)")
+	want := "  \x1b[36m2\x1b[0m \x1b[33m*\x1b[0m \x1b[35m(\x1b[0m\x1b[36m3\x1b[0m \x1b[33m+\x1b[0m \x1b[36m4\x1b[0m\x1b[35m)\x1b[0m\n" +
+		"Result = 14\n"
+	if out != want {
+		t.Errorf("--color always output = %q, want %q", out, want)
+	}
+
+	// Output to anything but a terminal is not colored by default.
+	for _, args := range [][]string{{"--color", "never"}, {"--color", "auto"}, {}} {
+		out, _, _ := run("", append(args, "2 * (3 + 4)")...)
+		if strings.Contains(out, "\x1b[") || out != "Result = 14\n" {
+			t.Errorf("%v output = %q, want no color codes", args, out)
+		}
+	}
+
+	if _, _, code := run("", "--color", "sometimes", "1"); code != 2 {
+		t.Errorf("--color sometimes exit %d, want 2", code)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	c := NewCalculator()
+	tokens, err := c.tokenize(`2*sin(-x) + len("ab")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := c.highlight(tokens)
+	want := ansiNumber + "2" + ansiReset + " " + ansiOperator + "*" + ansiReset + " sin" +
+		ansiParen + "(" + ansiReset + ansiOperator + "-" + ansiReset + "x" + ansiParen + ")" + ansiReset +
+		" " + ansiOperator + "+" + ansiReset + " len" +
+		ansiParen + "(" + ansiReset + ansiString + `"ab"` + ansiReset + ansiParen + ")" + ansiReset
+	if got != want {
+		t.Errorf("highlight = %q, want %q", got, want)
+	}
+}
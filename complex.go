@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strconv"
+)
+
+// Complex-mode implementations of the arithmetic operators, keyed by
+// symbol. Operators without one, such as the bitwise ones, are errors in
+// complex mode.
+var complexBinary = map[string]func(a, b complex128) (complex128, error){
+	"+": func(a, b complex128) (complex128, error) { return a + b, nil },
+	"-": func(a, b complex128) (complex128, error) { return a - b, nil },
+	"*": func(a, b complex128) (complex128, error) { return a * b, nil },
+	"/": func(a, b complex128) (complex128, error) {
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return a / b, nil
+	},
+	"+%": func(a, b complex128) (complex128, error) { return a + a*b/100, nil },
+	"-%": func(a, b complex128) (complex128, error) { return a - a*b/100, nil },
+	"^":  func(a, b complex128) (complex128, error) { return powComplex(a, b), nil },
+}
+
+var complexUnary = map[string]func(x complex128) (complex128, error){
+	// 0 - x rather than -x, which would give the zero imaginary part of a
+	// real number a negative sign and put sqrt(-1) on the wrong side of
+	// its branch cut.
+	"-": func(x complex128) (complex128, error) { return 0 - x, nil },
+	"+": func(x complex128) (complex128, error) { return x, nil },
+	"√": func(x complex128) (complex128, error) { return cmplx.Sqrt(x), nil },
+	"∛": func(x complex128) (complex128, error) { return cbrtComplex(x), nil },
+}
+
+// complexFunctions replace the real functions of the same name in complex
+// mode. Other functions still work when their arguments are all real.
+var complexFunctions = map[string]func(x complex128) complex128{
+	"sqrt": cmplx.Sqrt,
+	"cbrt": cbrtComplex,
+	"exp":  cmplx.Exp,
+	"ln":   cmplx.Log,
+	"sin":  cmplx.Sin,
+	"cos":  cmplx.Cos,
+	"tan":  cmplx.Tan,
+	"sinh": cmplx.Sinh,
+	"cosh": cmplx.Cosh,
+	"tanh": cmplx.Tanh,
+	"re":   func(x complex128) complex128 { return complex(real(x), 0) },
+	"im":   func(x complex128) complex128 { return complex(imag(x), 0) },
+	"abs":  func(x complex128) complex128 { return complex(cmplx.Abs(x), 0) },
+	"arg":  func(x complex128) complex128 { return complex(cmplx.Phase(x), 0) },
+	"conj": cmplx.Conj,
+}
+
+// maxIntPower is the largest integer exponent powComplex multiplies out.
+const maxIntPower = 64
+
+// powComplex is a ^ b. A small integer power is multiplied out, so i^2 is
+// exactly -1 where cmplx.Pow, going through the logarithm, leaves an
+// imaginary part of about 1e-16.
+func powComplex(a, b complex128) complex128 {
+	n := real(b)
+	if imag(b) != 0 || n != math.Trunc(n) || math.Abs(n) > maxIntPower || a == 0 {
+		return cmplx.Pow(a, b)
+	}
+	result := complex(1, 0)
+	for k := 0; k < int(math.Abs(n)); k++ {
+		result *= a
+	}
+	if n < 0 {
+		return 1 / result
+	}
+	return result
+}
+
+// cbrtComplex keeps the real cube root for real arguments, so ∛-8 is still
+// -2, and takes the principal root otherwise.
+func cbrtComplex(x complex128) complex128 {
+	if imag(x) == 0 {
+		return complex(math.Cbrt(real(x)), 0)
+	}
+	return cmplx.Pow(x, 1.0/3)
+}
+
+// evaluateComplex is evaluatePostfix for complex mode, where the constant
+// i is the imaginary unit and every number is a complex128.
+func (c *Calculator) evaluateComplex(tokens []Token) (Value, error) {
+	var stack []Value
+
+	for _, token := range tokens {
+		if err := c.checkStep(len(stack), token); err != nil {
+			return Value{}, err
+		}
+		switch token.Type {
+		case NUMBER:
+			// Numbers written back by Explain may be complex already, as
+			// in (3+4i).
+			z, err := strconv.ParseComplex(token.Value, 128)
+			if err != nil {
+				return Value{}, errorAt(token.Pos, "invalid number %q at position %d", token.Value, token.Pos)
+			}
+			stack = append(stack, Value{Kind: ComplexValue, Complex: z})
+		case STRING:
+			return Value{}, fmt.Errorf("strings are not supported in complex mode")
+		case IDENTIFIER:
+			z, err := c.lookupComplex(token.Value)
+			if err != nil {
+				return Value{}, err
+			}
+			stack = append(stack, Value{Kind: ComplexValue, Complex: z})
+		case TARGET:
+			stack = append(stack, Value{Kind: targetValue, Str: token.Value})
+		case FUNCTION:
+			if token.Value == setFunction {
+				return Value{}, fmt.Errorf("sets are not supported in complex mode")
+			}
+			if token.Body != nil {
+				return Value{}, fmt.Errorf("series is not supported in complex mode")
+			}
+			if len(stack) < token.Args {
+				return Value{}, fmt.Errorf("not enough arguments for function %s", token.Value)
+			}
+			args := make([]complex128, token.Args)
+			for i, x := range stack[len(stack)-token.Args:] {
+				if x.Kind != ComplexValue {
+					return Value{}, fmt.Errorf("function %s expects a number, got assignment target %s", token.Value, x.Str)
+				}
+				args[i] = x.Complex
+			}
+			result, err := c.callComplex(token, args)
+			if err != nil {
+				return Value{}, fmt.Errorf("%s: %w", token.Value, err)
+			}
+			stack = append(stack[:len(stack)-token.Args], Value{Kind: ComplexValue, Complex: result})
+		case OPERATOR:
+			op := c.operators[token.Op]
+			if op.unary {
+				apply, ok := complexUnary[op.symbol]
+				if !ok {
+					return Value{}, fmt.Errorf("operator %s is not supported in complex mode", token.Value)
+				}
+				if len(stack) < 1 {
+					return Value{}, fmt.Errorf("not enough operands for operator %s", token.Value)
+				}
+				x := stack[len(stack)-1]
+				if x.Kind != ComplexValue {
+					return Value{}, fmt.Errorf("operator %s expects a number, got assignment target %s", token.Value, x.Str)
+				}
+				result, err := apply(x.Complex)
+				if err != nil {
+					return Value{}, errorAt(token.Pos, "%w at position %d", err, token.Pos)
+				}
+				stack[len(stack)-1] = Value{Kind: ComplexValue, Complex: result}
+				continue
+			}
+			if len(stack) < 2 {
+				return Value{}, fmt.Errorf("not enough operands for operator %s", token.Value)
+			}
+			a, b := stack[len(stack)-2], stack[len(stack)-1]
+			if op.assign {
+				if err := c.assign(a, b); err != nil {
+					return Value{}, errorAt(token.Pos, "%w at position %d", err, token.Pos)
+				}
+				stack = append(stack[:len(stack)-2], b)
+				continue
+			}
+			if op.sequence {
+				stack = append(stack[:len(stack)-2], b)
+				continue
+			}
+			apply, ok := complexBinary[op.symbol]
+			if !ok {
+				return Value{}, fmt.Errorf("operator %s is not supported in complex mode", token.Value)
+			}
+			for _, x := range []Value{a, b} {
+				if x.Kind != ComplexValue {
+					return Value{}, fmt.Errorf("operator %s expects a number, got assignment target %s", token.Value, x.Str)
+				}
+			}
+			result, err := apply(a.Complex, b.Complex)
+			if err != nil {
+				return Value{}, errorAt(token.Pos, "%w at position %d", err, token.Pos)
+			}
+			stack = append(stack[:len(stack)-2], Value{Kind: ComplexValue, Complex: result})
+		}
+	}
+
+	if len(stack) != 1 || stack[0].Kind != ComplexValue {
+		return Value{}, fmt.Errorf("invalid expression")
+	}
+
+	return stack[0], nil
+}
+
+func (c *Calculator) lookupComplex(name string) (complex128, error) {
+	if name == "i" {
+		if _, ok := c.vars[name]; !ok {
+			return complex(0, 1), nil
+		}
+	}
+	v, err := c.lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	switch v.Kind {
+	case ComplexValue:
+		return v.Complex, nil
+	case NumberValue:
+		return complex(v.Num, 0), nil
+	}
+	return 0, fmt.Errorf("%s is not a number", name)
+}
+
+// callComplex applies a function in complex mode, falling back to the real
+// implementation when every argument is real.
+func (c *Calculator) callComplex(token Token, args []complex128) (complex128, error) {
+	if fn, ok := complexFunctions[token.Value]; ok {
+		if token.Args != 1 {
+			return 0, fmt.Errorf("expects 1 argument, got %d", token.Args)
+		}
+		return fn(args[0]), nil
+	}
+
+	f, ok := c.functions[token.Value]
+	if !ok {
+		return 0, fmt.Errorf("unknown function")
+	}
+	if f.arity >= 0 && token.Args != f.arity {
+		return 0, fmt.Errorf("expects %d arguments, got %d", f.arity, token.Args)
+	}
+	values := make([]Value, len(args))
+	for i, z := range args {
+		if imag(z) != 0 {
+			return 0, fmt.Errorf("does not support complex arguments")
+		}
+		values[i] = Value{Num: real(z)}
+	}
+	result, err := c.call(token.Value, f, values)
+	if err != nil {
+		return 0, err
+	}
+	num, err := result.number("result")
+	if err != nil {
+		return 0, err
+	}
+	return complex(num, 0), nil
+}
+
+// formatComplex renders z as 3+4i, 3-4i, 4i or 3, with a bare i for a unit
+// imaginary part.
+func formatComplex(z complex128) string {
+	re, im := real(z), imag(z)
+	if im == 0 {
+		return strconv.FormatFloat(re, 'g', -1, 64)
+	}
+
+	imag := strconv.FormatFloat(im, 'g', -1, 64)
+	switch imag {
+	case "1":
+		imag = ""
+	case "-1":
+		imag = "-"
+	}
+	if re == 0 {
+		return imag + "i"
+	}
+	if im > 0 {
+		imag = "+" + imag
+	}
+	return strconv.FormatFloat(re, 'g', -1, 64) + imag + "i"
+}
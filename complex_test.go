@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+func TestComplex(t *testing.T) {
+	c := NewCalculator()
+	c.Complex = true
+	checkEval(t, c, []evalCase{
+		{"sqrt(-1)", "i"},
+		{"√-4", "2i"},
+		{"(3+4i)*(1-2i)", "11-2i"},
+		{"(1+2i)/(3-4i)", "-0.2+0.4i"},
+		{"(1+i) - (1+i)", "0"},
+		{"abs(3+4i)", "5"},
+		{"conj(3+4i)", "3-4i"},
+		{"re(3+4i) + im(3+4i)", "7"},
+		{"∛-8", "-2"},
+	})
+	checkEvalError(t, c, "1 / (i - i)", "division by zero")
+}
+
+func TestComplexAssignment(t *testing.T) {
+	c := NewCalculator()
+	c.Complex = true
+	checkEval(t, c, []evalCase{
+		{"z = 3+4i", "3+4i"},
+		{"a = b = 2i", "2i"},
+		{"a * b", "-4"},
+		// An assignment need not be the whole expression.
+		{"1 + (x = 2)", "3"},
+		{"x * i", "2i"},
+		{"x = 3, x + 1", "4"},
+		{"y = x + i, y * z", "5+15i"},
+	})
+	checkEvalError(t, c, "x = 1 = 2", "cannot assign to 1")
+}
+
+func TestComplexIntegerPowers(t *testing.T) {
+	c := NewCalculator()
+	c.Complex = true
+	checkEval(t, c, []evalCase{
+		{"i^2", "-1"},
+		{"i^3", "-i"},
+		{"i^4", "1"},
+		{"i^-1", "-i"},
+		{"(1+i)^2", "2i"},
+		{"(1+i)^-2", "-0.5i"},
+		{"2^10", "1024"},
+		{"0^0", "1"},
+		// Other exponents take the principal value.
+		{"i^0.5", "0.7071067811865476+0.7071067811865475i"},
+		{"i^i", "0.20787957635076193"},
+	})
+}
+
+func TestFormatComplex(t *testing.T) {
+	for _, tc := range []struct {
+		z    complex128
+		want string
+	}{
+		{complex(3, 4), "3+4i"},
+		{complex(3, -4), "3-4i"},
+		{complex(0, 4), "4i"},
+		{complex(0, 1), "i"},
+		{complex(0, -1), "-i"},
+		{complex(2, 1), "2+i"},
+		{complex(2, -1), "2-i"},
+		{complex(3, 0), "3"},
+		{complex(-0.5, 0), "-0.5"},
+		{complex(1e21, 1.5), "1e+21+1.5i"},
+	} {
+		if got := formatComplex(tc.z); got != tc.want {
+			t.Errorf("formatComplex(%v) = %q, want %q", tc.z, got, tc.want)
+		}
+	}
+}
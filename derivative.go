@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Derivative returns the derivative of expr with respect to variable as a
+// new expression, e.g. Derivative("x^2", "x") is "2 * x". Any identifier
+// other than variable is treated as a constant. The result is simplified
+// only by folding constants and dropping trivial terms such as "* 1" and
+// "+ 0".
+func (c *Calculator) Derivative(expr, variable string) (_ string, err error) {
+	defer recoverPanic(&err)
+
+	root, err := c.parse(expr)
+	if err != nil {
+		return "", err
+	}
+
+	d, err := c.differentiate(root, variable)
+	if err != nil {
+		return "", err
+	}
+
+	return c.format(d), nil
+}
+
+func (c *Calculator) differentiate(n *Node, x string) (*Node, error) {
+	switch n.Token.Type {
+	case NUMBER:
+		return number(0), nil
+	case IDENTIFIER:
+		if n.Token.Value == x {
+			return number(1), nil
+		}
+		return number(0), nil
+	case OPERATOR:
+		return c.differentiateOperator(n, x)
+	case FUNCTION:
+		return c.differentiateFunction(n, x)
+	}
+	return nil, fmt.Errorf("cannot differentiate %s", n.Token.Value)
+}
+
+func (c *Calculator) differentiateOperator(n *Node, x string) (*Node, error) {
+	if c.operators[n.Token.Op].unary {
+		du, err := c.differentiate(n.Args[0], x)
+		if err != nil {
+			return nil, err
+		}
+		switch n.Token.Value {
+		case "-":
+			return c.neg(du), nil
+		case "√":
+			return c.binary("*", c.binary("/", number(1), c.binary("*", number(2), n)), du), nil
+		case "∛":
+			return c.binary("*", c.binary("/", number(1), c.binary("*", number(3), c.binary("^", n, number(2)))), du), nil
+		}
+		return du, nil
+	}
+
+	u, v := n.Args[0], n.Args[1]
+	du, err := c.differentiate(u, x)
+	if err != nil {
+		return nil, err
+	}
+	dv, err := c.differentiate(v, x)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.Token.Value {
+	case "+", "-":
+		return c.binary(n.Token.Value, du, dv), nil
+	case "*":
+		// (u * v)' = u' * v + u * v'
+		return c.binary("+", c.binary("*", du, v), c.binary("*", u, dv)), nil
+	case "/":
+		// (u / v)' = (u' * v - u * v') / v ^ 2
+		return c.binary("/",
+			c.binary("-", c.binary("*", du, v), c.binary("*", u, dv)),
+			c.binary("^", v, number(2))), nil
+	case "^":
+		switch {
+		case !dependsOn(v, x):
+			// (u ^ k)' = k * u ^ (k - 1) * u'
+			return c.binary("*", c.binary("*", v, c.binary("^", u, c.binary("-", v, number(1)))), du), nil
+		case !dependsOn(u, x):
+			// (k ^ v)' = k ^ v * ln(k) * v'
+			return c.binary("*", c.binary("*", n, call("ln", u)), dv), nil
+		default:
+			// (u ^ v)' = u ^ v * (v' * ln(u) + v * u' / u)
+			return c.binary("*", n, c.binary("+",
+				c.binary("*", dv, call("ln", u)),
+				c.binary("/", c.binary("*", v, du), u))), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot differentiate operator %s", n.Token.Value)
+}
+
+func (c *Calculator) differentiateFunction(n *Node, x string) (*Node, error) {
+	if len(n.Args) != 1 {
+		return nil, fmt.Errorf("cannot differentiate function %s", n.Token.Value)
+	}
+	u := n.Args[0]
+	du, err := c.differentiate(u, x)
+	if err != nil {
+		return nil, err
+	}
+
+	// Chain rule: f(u)' = f'(u) * u'
+	var outer *Node
+	switch n.Token.Value {
+	case "sin":
+		outer = call("cos", u)
+	case "cos":
+		outer = c.neg(call("sin", u))
+	case "tan":
+		outer = c.binary("/", number(1), c.binary("^", call("cos", u), number(2)))
+	case "exp":
+		outer = n
+	case "ln":
+		outer = c.binary("/", number(1), u)
+	case "sqrt":
+		outer = c.binary("/", number(1), c.binary("*", number(2), n))
+	case "cbrt":
+		outer = c.binary("/", number(1), c.binary("*", number(3), c.binary("^", n, number(2))))
+	default:
+		return nil, fmt.Errorf("cannot differentiate function %s", n.Token.Value)
+	}
+	return c.binary("*", outer, du), nil
+}
+
+// dependsOn reports whether the identifier x occurs anywhere in n.
+func dependsOn(n *Node, x string) bool {
+	if n.Token.Type == IDENTIFIER && n.Token.Value == x {
+		return true
+	}
+	for _, arg := range n.Args {
+		if dependsOn(arg, x) {
+			return true
+		}
+	}
+	return false
+}
+
+func number(v float64) *Node {
+	return &Node{Token: Token{Type: NUMBER, Value: strconv.FormatFloat(v, 'g', -1, 64)}}
+}
+
+func call(name string, args ...*Node) *Node {
+	return &Node{Token: Token{Type: FUNCTION, Value: name, Args: len(args)}, Args: args}
+}
+
+// numberValue reports the value of a literal, seeing through a leading
+// unary minus so that -1 folds like the literal it denotes.
+func numberValue(n *Node) (float64, bool) {
+	if n.Token.Type == OPERATOR && len(n.Args) == 1 && n.Token.Value == "-" {
+		v, ok := numberValue(n.Args[0])
+		return -v, ok
+	}
+	if n.Token.Type != NUMBER {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(n.Token.Value, 64)
+	return v, err == nil
+}
+
+func isNumber(n *Node, v float64) bool {
+	got, ok := numberValue(n)
+	return ok && got == v
+}
+
+// neg builds -a, folding literals and double negations.
+func (c *Calculator) neg(a *Node) *Node {
+	if v, ok := numberValue(a); ok {
+		return number(-v)
+	}
+	if a.Token.Type == OPERATOR && c.operators[a.Token.Op].unary && a.Token.Value == "-" {
+		return a.Args[0]
+	}
+	return &Node{Token: Token{Type: OPERATOR, Value: "-", Op: c.unaryIDs["-"]}, Args: []*Node{a}}
+}
+
+// binary builds a op b, folding literal operands and the identities
+// x + 0, x - 0, x * 1, x * 0, x / 1, x ^ 1 and x ^ 0.
+func (c *Calculator) binary(op string, a, b *Node) *Node {
+	id := c.operatorIDs[op]
+	av, aNum := numberValue(a)
+	bv, bNum := numberValue(b)
+	if aNum && bNum {
+		if v, err := c.operators[id].apply(av, bv); err == nil {
+			return number(v)
+		}
+	}
+
+	switch op {
+	case "+":
+		if isNumber(a, 0) {
+			return b
+		}
+		if isNumber(b, 0) {
+			return a
+		}
+	case "-":
+		if isNumber(b, 0) {
+			return a
+		}
+		if isNumber(a, 0) {
+			return c.neg(b)
+		}
+	case "*":
+		if isNumber(a, 0) || isNumber(b, 0) {
+			return number(0)
+		}
+		if isNumber(a, 1) {
+			return b
+		}
+		if isNumber(b, 1) {
+			return a
+		}
+	case "/":
+		if isNumber(a, 0) {
+			return number(0)
+		}
+		if isNumber(b, 1) {
+			return a
+		}
+	case "^":
+		if isNumber(b, 0) {
+			return number(1)
+		}
+		if isNumber(b, 1) {
+			return a
+		}
+	}
+
+	return &Node{Token: Token{Type: OPERATOR, Value: op, Op: id}, Args: []*Node{a, b}}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestDerivative(t *testing.T) {
+	c := NewCalculator()
+	for _, tc := range []struct{ expr, want string }{
+		{"x^2", "2 * x"},
+		{"sin(x)", "cos(x)"},
+		{"cos(x)", "-sin(x)"},
+		{"ln(x)", "1 / x"},
+		{"5", "0"},
+		{"y * x", "y"},
+		{"3*x^3 + 2*x", "3 * (3 * x ^ 2) + 2"},
+		{"sin(x^2)", "cos(x ^ 2) * (2 * x)"},
+		{"sqrt(x)", "1 / (2 * sqrt(x))"},
+	} {
+		got, err := c.Derivative(tc.expr, "x")
+		if err != nil {
+			t.Errorf("Derivative(%q): %v", tc.expr, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Derivative(%q) = %q, want %q", tc.expr, got, tc.want)
+		}
+	}
+
+	if _, err := c.Derivative("abs(x)", "x"); err == nil {
+		t.Error("Derivative(\"abs(x)\") succeeded")
+	}
+}
+
+// TestDerivativeEvaluates checks the derivatives of a few functions
+// against their known values at x = 2.
+func TestDerivativeEvaluates(t *testing.T) {
+	c := NewCalculator()
+	x := regexp.MustCompile(`\bx\b`)
+	for expr, want := range map[string]string{
+		"x^3":      "12",
+		"x^x":      "6.772588722239782",
+		"exp(2*x)": "109.19630006628847",
+		"1 / x":    "-0.25",
+	} {
+		d, err := c.Derivative(expr, "x")
+		if err != nil {
+			t.Errorf("Derivative(%q): %v", expr, err)
+			continue
+		}
+		checkEval(t, c, []evalCase{{x.ReplaceAllString(d, "(2)"), want}})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import "math"
+
+// equivSamples is how many random bindings Equiv tries when the
+// expressions have variables, and equivRange bounds the values drawn:
+// each variable is uniform in [-equivRange, equivRange].
+const (
+	equivSamples = 32
+	equivRange   = 10
+)
+
+// equivTolerance is the relative tolerance of Equiv when Epsilon is
+// smaller, so that sin(x)^2 + cos(x)^2 is equivalent to 1 despite
+// rounding.
+const equivTolerance = 1e-9
+
+// Equiv reports whether a and b are the same expression: whether they
+// evaluate to the same value, within Epsilon or 1e-9, whichever is
+// larger. Expressions without variables are evaluated once. Otherwise
+// the free variables of both are bound to random values from c's random
+// source and the two are compared at each of 32 such points; a point
+// where both fail, as ln(x) does for negative x, is skipped, and one
+// where only one fails makes them different.
+//
+// This is a probabilistic check: expressions that differ only at a few
+// points, such as x/x and 1 at x = 0, or only outside [-10, 10], such as
+// x and min(x, 100), are reported equivalent. A false result is always
+// right; a true one is very likely but not certain.
+// Variables c already had are restored afterwards.
+func (c *Calculator) Equiv(a, b string) (_ bool, err error) {
+	defer recoverPanic(&err)
+
+	varsA, err := c.FreeVariables(a)
+	if err != nil {
+		return false, err
+	}
+	varsB, err := c.FreeVariables(b)
+	if err != nil {
+		return false, err
+	}
+	names := varsA
+	seen := make(map[string]bool)
+	for _, name := range varsA {
+		seen[name] = true
+	}
+	for _, name := range varsB {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+
+	saved := make(map[string]Value, len(c.vars))
+	for name, v := range c.vars {
+		saved[name] = v
+	}
+	defer func() { c.vars = saved }()
+
+	if len(names) == 0 {
+		x, err := c.Evaluate(a)
+		if err != nil {
+			return false, err
+		}
+		y, err := c.Evaluate(b)
+		if err != nil {
+			return false, err
+		}
+		return c.equivalent(x, y), nil
+	}
+
+	defined := false
+	var first error
+	for i := 0; i < equivSamples; i++ {
+		for _, name := range names {
+			x := (2*c.rng.Float64() - 1) * equivRange
+			if err := c.assign(Value{Kind: targetValue, Str: name}, Value{Num: x}); err != nil {
+				return false, err
+			}
+		}
+		x, errA := c.Evaluate(a)
+		y, errB := c.Evaluate(b)
+		if errA != nil && errB != nil {
+			if first == nil {
+				first = errA
+			}
+			continue
+		}
+		if errA != nil || errB != nil || !c.equivalent(x, y) {
+			return false, nil
+		}
+		defined = true
+	}
+	if !defined {
+		// Neither was defined anywhere, which is more likely a mistake,
+		// such as an unknown function, than a tiny domain.
+		return false, first
+	}
+	return true, nil
+}
+
+// equivalent compares two results for Equiv: numbers and complex numbers
+// within the tolerance, anything else by its text.
+func (c *Calculator) equivalent(x, y Value) bool {
+	tol := math.Max(c.Epsilon, equivTolerance)
+	near := func(a, b float64) bool {
+		if a == b || math.IsNaN(a) && math.IsNaN(b) {
+			return true
+		}
+		scale := math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+		return math.Abs(a-b) <= tol*scale
+	}
+	switch {
+	case x.Kind == NumberValue && y.Kind == NumberValue:
+		return near(x.Num, y.Num)
+	case x.Kind == ComplexValue && y.Kind == ComplexValue:
+		return near(real(x.Complex), real(y.Complex)) && near(imag(x.Complex), imag(y.Complex))
+	}
+	return x.Kind == y.Kind && x.String() == y.String()
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestEquiv(t *testing.T) {
+	c := NewCalculator()
+	c.Seed(1)
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{"2 + 2", "4", true},
+		{"0.1 + 0.2", "0.3", true},
+		{"2 + 2", "5", false},
+		{"(x + 1)^2", "x^2 + 2*x + 1", true},
+		{"sin(x)^2 + cos(x)^2", "1", true},
+		{"x * y", "y * x", true},
+		{"ln(x^2)", "2 * ln(sqrt(x^2))", true},
+		{"(x + 1)^2", "x^2 + 1", false},
+		{"x", "y", false},
+		// Defined only for positive x, where the two agree.
+		{"sqrt(x)^2", "x + 0 * sqrt(x)", true},
+		// One errors where the other does not.
+		{"sqrt(x)^2", "x", false},
+	} {
+		got, err := c.Equiv(tc.a, tc.b)
+		if err != nil {
+			t.Errorf("Equiv(%q, %q) error: %v", tc.a, tc.b, err)
+		} else if got != tc.want {
+			t.Errorf("Equiv(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+
+	// The variables Equiv binds do not outlive it.
+	checkEval(t, c, []evalCase{{"x = 3", "3"}})
+	if _, err := c.Equiv("x + y", "y + x"); err != nil {
+		t.Fatal(err)
+	}
+	checkEval(t, c, []evalCase{{"x", "3"}})
+	checkEvalError(t, c, "y", "unknown identifier 'y'")
+
+	if _, err := c.Equiv("nothere(x)", "nothere(x)"); err == nil {
+		t.Errorf("Equiv of an unknown function succeeded")
+	}
+	if _, err := c.Equiv("1 +", "1"); err == nil {
+		t.Errorf("Equiv of a malformed expression succeeded")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func ExampleCalculator_Calculate() {
+	c := NewCalculator()
+	for _, expr := range []string{"2 * (3 + 4)", "sqrt(16) + 2^3", "x = 5", "x * 2"} {
+		result, err := c.Calculate(expr)
+		if err != nil {
+			fmt.Println("error:", err)
+			continue
+		}
+		fmt.Println(result)
+	}
+	// Output:
+	// 14
+	// 12
+	// 5
+	// 10
+}
+
+func ExampleCalculator_Calculate_error() {
+	c := NewCalculator()
+	_, err := c.Calculate("1 + 2 / 0")
+	fmt.Println(err)
+
+	var perr *PositionError
+	if errors.As(err, &perr) {
+		fmt.Println("at position", perr.Pos)
+	}
+	// Output:
+	// division by zero at position 6
+	// at position 6
+}
+
+func ExampleCalculator_Evaluate() {
+	c := NewCalculator()
+	for _, expr := range []string{"7 / 2", `base("FF", 16, 2)`, "float(5)", "2 < 3"} {
+		v, err := c.Evaluate(expr)
+		if err != nil {
+			fmt.Println("error:", err)
+			continue
+		}
+		fmt.Println(v)
+	}
+	// Output:
+	// 3.5
+	// 11111111
+	// 5.0
+	// 1
+}
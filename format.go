@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// currency describes how --currency formats results.
+type currency struct {
+	symbol   string
+	decimals int
+}
+
+var currencies = map[string]currency{
+	"USD": {"$", 2},
+	"EUR": {"€", 2},
+	"GBP": {"£", 2},
+	"JPY": {"¥", 0},
+	"INR": {"₹", 2},
+	"CHF": {"CHF ", 2},
+}
+
+// isCurrencySign reports whether r is the symbol of one of the currencies,
+// which the lexer ignores before a number: $200 is 200.
+func isCurrencySign(r rune) bool {
+	for _, cur := range currencies {
+		if cur.symbol == string(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCurrency looks up a currency by its ISO 4217 code.
+func parseCurrency(code string) (currency, error) {
+	cur, ok := currencies[strings.ToUpper(code)]
+	if !ok {
+		return currency{}, fmt.Errorf("unknown currency %q, want USD, EUR, GBP, JPY, INR or CHF", code)
+	}
+	return cur, nil
+}
+
+// format renders x as an amount of cur, rounded by mode to the currency's
+// decimals and grouped in thousands: $1,234.57. Infinities and NaN are
+// written plainly.
+func (cur currency) format(x float64, mode RoundingMode) string {
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	sign := ""
+	if x < 0 {
+		sign, x = "-", -x
+	}
+	s := roundDecimal(strconv.FormatFloat(x, 'f', -1, 64), cur.decimals, mode)
+	if strings.Trim(s, "0.") == "" {
+		sign = ""
+	}
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i:]
+	}
+	return sign + cur.symbol + groupThousands(whole, ",") + frac
+}
+
+// RoundingMode selects how fixed and --currency round a number that
+// lies between two values of the last place kept. The modes follow the
+// magnitude, so -2.5 rounds to -3 wherever 2.5 rounds to 3.
+type RoundingMode int
+
+const (
+	// RoundHalfUp rounds ties away from zero: 2.5 to 3, 3.5 to 4. It is
+	// the default, as taught in school.
+	RoundHalfUp RoundingMode = iota
+	// RoundHalfEven rounds ties to an even last digit, 2.5 to 2 and 3.5
+	// to 4, so that rounding many values is not biased upwards.
+	RoundHalfEven
+	// RoundHalfDown rounds ties towards zero: 2.5 to 2, 3.5 to 3.
+	RoundHalfDown
+	// RoundTowardZero drops the extra digits: 2.9 to 2.
+	RoundTowardZero
+	// RoundAwayFromZero rounds anything beyond the last place up in
+	// magnitude: 2.1 to 3.
+	RoundAwayFromZero
+)
+
+// ParseRoundingMode maps the names "half-up", "half-even", "half-down",
+// "toward-zero" and "away-from-zero" to their RoundingMode.
+func ParseRoundingMode(name string) (RoundingMode, error) {
+	switch name {
+	case "half-up":
+		return RoundHalfUp, nil
+	case "half-even":
+		return RoundHalfEven, nil
+	case "half-down":
+		return RoundHalfDown, nil
+	case "toward-zero":
+		return RoundTowardZero, nil
+	case "away-from-zero":
+		return RoundAwayFromZero, nil
+	}
+	return 0, fmt.Errorf("unknown rounding mode %q, want half-up, half-even, half-down, toward-zero or away-from-zero", name)
+}
+
+// roundDecimal rounds the non-negative decimal s to exactly places digits
+// after the point. Working on the shortest decimal form of a float rounds
+// 1.005 to 1.01 half up, as written, where rounding the binary value would
+// give 1.00.
+func roundDecimal(s string, places int, mode RoundingMode) string {
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	for len(frac) < places {
+		frac += "0"
+	}
+	digits := []byte(whole + frac[:places])
+	if roundsUp(digits, frac[places:], mode) {
+		i := len(digits) - 1
+		for ; i >= 0 && digits[i] == '9'; i-- {
+			digits[i] = '0'
+		}
+		if i < 0 {
+			digits = append([]byte{'1'}, digits...)
+		} else {
+			digits[i]++
+		}
+	}
+	n := len(digits) - places
+	if places == 0 {
+		return string(digits)
+	}
+	return string(digits[:n]) + "." + string(digits[n:])
+}
+
+// roundsUp reports whether mode rounds kept, the digits kept, up in
+// magnitude given rest, the digits dropped after them.
+func roundsUp(kept []byte, rest string, mode RoundingMode) bool {
+	if strings.Trim(rest, "0") == "" {
+		return false
+	}
+	beyondHalf := strings.Trim(rest[1:], "0") != ""
+	switch mode {
+	case RoundHalfEven:
+		odd := len(kept) > 0 && (kept[len(kept)-1]-'0')%2 == 1
+		return rest[0] > '5' || (rest[0] == '5' && (beyondHalf || odd))
+	case RoundHalfDown:
+		return rest[0] > '5' || (rest[0] == '5' && beyondHalf)
+	case RoundTowardZero:
+		return false
+	case RoundAwayFromZero:
+		return true
+	}
+	return rest[0] >= '5'
+}
+
+// groupThousands inserts sep between each group of three digits of whole.
+func groupThousands(whole, sep string) string {
+	if len(whole) <= 3 {
+		return whole
+	}
+	var sb strings.Builder
+	first := len(whole) % 3
+	if first == 0 {
+		first = 3
+	}
+	sb.WriteString(whole[:first])
+	for i := first; i < len(whole); i += 3 {
+		sb.WriteString(sep)
+		sb.WriteString(whole[i : i+3])
+	}
+	return sb.String()
+}
+
+// grouped renders v like Value.String but in positional notation with sep
+// between each group of three integer digits: 1,234,567.5. Numbers of 1e21
+// or more, infinities and NaN keep their usual form.
+func grouped(v Value, sep string) string {
+	x := v.Num
+	if math.IsInf(x, 0) || math.IsNaN(x) || math.Abs(x) >= 1e21 {
+		return v.String()
+	}
+	s := strconv.FormatFloat(math.Abs(x), 'f', -1, 64)
+	switch v.Format {
+	case FormatInt:
+		s = strconv.FormatFloat(math.Abs(x), 'f', 0, 64)
+	case FormatFloat:
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+	}
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i:]
+	}
+	sign := ""
+	if math.Signbit(x) {
+		sign = "-"
+	}
+	return sign + groupThousands(whole, sep) + frac
+}
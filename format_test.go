@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCurrencyFormat(t *testing.T) {
+	usd, err := parseCurrency("usd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpy, err := parseCurrency("JPY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		cur  currency
+		x    float64
+		want string
+	}{
+		{usd, 1234.567, "$1,234.57"},
+		{usd, 1000000, "$1,000,000.00"},
+		{usd, 0, "$0.00"},
+		{usd, -1234.5, "-$1,234.50"},
+		// Half-cent ties round up, as the decimal is written.
+		{usd, 0.005, "$0.01"},
+		{usd, 0.125, "$0.13"},
+		{usd, 1.005, "$1.01"},
+		{usd, 2.675, "$2.68"},
+		{usd, 0.0049, "$0.00"},
+		{usd, -0.004, "$0.00"},
+		{usd, 999.995, "$1,000.00"},
+		{jpy, 1234.5, "¥1,235"},
+	} {
+		if got := tc.cur.format(tc.x, RoundHalfUp); got != tc.want {
+			t.Errorf("format(%v) = %q, want %q", tc.x, got, tc.want)
+		}
+	}
+	if _, err := parseCurrency("XYZ"); err == nil {
+		t.Error("parseCurrency(\"XYZ\") succeeded")
+	}
+}
+
+func TestCurrencyFlag(t *testing.T) {
+	if out, _, _ := run("", "--currency", "EUR", "1234.5 * 2"); out != "Result = €2,469.00\n" {
+		t.Errorf("output = %q", out)
+	}
+	if _, errout, code := run("", "--currency", "XYZ", "1"); code != 2 || errout == "" {
+		t.Errorf("unknown currency: %q, exit %d", errout, code)
+	}
+}
+
+func TestGrouped(t *testing.T) {
+	for _, tc := range []struct {
+		x    float64
+		sep  string
+		want string
+	}{
+		{0, ",", "0"},
+		{999, ",", "999"},
+		{1000, ",", "1,000"},
+		{1234567, ",", "1,234,567"},
+		{123456, ",", "123,456"},
+		{1234567.25, ",", "1,234,567.25"},
+		{0.0001234, ",", "0.0001234"},
+		{-1234.5, ",", "-1,234.5"},
+		{-999, ",", "-999"},
+		{1e20, ",", "100,000,000,000,000,000,000"},
+		{1e21, ",", "1e+21"},
+		{1234567, " ", "1 234 567"},
+		{1234567.5, "_", "1_234_567.5"},
+	} {
+		if got := grouped(Value{Num: tc.x}, tc.sep); got != tc.want {
+			t.Errorf("grouped(%v, %q) = %q, want %q", tc.x, tc.sep, got, tc.want)
+		}
+	}
+}
+
+func TestThousandsFlag(t *testing.T) {
+	if out, _, _ := run("", "--thousands", ",", "1234567 * 2"); out != "Result = 2,469,134\n" {
+		t.Errorf("output = %q", out)
+	}
+	if out, _, _ := run("", "1234567 * 2"); out != "Result = 2.469134e+06\n" {
+		t.Errorf("output without --thousands = %q", out)
+	}
+	if _, _, code := run("", "--thousands", ".", "1"); code != 2 {
+		t.Errorf("--thousands . exit %d, want 2", code)
+	}
+}
+
+func TestRoundingModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode RoundingMode
+		// The results of fixed(x, 0) for 0.5, 1.5, 2.5, -2.5 and 2.4,
+		// and of fixed(2.25, 1) and fixed(2.35, 1).
+		want [7]string
+	}{
+		{RoundHalfUp, [7]string{"1", "2", "3", "-3", "2", "2.3", "2.4"}},
+		{RoundHalfEven, [7]string{"0", "2", "2", "-2", "2", "2.2", "2.4"}},
+		{RoundHalfDown, [7]string{"0", "1", "2", "-2", "2", "2.2", "2.3"}},
+		{RoundTowardZero, [7]string{"0", "1", "2", "-2", "2", "2.2", "2.3"}},
+		{RoundAwayFromZero, [7]string{"1", "2", "3", "-3", "3", "2.3", "2.4"}},
+	} {
+		c := NewCalculator()
+		c.Rounding = tc.mode
+		checkEval(t, c, []evalCase{
+			{"fixed(0.5, 0)", tc.want[0]},
+			{"fixed(1.5, 0)", tc.want[1]},
+			{"fixed(2.5, 0)", tc.want[2]},
+			{"fixed(-2.5, 0)", tc.want[3]},
+			{"fixed(2.4, 0)", tc.want[4]},
+			{"fixed(2.25, 1)", tc.want[5]},
+			{"fixed(2.35, 1)", tc.want[6]},
+		})
+	}
+
+	usd, err := parseCurrency("USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for mode, want := range map[RoundingMode]string{
+		RoundHalfUp:       "$0.13",
+		RoundHalfEven:     "$0.12",
+		RoundHalfDown:     "$0.12",
+		RoundTowardZero:   "$0.12",
+		RoundAwayFromZero: "$0.13",
+	} {
+		if got := usd.format(0.125, mode); got != want {
+			t.Errorf("format(0.125, %v) = %q, want %q", mode, got, want)
+		}
+	}
+}
+
+func TestRoundingFlag(t *testing.T) {
+	if out, _, _ := run("", "--rounding", "half-even", "fixed(2.5, 0)"); out != "Result = 2\n" {
+		t.Errorf("--rounding half-even output = %q", out)
+	}
+	if _, errout, code := run("", "--rounding", "up", "1"); code != 2 || !strings.Contains(errout, `unknown rounding mode "up"`) {
+		t.Errorf("--rounding up: %q, exit %d", errout, code)
+	}
+}
+
+func TestPercentAndCurrencyLiterals(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"15%", "0.15"},
+		{"0.5%", "0.005"},
+		{"150%", "1.5"},
+		{"15% * $200", "30"},
+		{"€50 + £25", "75"},
+		{"¥1000 * 8%", "80"},
+		{"$200 - 10% * $200", "180"},
+	})
+	for _, input := range []string{"15 %", "(15)%", "$ 20", "$x"} {
+		if _, err := c.Evaluate(input); err == nil {
+			t.Errorf("Evaluate(%q) succeeded", input)
+		}
+	}
+
+	// The literal stays exact in rational mode.
+	c.Rational = true
+	checkEval(t, c, []evalCase{{"0.1%", "1/1000"}, {"15% * $200", "30"}})
+
+	if out, _, _ := run("", "--currency", "USD", "15% * $200"); out != "Result = $30.00\n" {
+		t.Errorf("--currency output = %q", out)
+	}
+}
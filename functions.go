@@ -0,0 +1,558 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// function is a builtin callable from expressions, e.g. pct(20, 150).
+// An arity of -1 accepts any number of arguments. Most functions are
+// numeric and set fn; the few that take or return strings set valueFn.
+type function struct {
+	arity   int
+	fn      func(args []float64) (float64, error)
+	valueFn func(args []Value) (Value, error)
+	// registered marks functions added with RegisterFunction, which may
+	// be replaced; builtins may not.
+	registered bool
+	// volatile marks builtins such as rand whose result can differ
+	// between calls with the same arguments, so they are neither cached
+	// nor folded.
+	volatile bool
+}
+
+// ErrBuiltinName is returned when RegisterFunction or Define is asked to
+// replace a builtin function or shadow a constant.
+var ErrBuiltinName = errors.New("name is reserved by a builtin")
+
+// RegisterFunction makes fn callable from expressions as name. An arity of
+// -1 accepts any number of arguments; otherwise calls with a different
+// number of arguments are an error. Builtin functions and constants cannot
+// be replaced, but registering a name again replaces the function
+// previously registered under it.
+func (c *Calculator) RegisterFunction(name string, arity int, fn func([]float64) (float64, error)) error {
+	runes := []rune(name)
+	if len(runes) == 0 || !isIdentStart(runes[0]) {
+		return fmt.Errorf("invalid function name %q", name)
+	}
+	for _, r := range runes {
+		if !isIdentPart(r) {
+			return fmt.Errorf("invalid function name %q", name)
+		}
+	}
+	if arity < -1 {
+		return fmt.Errorf("invalid arity %d for %s", arity, name)
+	}
+	if fn == nil {
+		return fmt.Errorf("nil function for %s", name)
+	}
+	if existing, ok := c.functions[name]; (ok && !existing.registered) || name == "eval" || name == "series" {
+		return fmt.Errorf("%w: %s", ErrBuiltinName, name)
+	}
+	if _, ok := c.constants[name]; ok {
+		return fmt.Errorf("%w: %s", ErrBuiltinName, name)
+	}
+
+	delete(c.aliases, name)
+	c.functions[name] = function{arity: arity, fn: fn, registered: true}
+	return nil
+}
+
+// RegisterAlias makes alias another name for the function name, builtin
+// or registered, so that with RegisterAlias("rt", "sqrt") rt(9) is 3.
+// Calls of the alias are read as calls of the function itself, so they
+// differentiate, simplify and print as it does. An alias of an alias
+// names the function behind it, and registering an alias again points
+// it elsewhere. Only calls are affected: rt without parentheses is still
+// a variable.
+func (c *Calculator) RegisterAlias(alias, name string) error {
+	runes := []rune(alias)
+	if len(runes) == 0 || !isIdentStart(runes[0]) {
+		return fmt.Errorf("invalid alias %q", alias)
+	}
+	for _, r := range runes {
+		if !isIdentPart(r) {
+			return fmt.Errorf("invalid alias %q", alias)
+		}
+	}
+	if target, ok := c.aliases[name]; ok {
+		name = target
+	}
+	_, isFunc := c.functions[name]
+	_, isComplex := complexFunctions[name]
+	if !isFunc && !isComplex && name != "eval" && name != "series" {
+		return fmt.Errorf("unknown function %s", name)
+	}
+	if alias == name {
+		return fmt.Errorf("%s cannot be an alias of itself", alias)
+	}
+	_, isFunc = c.functions[alias]
+	_, isComplex = complexFunctions[alias]
+	_, isMacro := c.macros[alias]
+	if isFunc || isComplex || isMacro || alias == "eval" || alias == "series" {
+		return fmt.Errorf("%w: %s", ErrBuiltinName, alias)
+	}
+	if _, ok := c.constants[alias]; ok {
+		return fmt.Errorf("%w: %s", ErrBuiltinName, alias)
+	}
+
+	c.aliases[alias] = name
+	return nil
+}
+
+// resolveAliases renames calls of aliases to the functions they stand for.
+func (c *Calculator) resolveAliases(tokens []Token) {
+	if len(c.aliases) == 0 {
+		return
+	}
+	for i, t := range tokens {
+		if t.Type != IDENTIFIER || i+1 == len(tokens) || tokens[i+1].Type != LPAREN {
+			continue
+		}
+		if name, ok := c.aliases[t.Value]; ok {
+			tokens[i].Value = name
+		}
+	}
+}
+
+var functions = map[string]function{
+	// pct(percent, base) = percent/100 * base, so pct(20, 150) is 30
+	"pct": {arity: 2, fn: func(args []float64) (float64, error) {
+		return args[0] / 100 * args[1], nil
+	}},
+	// pctchange(old, new) is the change from old to new in percent, so
+	// pctchange(50, 75) is 50.
+	"pctchange": {arity: 2, fn: func(args []float64) (float64, error) {
+		if args[0] == 0 {
+			return 0, fmt.Errorf("old value must not be 0")
+		}
+		return (args[1] - args[0]) / args[0] * 100, nil
+	}},
+	// cagr(begin, end, years) is the compound annual growth rate in
+	// percent, so cagr(100, 121, 2) is 10.
+	"cagr": {arity: 3, fn: func(args []float64) (float64, error) {
+		begin, end, years := args[0], args[1], args[2]
+		if years <= 0 {
+			return 0, fmt.Errorf("years must be positive, got %v", years)
+		}
+		if begin == 0 || end/begin < 0 {
+			return 0, fmt.Errorf("begin and end must be non-zero with the same sign")
+		}
+		return (math.Pow(end/begin, 1/years) - 1) * 100, nil
+	}},
+	"sin":  unary(math.Sin),
+	"cos":  unary(math.Cos),
+	"tan":  unary(math.Tan),
+	"exp":  unary(math.Exp),
+	"ln":   unary(math.Log),
+	"sqrt": unary(math.Sqrt),
+	// cbrt is defined for negatives, cbrt(-8) = -2, where -8^(1/3) is not.
+	"cbrt":  unary(math.Cbrt),
+	"sinh":  unary(math.Sinh),
+	"cosh":  unary(math.Cosh),
+	"tanh":  unary(math.Tanh),
+	"asinh": unary(math.Asinh),
+	"acosh": domain(math.Acosh, func(x float64) bool { return x >= 1 }, "x >= 1"),
+	"atanh": domain(math.Atanh, func(x float64) bool { return x > -1 && x < 1 }, "-1 < x < 1"),
+	// int truncates towards zero, so int(7/2) is 3 and int(-7/2) is -3.
+	"int": {arity: 1, valueFn: func(args []Value) (Value, error) {
+		x, err := args[0].number("argument 1")
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Num: math.Trunc(x), Format: FormatInt}, nil
+	}},
+	// float keeps its argument but prints it with a fractional part.
+	"float": {arity: 1, valueFn: func(args []Value) (Value, error) {
+		x, err := args[0].number("argument 1")
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Num: x, Format: FormatFloat}, nil
+	}},
+	// base(n, from, to) rewrites n from one base to another, e.g.
+	// base("FF", 16, 2) is "11111111".
+	"base": {arity: 3, valueFn: baseConvert},
+	// The bit-counting functions work on the 64-bit two's complement form
+	// of an integer, as the bitwise operators do, so popcount(-1) is 64.
+	"popcount": bitCount(bits.OnesCount64),
+	"clz":      bitCount(bits.LeadingZeros64),
+	"ctz":      bitCount(bits.TrailingZeros64),
+	// isprime(n) is 1 if the integer n is prime and 0 otherwise, and
+	// nextprime(n) the smallest prime greater than n.
+	"isprime": {arity: 1, valueFn: func(args []Value) (Value, error) {
+		n, err := primeArgument(args[0])
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Num: truth(isPrime(n)), Format: FormatBool}, nil
+	}},
+	"nextprime": {arity: 1, fn: func(args []float64) (float64, error) {
+		n, err := primeArgument(Value{Num: args[0]})
+		if err != nil {
+			return 0, err
+		}
+		if n < 2 {
+			return 2, nil
+		}
+		for p := n + 1; p <= maxExactInt; p++ {
+			if isPrime(p) {
+				return float64(p), nil
+			}
+		}
+		return 0, fmt.Errorf("no prime after %d below 2^53", n)
+	}},
+	// fact(n) is n! for an integer n >= 0, multiplied out exactly and
+	// rounded once, so fact(20) is exact and fact(50) the float64 nearest
+	// to 50!. From fact(171) it overflows.
+	"fact": {arity: 1, fn: func(args []float64) (float64, error) {
+		n := args[0]
+		if n != math.Trunc(n) || n < 0 {
+			return 0, fmt.Errorf("expects a non-negative integer, got %v", n)
+		}
+		if n > maxFloatFactorial {
+			return math.Inf(1), nil
+		}
+		x, err := factorial(int64(n))
+		if err != nil {
+			return 0, err
+		}
+		f, _ := new(big.Float).SetInt(x).Float64()
+		return f, nil
+	}},
+	// tofraction(x) is the simplest fraction that is x as a float64, so
+	// tofraction(0.75) is 3/4 and tofraction(1/3) is 1/3, and
+	// rationalize(x, maxdenom) the fraction nearest to that one with a
+	// denominator of at most maxdenom, so rationalize(pi, 100) is 311/99.
+	"tofraction": {arity: 1, valueFn: func(args []Value) (Value, error) {
+		x, err := args[0].number("argument 1")
+		if err != nil {
+			return Value{}, err
+		}
+		r, err := toFraction(x)
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Kind: RationalValue, Rat: r}, nil
+	}},
+	"rationalize": {arity: 2, valueFn: func(args []Value) (Value, error) {
+		x, err := args[0].number("argument 1")
+		if err != nil {
+			return Value{}, err
+		}
+		max, err := args[1].number("argument 2")
+		if err != nil {
+			return Value{}, err
+		}
+		if max != math.Trunc(max) || max < 1 || max > maxExactInt {
+			return Value{}, fmt.Errorf("expects a maximum denominator from 1 to 2^53, got %v", max)
+		}
+		r, err := toFraction(x)
+		if err != nil {
+			return Value{}, err
+		}
+		r = limitDenominator(r, big.NewInt(int64(max)))
+		return Value{Kind: RationalValue, Rat: r}, nil
+	}},
+	// polar(x, y) is the pair (r, θ) of the point (x, y) in polar
+	// coordinates, θ in radians, and rect(r, θ) converts back. divmod(a, b)
+	// is the pair of the quotient rounded down and the remainder, whose
+	// sign follows b: divmod(7, -2) is (-4, -1).
+	"polar": pair(func(x, y float64) (float64, float64, error) {
+		return math.Hypot(x, y), math.Atan2(y, x), nil
+	}),
+	"rect": pair(func(r, theta float64) (float64, float64, error) {
+		return r * math.Cos(theta), r * math.Sin(theta), nil
+	}),
+	// wrap(θ) reduces an angle in radians to [0, 2π) and wrappi(θ) to
+	// [-π, π); wrapdeg and wrap180 do the same in degrees, to [0, 360) and
+	// [-180, 180). Negative angles wrap upwards, so wrapdeg(-90) is 270.
+	"wrap":    unary(func(x float64) float64 { return wrapAngle(x, 2*math.Pi, false) }),
+	"wrappi":  unary(func(x float64) float64 { return wrapAngle(x, 2*math.Pi, true) }),
+	"wrapdeg": unary(func(x float64) float64 { return wrapAngle(x, 360, false) }),
+	"wrap180": unary(func(x float64) float64 { return wrapAngle(x, 360, true) }),
+	"divmod": pair(func(a, b float64) (float64, float64, error) {
+		if b == 0 {
+			return 0, 0, fmt.Errorf("division by zero")
+		}
+		q := math.Floor(a / b)
+		return q, a - q*b, nil
+	}),
+	// The statistics functions take any number of arguments. variance and
+	// stddev are the sample statistics, dividing by n-1; variancep and
+	// stddevp are the population ones, dividing by n.
+	"mean":      {arity: -1, fn: mean},
+	"median":    {arity: -1, fn: median},
+	"variance":  {arity: -1, fn: variance(1)},
+	"variancep": {arity: -1, fn: variance(0)},
+	"stddev":    {arity: -1, fn: stddev(1)},
+	"stddevp":   {arity: -1, fn: stddev(0)},
+	setFunction: {arity: -1, valueFn: makeSet},
+}
+
+// unary adapts a one-argument math function to the function table.
+func unary(fn func(float64) float64) function {
+	return function{arity: 1, fn: func(args []float64) (float64, error) {
+		return fn(args[0]), nil
+	}}
+}
+
+// domain is like unary but rejects arguments outside the function's
+// domain, described by want, instead of returning NaN or Inf.
+func domain(fn func(float64) float64, valid func(float64) bool, want string) function {
+	return function{arity: 1, fn: func(args []float64) (float64, error) {
+		if !valid(args[0]) {
+			return 0, fmt.Errorf("argument %v out of domain, want %s", args[0], want)
+		}
+		return fn(args[0]), nil
+	}}
+}
+
+// wrapAngle reduces x modulo period into [0, period), or into
+// [-period/2, period/2) if signed. math.Mod keeps the sign of x, so a
+// negative remainder is moved up by a period; when that rounds to period
+// itself, for a remainder smaller than its precision, the result is 0.
+func wrapAngle(x, period float64, signed bool) float64 {
+	r := math.Mod(x, period)
+	if r < 0 {
+		r += period
+	}
+	if r >= period {
+		r = 0
+	}
+	if signed && r >= period/2 {
+		r -= period
+	}
+	return r
+}
+
+// pair adapts a function of two numbers returning two to the function
+// table, giving a TupleValue.
+func pair(fn func(a, b float64) (float64, float64, error)) function {
+	return function{arity: 2, valueFn: func(args []Value) (Value, error) {
+		a, err := args[0].number("argument 1")
+		if err != nil {
+			return Value{}, err
+		}
+		b, err := args[1].number("argument 2")
+		if err != nil {
+			return Value{}, err
+		}
+		x, y, err := fn(a, b)
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Kind: TupleValue, Tuple: []float64{x, y}}, nil
+	}}
+}
+
+// bitCount adapts a function counting bits of a uint64 to the function
+// table, rejecting arguments that are not 64-bit integers.
+func bitCount(fn func(uint64) int) function {
+	return function{arity: 1, fn: func(args []float64) (float64, error) {
+		x := args[0]
+		if x != math.Trunc(x) || math.IsInf(x, 0) {
+			return 0, fmt.Errorf("requires an integer, got %v", x)
+		}
+		if x < math.MinInt64 || x >= math.MaxInt64 {
+			return 0, fmt.Errorf("argument %v overflows 64 bits", x)
+		}
+		return float64(fn(uint64(int64(x)))), nil
+	}}
+}
+
+// maxExactInt is 2^53, beyond which float64 cannot hold every integer.
+const maxExactInt = 1 << 53
+
+// maxFloatFactorial is the largest n whose n! a float64 holds.
+const maxFloatFactorial = 170
+
+// factorial returns n! exactly, for fact. Like the powers of rational mode
+// its result is limited to maxRationalBits bits.
+func factorial(n int64) (*big.Int, error) {
+	if lg, _ := math.Lgamma(float64(n) + 1); lg/math.Ln2 > maxRationalBits {
+		return nil, fmt.Errorf("%d! exceeds %d bits", n, maxRationalBits)
+	}
+	return new(big.Int).MulRange(1, n), nil
+}
+
+// primeArgument checks that v is an integer a float64 holds exactly.
+func primeArgument(v Value) (int64, error) {
+	x, err := v.number("argument 1")
+	if err != nil {
+		return 0, err
+	}
+	if x != math.Trunc(x) || math.IsInf(x, 0) {
+		return 0, fmt.Errorf("requires an integer, got %v", x)
+	}
+	if math.Abs(x) > maxExactInt {
+		return 0, fmt.Errorf("argument %v is beyond 2^53", x)
+	}
+	return int64(x), nil
+}
+
+// isPrime reports whether n is prime. ProbablyPrime(0) is exact for
+// numbers below 2^64.
+func isPrime(n int64) bool {
+	return n >= 2 && big.NewInt(n).ProbablyPrime(0)
+}
+
+// fixed rounds x to args[1] decimal places by c.Rounding. It rounds the
+// decimal digits of x as written, like --currency does, so fixed(2.005, 2)
+// is 2.01 even though the float64 nearest 2.005 is slightly below it.
+func (c *Calculator) fixed(args []float64) (float64, error) {
+	x, n := args[0], args[1]
+	if n != math.Trunc(n) || n < 0 {
+		return 0, fmt.Errorf("decimal places must be a non-negative integer, got %v", n)
+	}
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return x, nil
+	}
+	s := strconv.FormatFloat(math.Abs(x), 'f', -1, 64)
+	if i := strings.IndexByte(s, '.'); i < 0 || float64(len(s)-i-1) <= n {
+		return x, nil
+	}
+	rounded, err := strconv.ParseFloat(roundDecimal(s, int(n), c.Rounding), 64)
+	if err != nil || rounded == 0 {
+		// Rounding -0.001 to 0 places gives 0, not -0.
+		return 0, err
+	}
+	return math.Copysign(rounded, x), nil
+}
+
+func mean(args []float64) (float64, error) {
+	if len(args) == 0 {
+		return 0, fmt.Errorf("needs at least 1 argument")
+	}
+	sum := 0.0
+	for _, x := range args {
+		sum += x
+	}
+	return sum / float64(len(args)), nil
+}
+
+func median(args []float64) (float64, error) {
+	if len(args) == 0 {
+		return 0, fmt.Errorf("needs at least 1 argument")
+	}
+	sorted := append([]float64(nil), args...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2, nil
+	}
+	return sorted[mid], nil
+}
+
+// variance returns the variance function that divides the sum of squared
+// deviations by n - ddof: 1 for the sample variance, 0 for the population
+// variance.
+func variance(ddof int) func(args []float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		if len(args) <= ddof {
+			return 0, fmt.Errorf("needs at least %d arguments", ddof+1)
+		}
+		m, _ := mean(args)
+		sum := 0.0
+		for _, x := range args {
+			sum += (x - m) * (x - m)
+		}
+		return sum / float64(len(args)-ddof), nil
+	}
+}
+
+func stddev(ddof int) func(args []float64) (float64, error) {
+	v := variance(ddof)
+	return func(args []float64) (float64, error) {
+		x, err := v(args)
+		return math.Sqrt(x), err
+	}
+}
+
+// call applies f to args, checking that numeric functions only receive
+// numbers.
+func (c *Calculator) call(name string, f function, args []Value) (Value, error) {
+	if f.valueFn != nil {
+		return f.valueFn(args)
+	}
+
+	nums := make([]float64, len(args))
+	for i, arg := range args {
+		num, err := arg.number("argument " + strconv.Itoa(i+1))
+		if err != nil {
+			return Value{}, err
+		}
+		nums[i] = num
+	}
+
+	result, err := f.fn(nums)
+	if err != nil {
+		return Value{}, err
+	}
+	if math.IsNaN(result) && c.NaN == NaNError {
+		if err := nanError(nums); err != nil {
+			return Value{}, err
+		}
+	}
+	result, err = c.checkOverflow(result, nums...)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{Num: result}, nil
+}
+
+// nanError returns the error of NaNError for a NaN result of a function
+// called with args, or nil if an argument is NaN itself.
+func nanError(args []float64) error {
+	text := make([]string, len(args))
+	for i, x := range args {
+		if math.IsNaN(x) {
+			return nil
+		}
+		text[i] = strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	switch len(args) {
+	case 0:
+		return ErrNaN
+	case 1:
+		return fmt.Errorf("%w for argument %s", ErrNaN, text[0])
+	}
+	return fmt.Errorf("%w for arguments %s", ErrNaN, strings.Join(text, ", "))
+}
+
+func baseConvert(args []Value) (Value, error) {
+	var bases [2]int
+	for i, arg := range args[1:] {
+		b, err := arg.number("base")
+		if err != nil {
+			return Value{}, err
+		}
+		if b != math.Trunc(b) || b < 2 || b > 36 {
+			return Value{}, fmt.Errorf("base must be an integer in [2, 36], got %v", b)
+		}
+		bases[i] = int(b)
+	}
+
+	// A number argument is read by its decimal digits, so base(1010, 2, 10)
+	// treats 1010 as binary.
+	digits := args[0].Str
+	if args[0].Kind != StringValue {
+		if args[0].Num != math.Trunc(args[0].Num) {
+			return Value{}, fmt.Errorf("cannot convert non-integer %v", args[0].Num)
+		}
+		digits = strconv.FormatFloat(args[0].Num, 'f', -1, 64)
+	}
+
+	n, err := strconv.ParseInt(digits, bases[0], 64)
+	if err != nil {
+		return Value{}, fmt.Errorf("%q is not a base %d integer", digits, bases[0])
+	}
+
+	return Value{Kind: StringValue, Str: strings.ToUpper(strconv.FormatInt(n, bases[1]))}, nil
+}
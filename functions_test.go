@@ -0,0 +1,450 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestPct(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"pct(20, 150)", "30"},
+		{"pct(150, 40)", "60"},
+		{"pct(-10, 50)", "-5"},
+		{"pct(0, 99)", "0"},
+		// A 15% tip on a bill of 80.
+		{"80 + pct(15, 80)", "92"},
+		// 25% off 60.
+		{"60 - pct(25, 60)", "45"},
+	})
+	checkEvalError(t, c, "pct(20)", "pct expects 2 arguments, got 1")
+}
+
+func TestBase(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{`base("FF", 16, 2)`, "11111111"},
+		{`base("ff", 16, 10)`, "255"},
+		{`base("255", 10, 16)`, "FF"},
+		{`base("777", 8, 10)`, "511"},
+		{`base("-1010", 2, 10)`, "-10"},
+		{`base("z", 36, 10)`, "35"},
+		{`base("ff", 16, 36)`, "73"},
+		{`base(255, 10, 2)`, "11111111"},
+	})
+	v, err := c.Evaluate(`base("FF", 16, 10)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Kind != StringValue {
+		t.Errorf("base returned a %v, want a string", v.Kind)
+	}
+	checkEvalError(t, c, `base("12", 1, 10)`, "base must be an integer in [2, 36], got 1")
+	checkEvalError(t, c, `base("12", 10, 37)`, "base must be an integer in [2, 36], got 37")
+	checkEvalError(t, c, `base("G", 16, 10)`, `"G" is not a base 16 integer`)
+}
+
+func TestCbrt(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"cbrt(27)", "3"},
+		{"cbrt(0.125)", "0.5"},
+		{"cbrt(-8)", "-2"},
+		{"cbrt(-27) + cbrt(27)", "0"},
+		{"cbrt(0)", "0"},
+	})
+}
+
+func TestHyperbolic(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"sinh(1)", "1.1752011936438014"},
+		{"cosh(1)", "1.5430806348152437"},
+		{"tanh(1)", "0.7615941559557649"},
+		{"sinh(0)", "0"},
+		{"cosh(0)", "1"},
+		{"asinh(1)", "0.881373587019543"},
+		{"acosh(1)", "0"},
+		{"atanh(0.5)", "0.5493061443340548"},
+		{"asinh(sinh(2))", "2"},
+	})
+	checkEvalError(t, c, "acosh(0.5)", "acosh: argument 0.5 out of domain, want x >= 1")
+	checkEvalError(t, c, "atanh(1)", "atanh: argument 1 out of domain, want -1 < x < 1")
+	checkEvalError(t, c, "atanh(-2)", "atanh: argument -2 out of domain, want -1 < x < 1")
+	checkEvalError(t, c, "sinh(1, 2)", "sinh expects 1 argument")
+}
+
+func TestIntAndFloat(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		// int truncates toward zero.
+		{"int(7/2)", "3"},
+		{"int(2.9)", "2"},
+		{"int(-7/2)", "-3"},
+		{"int(-2.9)", "-2"},
+		{"float(5)", "5.0"},
+		{"float(int(3.7))", "3.0"},
+		{"float(2.5)", "2.5"},
+		// The annotation only formats the result of the call.
+		{"float(5) + 1", "6"},
+	})
+}
+
+func TestBusinessFunctions(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"pctchange(50, 75)", "50"},
+		{"pctchange(80, 60)", "-25"},
+		{"pctchange(-10, -5)", "-50"},
+		{"cagr(100, 200, 3)", "25.992104989487295"},
+		{"cagr(100, 121, 2)", "10.000000000000009"},
+		{"cagr(200, 100, 1)", "-50"},
+	})
+	checkEvalError(t, c, "pctchange(0, 5)", "pctchange: old value must not be 0")
+	checkEvalError(t, c, "cagr(100, 200, 0)", "cagr: years must be positive, got 0")
+	checkEvalError(t, c, "cagr(0, 200, 2)", "cagr: begin and end must be non-zero with the same sign")
+	checkEvalError(t, c, "cagr(-100, 200, 2)", "cagr: begin and end must be non-zero with the same sign")
+}
+
+func TestRegisterFunction(t *testing.T) {
+	c := NewCalculator()
+	hyp := func(args []float64) (float64, error) {
+		return math.Hypot(args[0], args[1]), nil
+	}
+	if err := c.RegisterFunction("hyp", 2, hyp); err != nil {
+		t.Fatal(err)
+	}
+	sum := func(args []float64) (float64, error) {
+		total := 0.0
+		for _, x := range args {
+			total += x
+		}
+		return total, nil
+	}
+	if err := c.RegisterFunction("total", -1, sum); err != nil {
+		t.Fatal(err)
+	}
+	checkEval(t, c, []evalCase{
+		{"hyp(3, 4)", "5"},
+		{"2 * hyp(5, 12) + 1", "27"},
+		{"total(1, 2, 3, 4)", "10"},
+		{"total()", "0"},
+	})
+	checkEvalError(t, c, "hyp(3)", "hyp expects 2 arguments, got 1")
+	checkEvalError(t, c, "nothere(1)", "unknown function 'nothere'")
+
+	// A registered function can be replaced; a builtin cannot.
+	double := func(args []float64) (float64, error) { return 2 * args[0], nil }
+	if err := c.RegisterFunction("hyp", 1, double); err != nil {
+		t.Fatal(err)
+	}
+	checkEval(t, c, []evalCase{{"hyp(4)", "8"}})
+	for _, name := range []string{"sqrt", "pi", "eval"} {
+		if err := c.RegisterFunction(name, 1, double); !errors.Is(err, ErrBuiltinName) {
+			t.Errorf("RegisterFunction(%q) error = %v, want ErrBuiltinName", name, err)
+		}
+	}
+	for _, name := range []string{"", "2x", "a-b"} {
+		if err := c.RegisterFunction(name, 1, double); err == nil {
+			t.Errorf("RegisterFunction(%q) succeeded", name)
+		}
+	}
+}
+
+func TestStatistics(t *testing.T) {
+	c := NewCalculator()
+	// The classic dataset with a population standard deviation of 2.
+	const data = "2, 4, 4, 4, 5, 5, 7, 9"
+	checkEval(t, c, []evalCase{
+		{"mean(" + data + ")", "5"},
+		{"median(" + data + ")", "4.5"},
+		{"median(3, 1, 2)", "2"},
+		{"variancep(" + data + ")", "4"},
+		{"stddevp(" + data + ")", "2"},
+		{"variance(" + data + ")", "4.571428571428571"},
+		{"stddev(" + data + ")", "2.138089935299395"},
+		{"mean(5)", "5"},
+		{"stddevp(1)", "0"},
+		{"mean(1, 2) + median(10, 20, 30)", "21.5"},
+	})
+	checkEvalError(t, c, "mean()", "mean: needs at least 1 argument")
+	checkEvalError(t, c, "median()", "median: needs at least 1 argument")
+	checkEvalError(t, c, "stddev(1)", "stddev: needs at least 2 arguments")
+	checkEvalError(t, c, "variance(1)", "variance: needs at least 2 arguments")
+}
+
+func TestBitCounting(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"popcount(0)", "0"},
+		{"popcount(255)", "8"},
+		{"popcount(-1)", "64"},
+		{"clz(1)", "63"},
+		{"clz(0)", "64"},
+		{"clz(2^62)", "1"},
+		{"ctz(0)", "64"},
+		{"ctz(8)", "3"},
+		{"ctz(-8)", "3"},
+	})
+	checkEvalError(t, c, "popcount(1.5)", "popcount: requires an integer, got 1.5")
+	checkEvalError(t, c, "clz(2^63)", "clz: argument 9.223372036854776e+18 overflows 64 bits")
+	checkEvalError(t, c, "ctz(inf)", "ctz:")
+}
+
+func TestPrimes(t *testing.T) {
+	c := NewCalculator()
+	for n, prime := range map[string]bool{
+		"2": true, "3": true, "5": true, "97": true,
+		"1": false, "0": false, "-7": false, "4": false, "91": false, "561": false,
+		"2147483647":       true, // 2^31 - 1
+		"1000000007":       true,
+		"1000000008":       false,
+		"9007199254740881": true, // the largest prime below 2^53
+	} {
+		want := "0"
+		if prime {
+			want = "1"
+		}
+		checkEval(t, c, []evalCase{{"isprime(" + n + ")", want}})
+	}
+	checkEval(t, c, []evalCase{
+		{"nextprime(0)", "2"},
+		{"nextprime(-5)", "2"},
+		{"nextprime(2)", "3"},
+		{"nextprime(7)", "11"},
+		{"nextprime(13)", "17"},
+		{"nextprime(1000000)", "1.000003e+06"},
+	})
+	checkEvalError(t, c, "isprime(2.5)", "isprime: requires an integer, got 2.5")
+	checkEvalError(t, c, "nextprime(1.5)", "nextprime: requires an integer, got 1.5")
+}
+
+func TestFixed(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"fixed(3.14159, 2)", "3.14"},
+		{"fixed(3.14159, 0)", "3"},
+		{"fixed(2.5, 0)", "3"},
+		{"fixed(-2.5, 0)", "-3"},
+		// The float64 nearest 2.005 is below it, but the digits as
+		// written round up.
+		{"fixed(2.005, 2)", "2.01"},
+		{"fixed(1.25, 5)", "1.25"},
+		{"fixed(-0.001, 0)", "0"},
+		// The result is a number for further arithmetic.
+		{"fixed(1/3, 2) * 3", "0.99"},
+	})
+	checkEvalError(t, c, "fixed(1.5, -1)", "decimal places must be a non-negative integer, got -1")
+	checkEvalError(t, c, "fixed(1.5, 0.5)", "decimal places must be a non-negative integer, got 0.5")
+}
+
+func TestTuples(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"polar(3, 4)", "(5, 0.9272952180016122)"},
+		{"rect(2, 0)", "(2, 0)"},
+		{"divmod(7, 2)", "(3, 1)"},
+		{"divmod(7, -2)", "(-4, -1)"},
+		{"divmod(-7, 2)", "(-4, 1)"},
+		{"p = divmod(7, 2)", "(3, 1)"},
+		{"p", "(3, 1)"},
+	})
+	v, err := c.Evaluate("polar(3, 4)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Kind != TupleValue || len(v.Tuple) != 2 || v.Tuple[0] != 5 {
+		t.Errorf("polar(3, 4) = %#v, want a TupleValue of 5 and θ", v)
+	}
+	if _, err := c.Calculate("polar(3, 4)"); err == nil {
+		t.Errorf("Calculate of a tuple succeeded")
+	}
+	checkEvalError(t, c, "polar(3, 4) + 1", "operator + expects a number, got tuple (5, 0.9272952180016122)")
+	checkEvalError(t, c, "sqrt(divmod(7, 2))", "sqrt: argument 1 expects a number, got tuple (3, 1)")
+	checkEvalError(t, c, "divmod(7, 0)", "divmod: division by zero")
+}
+
+func TestRegisterAlias(t *testing.T) {
+	c := NewCalculator()
+	for _, names := range [][2]string{{"rt", "sqrt"}, {"sq", "sqrt"}, {"root", "rt"}} {
+		if err := c.RegisterAlias(names[0], names[1]); err != nil {
+			t.Fatalf("RegisterAlias(%q, %q): %v", names[0], names[1], err)
+		}
+	}
+	checkEval(t, c, []evalCase{
+		{"sqrt(9)", "3"},
+		{"rt(9)", "3"},
+		{"sq(16) + rt(4)", "6"},
+		// An alias of an alias names the function behind it.
+		{"root(25)", "5"},
+		// Only calls are affected: rt alone is still a variable.
+		{"rt = 2", "2"},
+		{"rt(rt * 8)", "4"},
+	})
+	if got, err := c.Explain("rt(9)"); err != nil || !strings.Contains(got, "sqrt(9) = 3") {
+		t.Errorf("Explain(rt(9)) = %q, %v, want the call as sqrt", got, err)
+	}
+
+	// Registering the alias again points it elsewhere.
+	if err := c.RegisterAlias("rt", "cbrt"); err != nil {
+		t.Fatal(err)
+	}
+	checkEval(t, c, []evalCase{{"rt(27)", "3"}})
+
+	for _, tc := range []struct{ alias, name string }{
+		{"r", "nothere"},
+		{"2r", "sqrt"},
+		{"sqrt", "sqrt"},
+	} {
+		if err := c.RegisterAlias(tc.alias, tc.name); err == nil {
+			t.Errorf("RegisterAlias(%q, %q) succeeded", tc.alias, tc.name)
+		}
+	}
+	for _, alias := range []string{"sin", "pi"} {
+		if err := c.RegisterAlias(alias, "sqrt"); !errors.Is(err, ErrBuiltinName) {
+			t.Errorf("RegisterAlias(%q) error = %v, want ErrBuiltinName", alias, err)
+		}
+	}
+}
+
+func TestFactorial(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"fact(0)", "1"},
+		{"fact(5)", "120"},
+		{"fact(171)", "+Inf"},
+		{"fact(fixed(0.1*3*10, 0))", "6"},
+	})
+	// 20! is under 2^63 and a float64 holds it exactly; 50! is rounded
+	// once, to the float64 nearest it.
+	f, err := c.Calculate("fact(20)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != 2432902008176640000 {
+		t.Errorf("fact(20) = %v, want 2432902008176640000", f)
+	}
+	exact, _ := new(big.Int).SetString("30414093201713378043612608166064768844377641568960512000000000000", 10)
+	want, _ := new(big.Float).SetInt(exact).Float64()
+	if f, err := c.Calculate("fact(50)"); err != nil || f != want {
+		t.Errorf("fact(50) = %v, %v, want %v", f, err, want)
+	}
+	checkEvalError(t, c, "fact(-1)", "expects a non-negative integer, got -1")
+	checkEvalError(t, c, "fact(2.5)", "expects a non-negative integer, got 2.5")
+	checkEvalError(t, c, "fact(0.1*3*10)", "expects a non-negative integer, got 3.0000000000000004")
+
+	c.Rational = true
+	checkEval(t, c, []evalCase{
+		{"fact(20)", "2432902008176640000"},
+		{"fact(50)", exact.String()},
+		{"fact(5) / fact(3)", "20"},
+	})
+	checkEvalError(t, c, "fact(100000)", "exceeds 65536 bits")
+}
+
+func TestOperatorFunctions(t *testing.T) {
+	c := NewCalculator()
+	for call, expr := range map[string]string{
+		"add(3, 4)":                 "3 + 4",
+		"sub(5, 2)":                 "5 - 2",
+		"mul(2, 6)":                 "2 * 6",
+		"div(10, 4)":                "10 / 4",
+		"pow(2, 10)":                "2 ^ 10",
+		"pow(-8, 1/3)":              "(-8) ^ (1/3)",
+		"add(0.1, 0.2)":             "0.1 + 0.2",
+		"mul(add(1, 2), sub(7, 3))": "(1 + 2) * (7 - 3)",
+	} {
+		want, err := c.Evaluate(expr)
+		if err != nil {
+			t.Fatalf("Evaluate(%q): %v", expr, err)
+		}
+		checkEval(t, c, []evalCase{{call, want.String()}})
+	}
+	checkEvalError(t, c, "div(1, 0)", "division by zero")
+	checkEvalError(t, c, "add(1)", "add expects 2 arguments, got 1")
+
+	// They follow the overflow mode as the operators do.
+	c.Overflow = OverflowError
+	checkEvalError(t, c, "mul(1e308, 10)", "overflow")
+}
+
+func TestNaNPolicy(t *testing.T) {
+	c := NewCalculator()
+	nan := func(args []float64) (float64, error) { return math.NaN(), nil }
+	if err := c.RegisterFunction("nanof", 2, nan); err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range []string{"ln(-1)", "sqrt(-1)", "√-1", "sin(inf)", "1 + ln(-2) * 0", "nanof(1, 2)"} {
+		_, err := c.Evaluate(input)
+		if !errors.Is(err, ErrNaN) {
+			t.Errorf("Evaluate(%q) error = %v, want ErrNaN", input, err)
+		}
+	}
+	checkEvalError(t, c, "ln(-1)", "ln: result is not a number for argument -1")
+	checkEvalError(t, c, "sqrt(-4)", "sqrt: result is not a number for argument -4")
+	checkEvalError(t, c, "nanof(1, 2)", "nanof: result is not a number for arguments 1, 2")
+	checkEvalError(t, c, "√-4 + 1", "√: result is not a number for argument -4")
+	checkEval(t, c, []evalCase{
+		// NaN from an operator, or passed to a function, is not the
+		// function's doing.
+		{"inf - inf", "NaN"},
+		{"sqrt(inf - inf)", "NaN"},
+		{"sqrt(4)", "2"},
+	})
+	checkEvalError(t, c, "acosh(0.5)", "acosh: argument 0.5 out of domain, want x >= 1")
+
+	c.NaN = NaNPropagate
+	checkEval(t, c, []evalCase{
+		{"ln(-1)", "NaN"},
+		{"sqrt(-1)", "NaN"},
+		{"√-1", "NaN"},
+		{"sin(inf)", "NaN"},
+		{"1 + ln(-2) * 0", "NaN"},
+		{"nanof(1, 2)", "NaN"},
+		{"inf - inf", "NaN"},
+	})
+	checkEvalError(t, c, "acosh(0.5)", "acosh: argument 0.5 out of domain, want x >= 1")
+}
+
+func TestParseNaNPolicy(t *testing.T) {
+	for name, want := range map[string]NaNPolicy{"error": NaNError, "propagate": NaNPropagate} {
+		if got, err := ParseNaNPolicy(name); err != nil || got != want {
+			t.Errorf("ParseNaNPolicy(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseNaNPolicy("ignore"); err == nil {
+		t.Errorf("ParseNaNPolicy(ignore) succeeded")
+	}
+	if out, _, _ := run("", "--nan", "propagate", "ln(-1)"); out != "Result = NaN\n" {
+		t.Errorf("--nan propagate output = %q", out)
+	}
+}
+
+func TestWrapAngles(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"wrap(0)", "0"},
+		{"wrap(2*pi)", "0"},
+		{"wrap(-pi/2)", "4.71238898038469"},
+		{"wrap(1e6)", "5.925621140132833"},
+		{"wrap(-1e6)", "0.3575641670467533"},
+		// Too small to move up by a period without becoming 2π.
+		{"wrap(-1e-20)", "0"},
+		{"wrappi(3*pi)", "-3.141592653589793"},
+		{"wrappi(pi)", "-3.141592653589793"},
+		{"wrappi(-pi)", "-3.141592653589793"},
+		{"wrappi(1e6)", "-0.3575641670467533"},
+		{"wrapdeg(-90)", "270"},
+		{"wrapdeg(720)", "0"},
+		{"wrapdeg(1e6)", "280"},
+		{"wrapdeg(-1e6)", "80"},
+		{"wrap180(180)", "-180"},
+		{"wrap180(-181)", "179"},
+		{"wrap180(1e9 + 180)", "100"},
+		{"wrap180(-1e9)", "80"},
+	})
+	checkEvalError(t, c, "wrap(inf)", "wrap: result is not a number for argument +Inf")
+}
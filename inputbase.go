@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+)
+
+// basePrefixLetters are the prefixes that override InputBase for one
+// literal, as in 0x1F.
+var basePrefixLetters = map[rune]int{
+	'x': 16, 'X': 16,
+	'o': 8, 'O': 8,
+	'b': 2, 'B': 2,
+}
+
+// digitValue is the value of r as a digit in bases up to 36, or 36 if it
+// is not one.
+func digitValue(r rune) int {
+	switch {
+	case r >= '0' && r <= '9':
+		return int(r - '0')
+	case r >= 'a' && r <= 'z':
+		return int(r-'a') + 10
+	case r >= 'A' && r <= 'Z':
+		return int(r-'A') + 10
+	}
+	return 36
+}
+
+// lexBaseLiteral reads the integer literal at runes[i] when InputBase is
+// set, returning its token, the index after it, and whether there was
+// one. A literal is a run of digits of the base starting with 0-9, an
+// identifier made only of such digits (so in base 16 FF is 255 and e is
+// 14), or 0x, 0o or 0b followed by digits of that base, unless the prefix
+// letter is itself a digit of the input base. Fractions are an error.
+func (c *Calculator) lexBaseLiteral(runes []rune, i int) (Token, int, bool, error) {
+	base, start := c.InputBase, i
+	r := runes[i]
+	if r == '.' {
+		return Token{}, 0, false, errorAt(i, "fractional number at position %d in base %d input", i, c.InputBase)
+	}
+	if isIdentStart(r) {
+		// A word is a number only if all of it is digits.
+		end := i
+		for end < len(runes) && isIdentPart(runes[end]) {
+			if digitValue(runes[end]) >= base {
+				return Token{}, 0, false, nil
+			}
+			end++
+		}
+		return c.baseToken(runes[start:end], base, start, end)
+	}
+	if r < '0' || r > '9' {
+		return Token{}, 0, false, nil
+	}
+
+	if r == '0' && i+2 < len(runes) {
+		if b, ok := basePrefixLetters[runes[i+1]]; ok && digitValue(runes[i+1]) >= base && digitValue(runes[i+2]) < b {
+			base, i = b, i+2
+		}
+	}
+	digits := i
+	for i < len(runes) && digitValue(runes[i]) < base {
+		i++
+	}
+	if i < len(runes) && (runes[i] == '.' || (runes[i] >= '0' && runes[i] <= '9')) {
+		if runes[i] == '.' {
+			return Token{}, 0, false, errorAt(i, "fractional number at position %d in base %d input", i, c.InputBase)
+		}
+		return Token{}, 0, false, errorAt(i, "digit %c at position %d is not valid in base %d", runes[i], i, base)
+	}
+	tok, _, ok, err := c.baseToken(runes[digits:i], base, start, i)
+	return tok, i, ok, err
+}
+
+// baseToken converts the digits of a base literal starting at pos to a
+// decimal NUMBER token.
+func (c *Calculator) baseToken(digits []rune, base, pos, end int) (Token, int, bool, error) {
+	if c.MaxLiteralLength > 0 && len(digits) > c.MaxLiteralLength {
+		return Token{}, 0, false, errorAt(pos, "%w: number at position %d exceeds %d characters", ErrLiteralTooLong, pos, c.MaxLiteralLength)
+	}
+	n, ok := new(big.Int).SetString(strings.ToLower(string(digits)), base)
+	if !ok {
+		return Token{}, 0, false, errorAt(pos, "invalid base %d number at position %d", base, pos)
+	}
+	return Token{Type: NUMBER, Value: n.String(), Pos: pos}, end, true, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestInputBase(t *testing.T) {
+	c := NewCalculator()
+	c.InputBase = 2
+	checkEval(t, c, []evalCase{
+		{"101 + 1", "6"},
+		{"11 * 11", "9"},
+		{"0x1F", "31"},
+	})
+	checkEvalError(t, c, "102", "digit 2 at position 2 is not valid in base 2")
+
+	c.InputBase = 8
+	checkEval(t, c, []evalCase{
+		{"17 + 1", "16"},
+		{"777", "511"},
+		{"0b101", "5"},
+	})
+	checkEvalError(t, c, "19", "digit 9 at position 1 is not valid in base 8")
+	checkEvalError(t, c, "1.5", "fractional number at position 1 in base 8 input")
+
+	c.InputBase = 16
+	checkEval(t, c, []evalCase{
+		{"FF + 1", "256"},
+		{"ff", "255"},
+		{"e", "14"},
+		{"face", "64206"},
+		{"10 * 10", "256"},
+		// b is a digit of the base, so 0b11 is not binary.
+		{"0b11", "2833"},
+		{"0o17", "15"},
+		// A word with letters outside the base is still a name.
+		{"sqrt(100)", "16"},
+	})
+	checkEvalError(t, c, ".5", "fractional number at position 0 in base 16 input")
+}
+
+func TestBaseInputFlag(t *testing.T) {
+	if out, errout, code := run("", "--base-input", "16", "FF + 1"); out != "Result = 256\n" || code != 0 {
+		t.Errorf("--base-input 16 = %q, %q, exit %d", out, errout, code)
+	}
+	if _, errout, code := run("", "--base-input", "1", "1"); code != 2 || errout != "Error: invalid input base 1, want 2 to 36\n" {
+		t.Errorf("--base-input 1 = %q, exit %d", errout, code)
+	}
+}
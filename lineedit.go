@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"unicode"
+)
+
+// lineEditor is the state of the line being typed at a terminal: its text,
+// the cursor within it and the earlier lines the up and down arrows recall.
+// It knows nothing of the terminal itself, which readLine drives.
+type lineEditor struct {
+	buf    []rune
+	cursor int
+	// history holds the lines entered so far, oldest first, and pos is
+	// the one shown, len(history) while editing a new line.
+	history []string
+	pos     int
+	// draft keeps the new line while an older one is shown.
+	draft []rune
+}
+
+// Keys other than printable characters, as lineEditor.key takes them.
+const (
+	keyUp = -1 - iota
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyBackspace
+	keyDelete
+)
+
+// key applies one key press and reports whether it finished the line.
+func (e *lineEditor) key(k rune) bool {
+	switch k {
+	case '\r', '\n':
+		return true
+	case keyUp:
+		if e.pos > 0 {
+			if e.pos == len(e.history) {
+				e.draft = e.buf
+			}
+			e.pos--
+			e.show([]rune(e.history[e.pos]))
+		}
+	case keyDown:
+		switch {
+		case e.pos < len(e.history)-1:
+			e.pos++
+			e.show([]rune(e.history[e.pos]))
+		case e.pos == len(e.history)-1:
+			e.pos++
+			e.show(e.draft)
+		}
+	case keyLeft:
+		if e.cursor > 0 {
+			e.cursor--
+		}
+	case keyRight:
+		if e.cursor < len(e.buf) {
+			e.cursor++
+		}
+	case keyHome:
+		e.cursor = 0
+	case keyEnd:
+		e.cursor = len(e.buf)
+	case keyBackspace:
+		if e.cursor > 0 {
+			e.buf = append(e.buf[:e.cursor-1], e.buf[e.cursor:]...)
+			e.cursor--
+		}
+	case keyDelete:
+		if e.cursor < len(e.buf) {
+			e.buf = append(e.buf[:e.cursor], e.buf[e.cursor+1:]...)
+		}
+	default:
+		if k >= 0 && unicode.IsPrint(k) {
+			e.buf = append(e.buf[:e.cursor], append([]rune{k}, e.buf[e.cursor:]...)...)
+			e.cursor++
+		}
+	}
+	return false
+}
+
+// show replaces the text with a copy of line, the cursor at its end.
+func (e *lineEditor) show(line []rune) {
+	e.buf = append([]rune(nil), line...)
+	e.cursor = len(e.buf)
+}
+
+// finish returns the line typed and starts a new one, adding the line to
+// the history unless it is blank or repeats the last one.
+func (e *lineEditor) finish() string {
+	line := string(e.buf)
+	if line != "" && (len(e.history) == 0 || e.history[len(e.history)-1] != line) {
+		e.history = append(e.history, line)
+	}
+	e.buf, e.cursor, e.draft = nil, 0, nil
+	e.pos = len(e.history)
+	return line
+}
+
+// terminalReader reads lines from a terminal in cbreak mode, editing them
+// with a lineEditor so that the arrow keys move through the line and its
+// history.
+type terminalReader struct {
+	in     *bufio.Reader
+	out    io.Writer
+	editor lineEditor
+}
+
+// readLine reads one line after printing prompt. It returns io.EOF for
+// Ctrl-D on an empty line.
+func (t *terminalReader) readLine(prompt string) (string, error) {
+	e := &t.editor
+	t.redraw(prompt)
+	for {
+		r, _, err := t.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		k := r
+		switch r {
+		case 4: // Ctrl-D
+			if len(e.buf) == 0 {
+				fmt.Fprintln(t.out)
+				return "", io.EOF
+			}
+			k = keyDelete
+		case 1: // Ctrl-A
+			k = keyHome
+		case 5: // Ctrl-E
+			k = keyEnd
+		case 8, 127:
+			k = keyBackspace
+		case 27:
+			k = t.escape()
+		}
+		if e.key(k) {
+			fmt.Fprintln(t.out)
+			return e.finish(), nil
+		}
+		t.redraw(prompt)
+	}
+}
+
+// escape reads the rest of an escape sequence such as ESC [ A, the up
+// arrow, and returns its key, or 0 for one it does not know.
+func (t *terminalReader) escape() rune {
+	r, _, err := t.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return 0
+	}
+	r, _, err = t.in.ReadRune()
+	if err != nil {
+		return 0
+	}
+	switch r {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	case '3':
+		// ESC [ 3 ~ is the Delete key.
+		if r, _, err := t.in.ReadRune(); err == nil && r == '~' {
+			return keyDelete
+		}
+	}
+	return 0
+}
+
+// redraw rewrites the current terminal line as prompt and the text, with
+// the cursor in place.
+func (t *terminalReader) redraw(prompt string) {
+	e := &t.editor
+	fmt.Fprintf(t.out, "\r\x1b[K%s%s", prompt, string(e.buf))
+	if back := len(e.buf) - e.cursor; back > 0 {
+		fmt.Fprintf(t.out, "\x1b[%dD", back)
+	}
+}
+
+// lineSource returns a function reading the lines of in for Run, printing
+// prompt before each one if it is not empty. When in and out are both
+// terminals it edits lines with history; otherwise, or if the terminal
+// cannot be switched to cbreak mode, it reads plain lines. The returned
+// restore function puts the terminal back as it was.
+func lineSource(in io.Reader, out io.Writer) (read func(prompt string) (string, error), restore func()) {
+	if f, ok := in.(*os.File); ok && isTerminal(in) && isTerminal(out) {
+		if restore, err := cbreak(f); err == nil {
+			t := &terminalReader{in: bufio.NewReader(f), out: out}
+			return t.readLine, restore
+		}
+	}
+
+	scanner := bufio.NewScanner(in)
+	read = func(prompt string) (string, error) {
+		if prompt != "" {
+			fmt.Fprint(out, prompt)
+		}
+		if scanner.Scan() {
+			return scanner.Text(), nil
+		}
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return read, func() {}
+}
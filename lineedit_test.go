@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+// typeKeys presses each of keys on e, finishing the line at a newline,
+// and returns the lines finished.
+func typeKeys(e *lineEditor, keys ...rune) []string {
+	var lines []string
+	for _, k := range keys {
+		if e.key(k) {
+			lines = append(lines, e.finish())
+		}
+	}
+	return lines
+}
+
+func TestLineEditorHistory(t *testing.T) {
+	e := &lineEditor{}
+	typeKeys(e, []rune("1+1\n2+2\n3+3\n")...)
+	if got := strings.Join(e.history, " | "); got != "1+1 | 2+2 | 3+3" {
+		t.Fatalf("history = %s", got)
+	}
+
+	for _, tc := range []struct {
+		keys []rune
+		want string
+	}{
+		{[]rune{keyUp}, "3+3"},
+		{[]rune{keyUp, keyUp}, "2+2"},
+		{[]rune{keyUp, keyUp, keyUp}, "1+1"},
+		// Up at the oldest line stays there.
+		{[]rune{keyUp, keyUp, keyUp, keyUp}, "1+1"},
+		{[]rune{keyUp, keyUp, keyDown}, "3+3"},
+		// Down past the newest line brings back the draft.
+		{append([]rune("4+"), keyUp, keyUp, keyDown, keyDown), "4+"},
+		{append([]rune("4+"), keyDown), "4+"},
+		// A recalled line can be edited.
+		{[]rune{keyUp, keyBackspace, '9'}, "3+9"},
+		{[]rune{keyUp, keyHome, keyDelete, '7'}, "7+3"},
+		{[]rune{keyUp, keyLeft, keyLeft, '0'}, "30+3"},
+	} {
+		typeKeys(e, tc.keys...)
+		if got := string(e.buf); got != tc.want {
+			t.Errorf("after %q the line is %q, want %q", tc.keys, got, tc.want)
+		}
+		if e.cursor > len(e.buf) {
+			t.Errorf("after %q the cursor is at %d of %d", tc.keys, e.cursor, len(e.buf))
+		}
+		// Abandon the line without adding it to the history.
+		e.buf = nil
+		e.finish()
+	}
+
+	// Editing a recalled line leaves the history as it was.
+	if got := strings.Join(e.history, " | "); got != "1+1 | 2+2 | 3+3" {
+		t.Errorf("history after editing = %s", got)
+	}
+	// Finishing adds the line, but not a blank or a repeat of the last.
+	typeKeys(e, keyUp, keyUp, '\n', '\n', keyUp, '\n')
+	if got := strings.Join(e.history, " | "); got != "1+1 | 2+2 | 3+3 | 2+2" {
+		t.Errorf("history = %s", got)
+	}
+}
+
+func TestTerminalReader(t *testing.T) {
+	// Two lines, then the up arrow twice, Ctrl-A, a digit, Enter, then
+	// Ctrl-D.
+	input := "1+2\r3*4\r\x1b[A\x1b[A\x015\r\x04"
+	r := &terminalReader{in: bufio.NewReader(strings.NewReader(input)), out: io.Discard}
+	var lines []string
+	for {
+		line, err := r.readLine("> ")
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if got := strings.Join(lines, " | "); got != "1+2 | 3*4 | 51+2" {
+		t.Errorf("lines = %s", got)
+	}
+}
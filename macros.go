@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxMacroDepth bounds nested macro expansion, which also stops a macro
+// that invokes itself.
+const maxMacroDepth = 64
+
+// macro is a named expression with parameters, defined with
+// def name(params) = body and expanded textually where it is invoked.
+type macro struct {
+	params []string
+	body   []Token
+}
+
+// Define adds or replaces a macro from a definition such as
+//
+//	def monthly(rate, n) = rate / 12 * n
+//
+// where the leading "def" is optional. Invoking monthly(0.06, 24) then
+// evaluates (0.06) / 12 * (24): each argument replaces its parameter as a
+// parenthesized expression, and any other identifiers in the body refer to
+// whatever variables exist when the macro is invoked.
+func (c *Calculator) Define(definition string) error {
+	definition = strings.TrimSpace(definition)
+	definition = strings.TrimSpace(strings.TrimPrefix(definition, "def "))
+
+	eq := strings.Index(definition, "=")
+	if eq < 0 {
+		return fmt.Errorf("macro definition needs the form name(params) = body")
+	}
+	// The header is lexed rather than scanned so that an alias being
+	// redefined keeps its own name.
+	header, err := c.lex(definition[:eq], false)
+	if err == nil {
+		header, err = c.implicitMultiply(header)
+	}
+	if err != nil {
+		return err
+	}
+	if len(header) < 3 || header[0].Type != IDENTIFIER || header[1].Type != LPAREN || header[len(header)-1].Type != RPAREN {
+		return fmt.Errorf("macro definition needs the form name(params) = body")
+	}
+	name := header[0].Value
+	if _, ok := c.functions[name]; ok || name == "eval" || name == "series" {
+		return fmt.Errorf("%w: %s", ErrBuiltinName, name)
+	}
+	if _, ok := c.constants[name]; ok {
+		return fmt.Errorf("%w: %s", ErrBuiltinName, name)
+	}
+	if target, ok := c.aliases[name]; ok {
+		return fmt.Errorf("%s is an alias of %s", name, target)
+	}
+
+	var params []string
+	seen := make(map[string]bool)
+	for i, t := range header[2 : len(header)-1] {
+		if i%2 == 1 {
+			if t.Type != COMMA {
+				return fmt.Errorf("macro parameters must be separated by commas")
+			}
+			continue
+		}
+		if t.Type != IDENTIFIER {
+			return fmt.Errorf("macro parameter %q is not a name", t.Value)
+		}
+		if seen[t.Value] {
+			return fmt.Errorf("duplicate macro parameter %s", t.Value)
+		}
+		seen[t.Value] = true
+		params = append(params, t.Value)
+	}
+	if len(header) > 3 && len(params) != (len(header)-2)/2 {
+		return fmt.Errorf("macro parameters must be separated by commas")
+	}
+
+	body, err := c.scanExpression(definition[eq+1:])
+	if err != nil {
+		return fmt.Errorf("macro %s: %w", name, err)
+	}
+
+	c.macros[name] = macro{params: params, body: body}
+	return nil
+}
+
+// Bind stores expr unevaluated under name, as name := expr does in the
+// REPL. eval(name) then evaluates it with the variables as they are at that
+// point, so after
+//
+//	total := price * qty
+//
+// eval(total) follows later changes to price and qty. Assigning a value to
+// name with = replaces the binding, and binding replaces a variable.
+func (c *Calculator) Bind(name, expr string) error {
+	runes := []rune(name)
+	if len(runes) == 0 || !isIdentStart(runes[0]) {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	for _, r := range runes {
+		if !isIdentPart(r) {
+			return fmt.Errorf("invalid name %q", name)
+		}
+	}
+	if _, ok := c.constants[name]; ok {
+		return fmt.Errorf("cannot assign to constant %s", name)
+	}
+
+	body, err := c.scanExpression(expr)
+	if err != nil {
+		return err
+	}
+
+	c.exprs[name] = body
+	delete(c.vars, name)
+	return nil
+}
+
+// scanExpression scans a macro body or bound expression, checking that it
+// parses.
+func (c *Calculator) scanExpression(expr string) ([]Token, error) {
+	tokens, err := c.scan(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	postfix, err := c.toPostfix(tokens)
+	if err == nil {
+		_, err = c.buildAST(postfix)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// LoadMacros defines a macro from every line of r, skipping blank lines and
+// lines starting with #.
+func (c *Calculator) LoadMacros(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := c.Define(text); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// expandMacros replaces every macro invocation in tokens by the macro's
+// body, parenthesized, with each parameter replaced by its parenthesized
+// argument, and every eval(name) by the parenthesized expression bound to
+// name.
+func (c *Calculator) expandMacros(tokens []Token, depth int) ([]Token, error) {
+	if len(c.macros) == 0 && len(c.exprs) == 0 {
+		return tokens, nil
+	}
+
+	var out []Token
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if token.Type == IDENTIFIER && token.Value == "eval" && i+1 < len(tokens) && tokens[i+1].Type == LPAREN {
+			expanded, err := c.expandEval(tokens, i, depth)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, expanded...)
+			i += 3
+			continue
+		}
+		m, ok := c.macros[token.Value]
+		if token.Type != IDENTIFIER || !ok || i+1 >= len(tokens) || tokens[i+1].Type != LPAREN {
+			out = append(out, token)
+			continue
+		}
+		if depth >= maxMacroDepth {
+			return nil, fmt.Errorf("macro expansion of %s nested more than %d deep", token.Value, maxMacroDepth)
+		}
+
+		args, end := macroArgs(tokens, i+1)
+		if end < 0 {
+			// Unbalanced; leave it for toPostfix to report.
+			out = append(out, tokens[i:]...)
+			break
+		}
+		if len(args) != len(m.params) {
+			return nil, fmt.Errorf("%s expects %d arguments, got %d", token.Value, len(m.params), len(args))
+		}
+
+		expansion := []Token{{Type: LPAREN, Value: "(", Pos: token.Pos}}
+		for _, b := range m.body {
+			if b.Type == IDENTIFIER {
+				if p := indexOf(m.params, b.Value); p >= 0 {
+					expansion = append(expansion, Token{Type: LPAREN, Value: "(", Pos: token.Pos})
+					expansion = append(expansion, args[p]...)
+					expansion = append(expansion, Token{Type: RPAREN, Value: ")", Pos: token.Pos})
+					continue
+				}
+			}
+			b.Pos = token.Pos
+			expansion = append(expansion, b)
+		}
+		expansion = append(expansion, Token{Type: RPAREN, Value: ")", Pos: token.Pos})
+
+		expanded, err := c.expandMacros(expansion, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, expanded...)
+		i = end
+	}
+	return out, nil
+}
+
+// expandEval expands the eval(name) starting at tokens[i].
+func (c *Calculator) expandEval(tokens []Token, i, depth int) ([]Token, error) {
+	token := tokens[i]
+	if i+3 >= len(tokens) || tokens[i+2].Type != IDENTIFIER || tokens[i+3].Type != RPAREN {
+		return nil, errorAt(token.Pos, "eval expects the name of a stored expression at position %d", token.Pos)
+	}
+	name := tokens[i+2].Value
+	body, ok := c.exprs[name]
+	if !ok {
+		return nil, fmt.Errorf("eval: %s does not hold an expression", name)
+	}
+	if depth >= maxMacroDepth {
+		return nil, fmt.Errorf("eval(%s) nested more than %d deep", name, maxMacroDepth)
+	}
+
+	expansion := []Token{{Type: LPAREN, Value: "(", Pos: token.Pos}}
+	for _, b := range body {
+		b.Pos = token.Pos
+		expansion = append(expansion, b)
+	}
+	expansion = append(expansion, Token{Type: RPAREN, Value: ")", Pos: token.Pos})
+	return c.expandMacros(expansion, depth+1)
+}
+
+// macroArgs splits the arguments of the call whose LPAREN is tokens[open],
+// returning them and the index of the closing RPAREN, or -1 if there is
+// none.
+func macroArgs(tokens []Token, open int) ([][]Token, int) {
+	var args [][]Token
+	var current []Token
+	depth := 0
+	for i := open + 1; i < len(tokens); i++ {
+		switch tokens[i].Type {
+		case LPAREN:
+			depth++
+		case RPAREN:
+			if depth == 0 {
+				if len(current) > 0 || len(args) > 0 {
+					args = append(args, current)
+				}
+				return args, i
+			}
+			depth--
+		case COMMA:
+			if depth == 0 {
+				args = append(args, current)
+				current = nil
+				continue
+			}
+		}
+		current = append(current, tokens[i])
+	}
+	return nil, -1
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDefine(t *testing.T) {
+	c := NewCalculator()
+	for _, def := range []string{
+		"def monthly(rate, n) = rate / 12 * n",
+		"square(x) = x * x",
+		"def answer() = 42",
+	} {
+		if err := c.Define(def); err != nil {
+			t.Fatalf("Define(%q): %v", def, err)
+		}
+	}
+	checkEval(t, c, []evalCase{
+		{"monthly(0.06, 24)", "0.12"},
+		{"monthly(12, 1) + monthly(24, 2)", "5"},
+		// Arguments are substituted parenthesized.
+		{"square(1 + 2)", "9"},
+		{"square(square(2))", "16"},
+		{"answer()", "42"},
+	})
+
+	// Other identifiers in the body are looked up when it is invoked.
+	if err := c.Define("scaled(x) = x * k"); err != nil {
+		t.Fatal(err)
+	}
+	checkEval(t, c, []evalCase{{"k = 2", "2"}, {"scaled(5)", "10"}, {"k = 3", "3"}, {"scaled(5)", "15"}})
+
+	checkEvalError(t, c, "monthly(1)", "monthly expects 2 arguments, got 1")
+	checkEvalError(t, c, "square(1, 2)", "square expects 1 arguments, got 2")
+
+	if err := c.Define("loop(x) = loop(x)"); err != nil {
+		t.Fatal(err)
+	}
+	checkEvalError(t, c, "loop(1)", "macro expansion of loop nested more than 64 deep")
+}
+
+func TestDefineErrors(t *testing.T) {
+	c := NewCalculator()
+	if err := c.Define("sqrt(x) = x"); !errors.Is(err, ErrBuiltinName) {
+		t.Errorf("redefining sqrt: error = %v, want ErrBuiltinName", err)
+	}
+	for _, def := range []string{
+		"monthly",
+		"monthly = 1",
+		"f(x, x) = x",
+		"f(1) = 1",
+		"f(x y) = x",
+		"f(x) = x +",
+	} {
+		if err := c.Define(def); err == nil {
+			t.Errorf("Define(%q) succeeded", def)
+		}
+	}
+}
+
+func TestLoadMacros(t *testing.T) {
+	c := NewCalculator()
+	src := "# business helpers\n\ndef net(x) = x * 0.8\ngross(x) = x / 0.8\n"
+	if err := c.LoadMacros(strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+	checkEval(t, c, []evalCase{{"net(100)", "80"}, {"gross(net(50))", "50"}})
+
+	err := c.LoadMacros(strings.NewReader("ok(x) = x\nbad(x) = x *\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+		t.Errorf("LoadMacros error = %v, want one for line 2", err)
+	}
+}
+
+func TestBind(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{{"price = 2", "2"}, {"qty = 3", "3"}})
+	if err := c.Bind("total", "price * qty"); err != nil {
+		t.Fatal(err)
+	}
+	checkEval(t, c, []evalCase{
+		{"eval(total)", "6"},
+		// Changing a dependency changes the result.
+		{"qty = 10", "10"},
+		{"eval(total)", "20"},
+		{"eval(total) + 1", "21"},
+	})
+	// Bindings can refer to each other.
+	if err := c.Bind("taxed", "eval(total) * 1.5"); err != nil {
+		t.Fatal(err)
+	}
+	checkEval(t, c, []evalCase{{"eval(taxed)", "30"}, {"price = 4", "4"}, {"eval(taxed)", "60"}})
+
+	checkEvalError(t, c, "eval(price)", "eval: price does not hold an expression")
+	checkEvalError(t, c, "eval(1 + 2)", "eval expects the name of a stored expression")
+	// Assigning a value replaces the binding.
+	checkEval(t, c, []evalCase{{"total = 5", "5"}, {"total", "5"}})
+	checkEvalError(t, c, "eval(total)", "eval: total does not hold an expression")
+
+	if err := c.Bind("pi", "3"); err == nil {
+		t.Error("binding the constant pi succeeded")
+	}
+}
+
+func TestREPLBind(t *testing.T) {
+	t.Setenv("CALC_EXPR", "")
+	out, _, _ := run("price = 2\nqty = 3\ntotal := price * qty\neval(total)\nqty = 10\neval(total)\n", "--quiet")
+	if want := "2\n3\n6\n10\n20\n"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
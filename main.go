@@ -1,10 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"os"
 	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // Token types
@@ -13,77 +17,611 @@ const (
 	OPERATOR
 	LPAREN
 	RPAREN
+	IDENTIFIER
+	COMMA
+	FUNCTION
+	STRING
+	TARGET
+	// WHITESPACE is a run of spaces, kept only by Tokenize.
+	WHITESPACE
+	// LABEL names the operand before it, as "sum" in (a + b):"sum",
+	// for EvaluateLabeled.
+	LABEL
+	// COMMENT is a // or /* */ comment, kept like WHITESPACE only by
+	// Tokenize.
+	COMMENT
 )
 
 type Token struct {
 	Type  int
 	Value string
+	Pos   int
+	// Op is the id of an OPERATOR token in its Calculator's operator table.
+	Op int
+	// Args is the number of arguments of a FUNCTION token (and, while
+	// converting to postfix, the running argument count of its LPAREN).
+	Args int
+	// Chain marks a comparison continuing a chain such as 1 < x < 10. Its
+	// left operand is the previous comparison, and it compares that
+	// comparison's right operand, so the chain means 1 < x and x < 10.
+	Chain bool
+	// Body is the term of a series call in postfix form, which the call
+	// evaluates once for each value of its index.
+	Body []Token
+}
+
+var tokenTypeNames = map[int]string{
+	NUMBER:     "NUMBER",
+	OPERATOR:   "OPERATOR",
+	LPAREN:     "LPAREN",
+	RPAREN:     "RPAREN",
+	IDENTIFIER: "IDENTIFIER",
+	COMMA:      "COMMA",
+	FUNCTION:   "FUNCTION",
+	STRING:     "STRING",
+	TARGET:     "TARGET",
+	WHITESPACE: "WHITESPACE",
+	LABEL:      "LABEL",
+	COMMENT:    "COMMENT",
+}
+
+// String renders a token as its type name and quoted value, e.g.
+// NUMBER("3").
+func (t Token) String() string {
+	name, ok := tokenTypeNames[t.Type]
+	if !ok {
+		name = fmt.Sprintf("Token(%d)", t.Type)
+	}
+	return fmt.Sprintf("%s(%q)", name, t.Value)
+}
+
+// Tokens is a token stream that prints readably, e.g.
+// NUMBER("3") OPERATOR("+") NUMBER("4").
+type Tokens []Token
+
+func (ts Tokens) String() string {
+	parts := make([]string, len(ts))
+	for i, t := range ts {
+		parts[i] = t.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+var constants = map[string]float64{
+	"inf": math.Inf(1),
+	"e":   math.E,
+	"pi":  math.Pi,
+}
+
+func isIdentStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
 }
 
 // Tokenizer: converts input string to tokens
-func tokenize(input string) ([]Token, error) {
+func (c *Calculator) tokenize(input string) ([]Token, error) {
+	tokens, err := c.scan(input)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err = c.expandMacros(tokens, 0)
+	if err != nil {
+		return nil, err
+	}
+	if c.labels == nil {
+		tokens = withoutLabels(tokens)
+	}
+	// Macros can multiply the tokens scan counted.
+	if c.MaxTokens > 0 && len(tokens) > c.MaxTokens {
+		return nil, fmt.Errorf("%w: %d tokens, the limit is %d", ErrTooManyTokens, len(tokens), c.MaxTokens)
+	}
+	return tokens, nil
+}
+
+// withoutLabels drops the LABEL tokens of tokens, which only
+// EvaluateLabeled evaluates.
+func withoutLabels(tokens []Token) []Token {
+	out := tokens[:0]
+	for _, t := range tokens {
+		if t.Type != LABEL {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// endsOperand reports whether t can be the last token of an operand, so
+// that a label may follow it.
+func endsOperand(t Token) bool {
+	switch t.Type {
+	case NUMBER, IDENTIFIER, STRING, RPAREN, LABEL:
+		return true
+	}
+	return false
+}
+
+// scan splits input into tokens, with implied multiplications inserted but
+// macros not yet expanded.
+func (c *Calculator) scan(input string) ([]Token, error) {
+	tokens, err := c.lex(input, false)
+	if err != nil {
+		return nil, err
+	}
+	c.resolveAliases(tokens)
+	return c.implicitMultiply(tokens)
+}
+
+// incomplete reports whether input is the start of an expression that the
+// next line could finish: whether it has more opening brackets than
+// closing ones or ends in a comma or an operator. Every operator counts,
+// the prefix ones included, as none is written after its operand, so
+// "3 +" and "2 * -" continue, and so does an unterminated /* comment.
+// Input that does not lex otherwise is not incomplete, so that its error
+// is reported straight away.
+func (c *Calculator) incomplete(input string) bool {
+	tokens, err := c.lex(input, false)
+	if errors.Is(err, ErrUnterminatedComment) {
+		return true
+	}
+	if err != nil || len(tokens) == 0 {
+		return false
+	}
+	depth := 0
+	for _, t := range tokens {
+		switch t.Type {
+		case LPAREN:
+			depth++
+		case RPAREN:
+			depth--
+		}
+	}
+	last := tokens[len(tokens)-1].Type
+	return depth > 0 || last == OPERATOR || last == COMMA
+}
+
+// Tokenize returns the tokens of input as written, without implied
+// multiplications or expanded macros, for tools such as formatters. With
+// whitespace set, each run of whitespace is a WHITESPACE token and each
+// comment a COMMENT token, so that the text of every token runs from its
+// Pos to the next token's and the tokens cover input exactly.
+func (c *Calculator) Tokenize(input string, whitespace bool) (_ []Token, err error) {
+	defer recoverPanic(&err)
+	return c.lex(input, whitespace)
+}
+
+// lex is scan without implicit multiplication, optionally keeping
+// whitespace.
+func (c *Calculator) lex(input string, whitespace bool) ([]Token, error) {
+	if c.MaxLength > 0 {
+		if n := utf8.RuneCountInString(input); n > c.MaxLength {
+			return nil, fmt.Errorf("%w: %d characters, the limit is %d", ErrInputTooLong, n, c.MaxLength)
+		}
+	}
+
 	var tokens []Token
-	var numBuilder strings.Builder
+	runes := []rune(input)
+	// prev is the last token other than whitespace and comments, deciding
+	// whether an operator is binary.
+	prev := func() (Token, bool) {
+		for j := len(tokens) - 1; j >= 0; j-- {
+			if tokens[j].Type != WHITESPACE && tokens[j].Type != COMMENT {
+				return tokens[j], true
+			}
+		}
+		return Token{}, false
+	}
 
-	for _, r := range input {
+	for i := 0; i < len(runes); {
+		if c.MaxTokens > 0 && len(tokens) > c.MaxTokens {
+			return nil, fmt.Errorf("%w: more than %d", ErrTooManyTokens, c.MaxTokens)
+		}
+		r := runes[i]
+		if c.InputBase != 0 && c.InputBase != 10 {
+			token, next, ok, err := c.lexBaseLiteral(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				tokens = append(tokens, token)
+				i = next
+				continue
+			}
+		}
 		switch {
-		case unicode.IsDigit(r) || r == '.':
-			numBuilder.WriteRune(r)
-		case strings.ContainsRune("+-*/", r):
-			if numBuilder.Len() > 0 {
-				tokens = append(tokens, Token{NUMBER, numBuilder.String()})
-				numBuilder.Reset()
-			}
-			tokens = append(tokens, Token{OPERATOR, string(r)})
-		case r == '(':
-			if numBuilder.Len() > 0 {
-				tokens = append(tokens, Token{NUMBER, numBuilder.String()})
-				numBuilder.Reset()
+		case unicode.IsDigit(r) || r == '.' || (isCurrencySign(r) && i+1 < len(runes) && (unicode.IsDigit(runes[i+1]) || runes[i+1] == '.')):
+			start := i
+			if isCurrencySign(r) {
+				// A currency sign before a number only marks it as an
+				// amount; the token still starts there, so its text
+				// includes the sign.
+				i++
+			}
+			digitsStart := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+				// Give up as soon as the literal is too long rather than
+				// scanning the rest of it.
+				if c.MaxLiteralLength > 0 && i-start > c.MaxLiteralLength {
+					return nil, errorAt(start, "%w: number at position %d exceeds %d characters", ErrLiteralTooLong, start, c.MaxLiteralLength)
+				}
+			}
+			i += exponentLength(runes, i)
+			value, err := c.asciiDigits(runes[digitsStart:i], digitsStart)
+			if err != nil {
+				return nil, err
 			}
-			tokens = append(tokens, Token{LPAREN, string(r)})
+			if i < len(runes) && runes[i] == '%' {
+				value = percentLiteral(value)
+				i++
+			} else if unit := angleUnit(runes, i); unit != "" {
+				value = angleLiteral(value, unit)
+				i += len(unit)
+			}
+			tokens = append(tokens, Token{Type: NUMBER, Value: value, Pos: start})
+			continue
+		case isIdentStart(r):
+			start := i
+			for i < len(runes) && isIdentPart(runes[i]) {
+				i++
+			}
+			if namespaces[string(runes[start:i])] && i+1 < len(runes) && runes[i] == '.' && isIdentStart(runes[i+1]) {
+				for i++; i < len(runes) && isIdentPart(runes[i]); i++ {
+				}
+			}
+			word := string(runes[start:i])
+			// A keyword operator such as max is one only where a binary
+			// operator can stand, after an operand; elsewhere the word is
+			// an ordinary name.
+			if id, ok := c.binarySymbols[word]; ok {
+				if last, ok := prev(); ok {
+					switch last.Type {
+					case NUMBER, IDENTIFIER, STRING, RPAREN:
+						tokens = append(tokens, Token{Type: OPERATOR, Value: c.operators[id].symbol, Pos: start, Op: id})
+						continue
+					}
+				}
+			}
+			tokens = append(tokens, Token{Type: IDENTIFIER, Value: word, Pos: start})
+			continue
+		case r == '(':
+			tokens = append(tokens, Token{Type: LPAREN, Value: string(r), Pos: i})
 		case r == ')':
-			if numBuilder.Len() > 0 {
-				tokens = append(tokens, Token{NUMBER, numBuilder.String()})
-				numBuilder.Reset()
+			tokens = append(tokens, Token{Type: RPAREN, Value: string(r), Pos: i})
+		case r == '{':
+			// A set literal is a call of setFunction with braces for
+			// parentheses.
+			tokens = append(tokens, Token{Type: FUNCTION, Value: setFunction, Pos: i}, Token{Type: LPAREN, Value: string(r), Pos: i})
+		case r == '}':
+			tokens = append(tokens, Token{Type: RPAREN, Value: string(r), Pos: i})
+		case r == ',':
+			tokens = append(tokens, Token{Type: COMMA, Value: string(r), Pos: i})
+		case r == ':' && i+1 < len(runes) && runes[i+1] == '"':
+			start := i
+			if last, ok := prev(); !ok || !endsOperand(last) {
+				return nil, errorAt(start, "label at position %d does not follow an operand", start)
+			}
+			i += 2
+			for i < len(runes) && runes[i] != '"' {
+				i++
+			}
+			if i == len(runes) {
+				return nil, errorAt(start, "unterminated label starting at position %d", start)
+			}
+			if i == start+2 {
+				return nil, errorAt(start, "empty label at position %d", start)
+			}
+			tokens = append(tokens, Token{Type: LABEL, Value: string(runes[start+2 : i]), Pos: start})
+		case r == '"':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				i++
+			}
+			if i == len(runes) {
+				return nil, errorAt(start, "unterminated string starting at position %d", start)
+			}
+			tokens = append(tokens, Token{Type: STRING, Value: string(runes[start+1 : i]), Pos: start})
+		case r == '/' && i+1 < len(runes) && (runes[i+1] == '/' || runes[i+1] == '*'):
+			// A comment is ignored like whitespace: // runs to the end of
+			// the line and /* to the next */, so block comments do not
+			// nest.
+			start := i
+			if runes[i+1] == '/' {
+				for i < len(runes) && runes[i] != '\n' {
+					i++
+				}
+			} else {
+				end := strings.Index(string(runes[i+2:]), "*/")
+				if end < 0 {
+					return nil, errorAt(start, "%w starting at position %d", ErrUnterminatedComment, start)
+				}
+				i += 2 + utf8.RuneCountInString(string(runes[i+2:])[:end]) + 2
 			}
-			tokens = append(tokens, Token{RPAREN, string(r)})
+			if whitespace {
+				tokens = append(tokens, Token{Type: COMMENT, Value: string(runes[start:i]), Pos: start})
+			}
+			continue
 		case unicode.IsSpace(r):
-			if numBuilder.Len() > 0 {
-				tokens = append(tokens, Token{NUMBER, numBuilder.String()})
-				numBuilder.Reset()
+			if whitespace {
+				start := i
+				for i < len(runes) && unicode.IsSpace(runes[i]) {
+					i++
+				}
+				tokens = append(tokens, Token{Type: WHITESPACE, Value: string(runes[start:i]), Pos: start})
+				continue
 			}
 		default:
-			return nil, fmt.Errorf("invalid character: %c", r)
+			// An operator is prefix when nothing that could be its left
+			// operand precedes it.
+			last, ok := prev()
+			unary := !ok
+			if ok {
+				switch last.Type {
+				case OPERATOR, LPAREN, COMMA:
+					unary = true
+				}
+			}
+			id, n, ok := c.matchOperator(runes, i, unary)
+			if !ok {
+				if _, _, binary := c.matchOperator(runes, i, !unary); binary && unary {
+					return nil, errorAt(i, "missing left operand for operator %c", r)
+				}
+				return nil, errorAt(i, "invalid character: %c", r)
+			}
+			// An alias stands for the operator's own symbol.
+			tokens = append(tokens, Token{Type: OPERATOR, Value: c.operators[id].symbol, Pos: i, Op: id})
+			i += n
+			continue
 		}
+		i++
 	}
 
-	if numBuilder.Len() > 0 {
-		tokens = append(tokens, Token{NUMBER, numBuilder.String()})
+	if c.SignedLiterals {
+		tokens = c.signLiterals(tokens)
 	}
-
 	return tokens, nil
 }
 
+// signLiterals merges each prefix - or + with a number right after it
+// into a signed NUMBER token at the sign's position.
+func (c *Calculator) signLiterals(tokens []Token) []Token {
+	out := tokens[:0]
+	for _, t := range tokens {
+		if n := len(out); n > 0 && t.Type == NUMBER {
+			sign := out[n-1]
+			if sign.Type == OPERATOR && c.operators[sign.Op].unary && (sign.Value == "-" || sign.Value == "+") && sign.Pos+1 == t.Pos {
+				if sign.Value == "-" {
+					t.Value = "-" + t.Value
+				}
+				t.Pos = sign.Pos
+				out[n-1] = t
+				continue
+			}
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// angleUnit returns the angle unit, deg or rad, that a number literal
+// ending at runes[i] has as a suffix, or "" if it has none. The unit must
+// end the word and not be called, so 2degrees and 2rad(x) are a number
+// before a name as usual.
+func angleUnit(runes []rune, i int) string {
+	for _, unit := range []string{"deg", "rad"} {
+		end := i + len(unit)
+		if end > len(runes) || string(runes[i:end]) != unit {
+			continue
+		}
+		if end < len(runes) && (isIdentPart(runes[end]) || runes[end] == '(') {
+			continue
+		}
+		return unit
+	}
+	return ""
+}
+
+// angleLiteral returns the number literal lit followed by an angle unit
+// as a literal in radians, the unit trigonometric functions take: 90deg
+// becomes 1.5707963267948966 and 1.5rad stays 1.5.
+func angleLiteral(lit, unit string) string {
+	x, err := strconv.ParseFloat(lit, 64)
+	if err != nil || unit == "rad" {
+		// A malformed literal is left for the evaluator to report.
+		return lit
+	}
+	return strconv.FormatFloat(x*math.Pi/180, 'g', -1, 64)
+}
+
+// percentLiteral returns the number literal lit followed by a percent
+// sign as a literal a hundred times smaller, moving the decimal point
+// rather than dividing so that the value stays exact: 15 becomes 0.15 and
+// 1.5e3 becomes 1.5e1.
+func percentLiteral(lit string) string {
+	if i := strings.IndexAny(lit, "eE"); i >= 0 {
+		exp, err := strconv.Atoi(lit[i+1:])
+		if err != nil {
+			return lit
+		}
+		return lit[:i] + "e" + strconv.Itoa(exp-2)
+	}
+	if strings.Count(lit, ".") > 1 {
+		// Malformed; the evaluator reports it.
+		return lit
+	}
+	whole, frac := lit, ""
+	if i := strings.IndexByte(lit, '.'); i >= 0 {
+		whole, frac = lit[:i], lit[i+1:]
+	}
+	digits, point := whole+frac, len(whole)-2
+	for ; point < 1; point++ {
+		digits = "0" + digits
+	}
+	whole = strings.TrimLeft(digits[:point], "0")
+	frac = strings.TrimRight(digits[point:], "0")
+	if whole == "" {
+		whole = "0"
+	}
+	if frac == "" {
+		return whole
+	}
+	return whole + "." + frac
+}
+
+// exponentLength returns the length of the exponent suffix starting at
+// runes[i] after the digits of a number, or 0 if there is none. An e or E
+// begins an exponent only when a digit follows it, optionally after a
+// sign: 2e3 and 2e-3 are single numbers, while in 2e, 2e+x and 2ex the
+// number ends at the 2 and the letters start an identifier, which is then
+// multiplied implicitly.
+func exponentLength(runes []rune, i int) int {
+	if i >= len(runes) || (runes[i] != 'e' && runes[i] != 'E') {
+		return 0
+	}
+	j := i + 1
+	if j < len(runes) && (runes[j] == '+' || runes[j] == '-') {
+		j++
+	}
+	if j >= len(runes) || !unicode.IsDigit(runes[j]) {
+		return 0
+	}
+	for j < len(runes) && unicode.IsDigit(runes[j]) {
+		j++
+	}
+	return j - i
+}
+
+// asciiDigits returns the number literal starting at position pos with
+// any non-ASCII decimal digits, such as the Arabic-Indic ٤٢, replaced by
+// their ASCII equivalents. They are accepted only with c.UnicodeDigits set,
+// and all the digits of one literal must come from the same script.
+func (c *Calculator) asciiDigits(literal []rune, pos int) (string, error) {
+	out := make([]rune, len(literal))
+	var zero rune
+	for i, r := range literal {
+		out[i] = r
+		if !unicode.IsDigit(r) {
+			continue
+		}
+		z := '0'
+		if r >= utf8.RuneSelf {
+			if !c.UnicodeDigits {
+				return "", errorAt(pos+i, "non-ASCII digit %c at position %d", r, pos+i)
+			}
+			z = digitZero(r)
+		}
+		if zero != 0 && z != zero {
+			return "", errorAt(pos, "number at position %d mixes digits from different scripts", pos)
+		}
+		zero = z
+		out[i] = '0' + (r - z)
+	}
+	return string(out), nil
+}
+
+// digitZero returns the zero of the run of ten digits that r belongs to.
+// Unicode encodes each script's digits 0 to 9 consecutively, and where
+// several sets are adjacent, as for the mathematical digits, each starts
+// at a multiple of ten from the start of the run.
+func digitZero(r rune) rune {
+	start := r
+	for unicode.IsDigit(start - 1) {
+		start--
+	}
+	return r - (r-start)%10
+}
+
+// implicitMultiply inserts the * that juxtaposition implies: a number
+// before a parenthesis, 2(3), a parenthesis after a parenthesis, (1)(2),
+// and a number before an identifier, 2x or 2 e. Each is an error instead
+// when c.Implicit leaves it out.
+func (c *Calculator) implicitMultiply(tokens []Token) ([]Token, error) {
+	var out []Token
+	for i, token := range tokens {
+		if i > 0 {
+			// A label is part of the operand before it.
+			j := i - 1
+			for j > 0 && tokens[j].Type == LABEL {
+				j--
+			}
+			prev := tokens[j].Type
+			var kind ImplicitMultiply
+			switch {
+			case prev == NUMBER && token.Type == LPAREN:
+				kind = ImplicitNumberParen
+			case prev == RPAREN && token.Type == LPAREN:
+				kind = ImplicitParenParen
+			case prev == NUMBER && token.Type == IDENTIFIER:
+				kind = ImplicitNumberIdent
+			}
+			if kind != 0 && c.Implicit&kind == 0 {
+				return nil, errorAt(token.Pos, "implicit multiplication before %s at position %d is disabled", token.Value, token.Pos)
+			}
+			if kind != 0 {
+				out = append(out, Token{Type: OPERATOR, Value: "*", Pos: token.Pos, Op: c.operatorIDs["*"]})
+			}
+		}
+		out = append(out, token)
+	}
+	return out, nil
+}
+
 // Shunting Yard Algorithm to convert infix to postfix
-func toPostfix(tokens []Token) ([]Token, error) {
+func (c *Calculator) toPostfix(tokens []Token) ([]Token, error) {
+	tokens, err := c.collectSeries(tokens)
+	if err != nil {
+		return nil, err
+	}
+
 	var output []Token
 	var stack []Token
 
-	precedence := map[string]int{
-		"+": 1,
-		"-": 1,
-		"*": 2,
-		"/": 2,
-	}
-
-	for _, token := range tokens {
+	for i, token := range tokens {
+		// The stack holds the brackets, calls and operators still open
+		// around token, so its length is how deeply token is nested.
+		if err := c.checkStep(len(stack), token); err != nil {
+			return nil, err
+		}
 		switch token.Type {
-		case NUMBER:
+		case NUMBER, STRING, TARGET:
+			output = append(output, token)
+		case LABEL:
+			// A label binds tighter than any operator, naming the
+			// operand just output.
 			output = append(output, token)
+		case IDENTIFIER:
+			if i+1 < len(tokens) && tokens[i+1].Type == LPAREN {
+				token.Type = FUNCTION
+				stack = append(stack, token)
+			} else {
+				// A variable about to be assigned names a target rather
+				// than standing for its value.
+				if i+1 < len(tokens) && tokens[i+1].Type == OPERATOR && c.operators[tokens[i+1].Op].assign {
+					token.Type = TARGET
+				}
+				output = append(output, token)
+			}
+		case FUNCTION:
+			stack = append(stack, token)
 		case OPERATOR:
-			for len(stack) > 0 {
+			op := c.operators[token.Op]
+			// A prefix operator has no left operand, so nothing on the
+			// stack can be applied before it.
+			for len(stack) > 0 && !op.unary {
 				top := stack[len(stack)-1]
-				if top.Type == OPERATOR && precedence[top.Value] >= precedence[token.Value] {
+				if top.Type != OPERATOR {
+					break
+				}
+				topPrec := c.operators[top.Op].precedence
+				if topPrec > op.precedence || (topPrec == op.precedence && !op.rightAssoc) {
+					// A comparison directly after another of its level,
+					// outside parentheses, continues its chain.
+					if topPrec == op.precedence && chainable(op) && chainable(c.operators[top.Op]) {
+						token.Chain = true
+					}
 					output = append(output, top)
 					stack = stack[:len(stack)-1]
 				} else {
@@ -92,22 +630,60 @@ func toPostfix(tokens []Token) ([]Token, error) {
 			}
 			stack = append(stack, token)
 		case LPAREN:
+			// An empty call like f() has no arguments, anything else
+			// starts with one and gains another on every comma.
+			if len(stack) > 0 && stack[len(stack)-1].Type == FUNCTION &&
+				!(i+1 < len(tokens) && tokens[i+1].Type == RPAREN) {
+				token.Args = 1
+			}
 			stack = append(stack, token)
+		case COMMA:
+			for len(stack) > 0 && stack[len(stack)-1].Type != LPAREN {
+				output = append(output, stack[len(stack)-1])
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) < 2 || stack[len(stack)-2].Type != FUNCTION {
+				// Anywhere else a comma is the sequence operator, the
+				// loosest of all, so everything before it is output.
+				id, ok := c.binarySymbols[","]
+				if !ok {
+					return nil, errorAt(token.Pos, "unexpected comma outside function call")
+				}
+				// 1,000 is far more likely a thousands separator than a
+				// sequence whose 1 is thrown away, so it is refused.
+				if i > 0 && i+1 < len(tokens) && tokens[i-1].Type == NUMBER && tokens[i+1].Type == NUMBER &&
+					tokens[i+1].Pos == token.Pos+1 && unicode.IsDigit(rune(tokens[i+1].Value[0])) {
+					return nil, errorAt(token.Pos, "comma between numbers at position %d: numbers have no thousands separator, use parentheses or spaces for the sequence operator", token.Pos)
+				}
+				stack = append(stack, Token{Type: OPERATOR, Value: ",", Pos: token.Pos, Op: id})
+				break
+			}
+			stack[len(stack)-1].Args++
 		case RPAREN:
 			for len(stack) > 0 && stack[len(stack)-1].Type != LPAREN {
 				output = append(output, stack[len(stack)-1])
 				stack = stack[:len(stack)-1]
 			}
 			if len(stack) == 0 || stack[len(stack)-1].Type != LPAREN {
-				return nil, fmt.Errorf("mismatched parentheses")
+				return nil, errorAt(token.Pos, "mismatched parentheses")
 			}
+			if (stack[len(stack)-1].Value == "{") != (token.Value == "}") {
+				return nil, errorAt(token.Pos, "mismatched parentheses")
+			}
+			args := stack[len(stack)-1].Args
 			stack = stack[:len(stack)-1]
+			if len(stack) > 0 && stack[len(stack)-1].Type == FUNCTION {
+				fn := stack[len(stack)-1]
+				fn.Args = args
+				output = append(output, fn)
+				stack = stack[:len(stack)-1]
+			}
 		}
 	}
 
 	for len(stack) > 0 {
-		if stack[len(stack)-1].Type == LPAREN {
-			return nil, fmt.Errorf("mismatched parentheses")
+		if stack[len(stack)-1].Type == LPAREN || stack[len(stack)-1].Type == FUNCTION {
+			return nil, errorAt(stack[len(stack)-1].Pos, "mismatched parentheses")
 		}
 		output = append(output, stack[len(stack)-1])
 		stack = stack[:len(stack)-1]
@@ -116,72 +692,294 @@ func toPostfix(tokens []Token) ([]Token, error) {
 	return output, nil
 }
 
+// checkStep is called before each token is parsed or evaluated. It
+// returns an ErrMaxDepthExceeded error about token if depth, the length
+// of the parsing or evaluation stack, is over MaxDepth, and an ErrCanceled
+// one once the context of EvaluateContext is done.
+func (c *Calculator) checkStep(depth int, token Token) error {
+	if c.MaxDepth > 0 && depth > c.MaxDepth {
+		return errorAt(token.Pos, "%w: more than %d levels at position %d", ErrMaxDepthExceeded, c.MaxDepth, token.Pos)
+	}
+	if c.ctx != nil {
+		select {
+		case <-c.ctx.Done():
+			return fmt.Errorf("%w: %w", ErrCanceled, c.ctx.Err())
+		default:
+		}
+	}
+	return nil
+}
+
+// parseNumber reads a NUMBER token.
+func (c *Calculator) parseNumber(token Token) (float64, error) {
+	num, err := strconv.ParseFloat(token.Value, 64)
+	if errors.Is(err, strconv.ErrRange) && math.IsInf(num, 0) {
+		// A literal too large for a float64 overflows like any other
+		// result.
+		num, err = c.checkOverflow(num)
+	}
+	if err != nil {
+		if errors.Is(err, ErrOverflow) {
+			return 0, errorAt(token.Pos, "number at position %d: %w", token.Pos, err)
+		}
+		return 0, errorAt(token.Pos, "invalid number %q at position %d", token.Value, token.Pos)
+	}
+	return num, nil
+}
+
+// evaluateFlat evaluates a flat chain of operands joined by operators of
+// one precedence level, such as a + b - c or 2 * x / 4, in a single pass
+// without converting it to postfix; that is most of what is typed in
+// practice. It reports false for any other expression, which then takes
+// the general path, and otherwise gives the same result and errors.
+func (c *Calculator) evaluateFlat(tokens []Token) (Value, bool, error) {
+	if c.Complex || c.Rational || c.Numbers != nil || len(tokens)%2 == 0 {
+		return Value{}, false, nil
+	}
+	prec := 0
+	for i := 1; i < len(tokens); i += 2 {
+		if tokens[i].Type != OPERATOR {
+			return Value{}, false, nil
+		}
+		op := c.operators[tokens[i].Op]
+		if op.unary || (op.precedence != precAdd && op.precedence != precMul) || (prec != 0 && op.precedence != prec) {
+			return Value{}, false, nil
+		}
+		prec = op.precedence
+	}
+	for i := 0; i < len(tokens); i += 2 {
+		if tokens[i].Type != NUMBER && tokens[i].Type != IDENTIFIER {
+			return Value{}, false, nil
+		}
+	}
+
+	operand := func(token Token) (Value, error) {
+		if token.Type == IDENTIFIER {
+			return c.lookup(token.Value)
+		}
+		num, err := c.parseNumber(token)
+		return Value{Num: num}, err
+	}
+
+	acc, err := operand(tokens[0])
+	if err != nil {
+		return Value{}, true, err
+	}
+	for i := 1; i < len(tokens); i += 2 {
+		token := tokens[i]
+		next, err := operand(tokens[i+1])
+		if err != nil {
+			return Value{}, true, err
+		}
+		a, err := acc.number("operator " + token.Value)
+		if err != nil {
+			return Value{}, true, err
+		}
+		b, err := next.number("operator " + token.Value)
+		if err != nil {
+			return Value{}, true, err
+		}
+		result, err := c.operators[token.Op].apply(a, b)
+		if err != nil {
+			return Value{}, true, errorAt(token.Pos, "%w at position %d", err, token.Pos)
+		}
+		result, err = c.checkOverflow(result, a, b)
+		if err != nil {
+			return Value{}, true, fmt.Errorf("%s: %w", token.Value, err)
+		}
+		acc = Value{Num: result}
+	}
+	return acc, true, nil
+}
+
 // Evaluator for postfix expression
-func evaluatePostfix(tokens []Token) (float64, error) {
-	var stack []float64
+func (c *Calculator) evaluatePostfix(tokens []Token) (Value, error) {
+	return c.evaluate(tokens, nil)
+}
+
+// evaluate is evaluatePostfix, also recording into stats when it is not
+// nil.
+func (c *Calculator) evaluate(tokens []Token, stats *Stats) (Value, error) {
+	if c.Complex {
+		return c.evaluateComplex(tokens)
+	}
+	if c.Rational {
+		return c.evaluateRational(tokens)
+	}
+	if c.Numbers != nil {
+		return c.evaluateNumbers(tokens)
+	}
+
+	var stack []Value
+	step := 0
 
 	for _, token := range tokens {
+		if err := c.checkStep(len(stack), token); err != nil {
+			return Value{}, err
+		}
+		if stats != nil && (token.Type == OPERATOR || token.Type == FUNCTION) {
+			stats.Operations++
+		}
+		var operands []Value
+		if c.Trace != nil {
+			operands = traceOperands(c, token, stack)
+		}
 		switch token.Type {
 		case NUMBER:
-			num, err := strconv.ParseFloat(token.Value, 64)
+			num, err := c.parseNumber(token)
+			if err != nil {
+				return Value{}, err
+			}
+			stack = append(stack, Value{Num: num})
+		case STRING:
+			stack = append(stack, Value{Kind: StringValue, Str: token.Value})
+		case IDENTIFIER:
+			v, err := c.lookup(token.Value)
 			if err != nil {
-				return 0, err
+				return Value{}, err
+			}
+			stack = append(stack, v)
+		case TARGET:
+			stack = append(stack, Value{Kind: targetValue, Str: token.Value})
+		case LABEL:
+			if len(stack) < 1 {
+				return Value{}, errorAt(token.Pos, "label at position %d does not follow an operand", token.Pos)
+			}
+			c.labels[token.Value] = stack[len(stack)-1]
+		case FUNCTION:
+			if token.Body != nil {
+				if len(stack) < token.Args {
+					return Value{}, fmt.Errorf("not enough arguments for function %s", token.Value)
+				}
+				result, err := c.series(token, stack[len(stack)-token.Args:], stats)
+				if err != nil {
+					return Value{}, fmt.Errorf("%s: %w", token.Value, err)
+				}
+				stack = append(stack[:len(stack)-token.Args], result)
+				break
+			}
+			f, ok := c.functions[token.Value]
+			if !ok {
+				return Value{}, fmt.Errorf("unknown function '%s'", token.Value)
+			}
+			if f.arity >= 0 && token.Args != f.arity {
+				return Value{}, fmt.Errorf("%s expects %d arguments, got %d", token.Value, f.arity, token.Args)
+			}
+			if len(stack) < token.Args {
+				return Value{}, fmt.Errorf("not enough arguments for function %s", token.Value)
 			}
-			stack = append(stack, num)
+			args := append([]Value(nil), stack[len(stack)-token.Args:]...)
+			stack = stack[:len(stack)-token.Args]
+
+			result, err := c.call(token.Value, f, args)
+			if err != nil {
+				return Value{}, fmt.Errorf("%s: %w", token.Value, err)
+			}
+			stack = append(stack, result)
 		case OPERATOR:
+			op := c.operators[token.Op]
+			if op.unary {
+				if len(stack) < 1 {
+					return Value{}, fmt.Errorf("not enough operands for operator %s", token.Value)
+				}
+				x, err := stack[len(stack)-1].number("operator " + token.Value)
+				if err != nil {
+					return Value{}, err
+				}
+				result, err := op.applyUnary(x)
+				if err != nil {
+					return Value{}, errorAt(token.Pos, "%w at position %d", err, token.Pos)
+				}
+				// √-1 is NaN as sqrt(-1) is, under the same policy.
+				if math.IsNaN(result) && c.NaN == NaNError {
+					if err := nanError([]float64{x}); err != nil {
+						return Value{}, fmt.Errorf("%s: %w", token.Value, err)
+					}
+				}
+				result, err = c.checkOverflow(result, x)
+				if err != nil {
+					return Value{}, fmt.Errorf("%s: %w", token.Value, err)
+				}
+				stack[len(stack)-1] = Value{Num: result}
+				break
+			}
 			if len(stack) < 2 {
-				return 0, fmt.Errorf("not enough operands for operator %s", token.Value)
+				return Value{}, fmt.Errorf("not enough operands for operator %s", token.Value)
+			}
+			if op.member {
+				v, err := c.member(stack[len(stack)-2], stack[len(stack)-1])
+				if err != nil {
+					return Value{}, errorAt(token.Pos, "%w at position %d", err, token.Pos)
+				}
+				stack = append(stack[:len(stack)-2], v)
+				break
 			}
-			b, a := stack[len(stack)-1], stack[len(stack)-2]
+			if op.sequence {
+				stack = append(stack[:len(stack)-2], stack[len(stack)-1])
+				break
+			}
+			if op.assign {
+				target, v := stack[len(stack)-2], stack[len(stack)-1]
+				if err := c.assign(target, v); err != nil {
+					return Value{}, errorAt(token.Pos, "%w at position %d", err, token.Pos)
+				}
+				// The assigned value is the result, so a = b = 5 sets b
+				// and then a.
+				stack = append(stack[:len(stack)-2], v)
+				break
+			}
+			a, err := stack[len(stack)-2].number("operator " + token.Value)
+			if err != nil {
+				return Value{}, err
+			}
+			b, err := stack[len(stack)-1].number("operator " + token.Value)
+			if err != nil {
+				return Value{}, err
+			}
+			prev := stack[len(stack)-2]
 			stack = stack[:len(stack)-2]
+			if token.Chain {
+				a = prev.middle
+			}
 
-			switch token.Value {
-			case "+":
-				stack = append(stack, a+b)
-			case "-":
-				stack = append(stack, a-b)
-			case "*":
-				stack = append(stack, a*b)
-			case "/":
-				if b == 0 {
-					return 0, fmt.Errorf("division by zero")
-				}
-				stack = append(stack, a/b)
+			result, err := op.apply(a, b)
+			if err != nil {
+				return Value{}, errorAt(token.Pos, "%w at position %d", err, token.Pos)
+			}
+			if token.Chain && prev.Num == 0 {
+				result = 0
 			}
+			result, err = c.checkOverflow(result, a, b)
+			if err != nil {
+				return Value{}, fmt.Errorf("%s: %w", token.Value, err)
+			}
+			v := Value{Num: result}
+			if op.boolean {
+				v.Format = FormatBool
+				v.middle = b
+			}
+			stack = append(stack, v)
+		}
+		if stats != nil && len(stack) > stats.MaxStackDepth {
+			stats.MaxStackDepth = len(stack)
+		}
+		if operands != nil {
+			step++
+			c.traceStep(step, token, operands, stack)
 		}
 	}
 
 	if len(stack) != 1 {
-		return 0, fmt.Errorf("invalid expression")
+		return Value{}, fmt.Errorf("invalid expression")
 	}
 
 	return stack[0], nil
 }
 
 func calculate(input string) (float64, error) {
-	tokens, err := tokenize(input)
-	if err != nil {
-		return 0, err
-	}
-
-	postfix, err := toPostfix(tokens)
-	if err != nil {
-		return 0, err
-	}
-
-	return evaluatePostfix(postfix)
+	return NewCalculator().Calculate(input)
 }
 
 func main() {
-	fmt.Println("Enter a math expression:")
-	var input string
-	fmt.Scanln(&input)
-
-	result, err := calculate(input)
-	if err != nil {
-		fmt.Println("Error:", err)
-	} else {
-		fmt.Printf("Result = %v\n", result)
-	}
+	os.Exit(Run(os.Stdin, os.Stdout, os.Stderr, os.Args))
 }
-
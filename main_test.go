@@ -0,0 +1,660 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// evalCase is an expression and the printed form of its value.
+type evalCase struct {
+	input string
+	want  string
+}
+
+// checkEval evaluates each case on c, failing the test for an error or a
+// result other than want.
+func checkEval(t *testing.T, c *Calculator, cases []evalCase) {
+	t.Helper()
+	for _, tc := range cases {
+		v, err := c.Evaluate(tc.input)
+		if err != nil {
+			t.Errorf("Evaluate(%q): %v", tc.input, err)
+			continue
+		}
+		if got := v.String(); got != tc.want {
+			t.Errorf("Evaluate(%q) = %s, want %s", tc.input, got, tc.want)
+		}
+	}
+}
+
+// checkEvalError evaluates input on c, failing the test unless it fails
+// with an error containing want.
+func checkEvalError(t *testing.T, c *Calculator, input, want string) {
+	t.Helper()
+	v, err := c.Evaluate(input)
+	if err == nil {
+		t.Errorf("Evaluate(%q) = %v, want an error containing %q", input, v, want)
+		return
+	}
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("Evaluate(%q) error = %q, want it to contain %q", input, err, want)
+	}
+}
+
+func TestOverflowModes(t *testing.T) {
+	// Near the float64 boundary: 1.5e308 still fits, 1e309 does not.
+	// Infinite operands are not an overflow in any mode.
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"1e308 * 1.5", "1.5e+308"},
+		{"1e308 * 10", "+Inf"},
+		{"-1e308 * 10", "-Inf"},
+		{"2^1024", "+Inf"},
+		{"inf + 1", "+Inf"},
+	})
+
+	c.Overflow = OverflowError
+	checkEval(t, c, []evalCase{
+		{"1e308 * 1.5", "1.5e+308"},
+		{"inf + 1", "+Inf"},
+	})
+	for _, input := range []string{"1e308 * 10", "-1e308 * 10", "2^1024", "1e308 + 1e308"} {
+		if _, err := c.Evaluate(input); !errors.Is(err, ErrOverflow) {
+			t.Errorf("Evaluate(%q) error = %v, want ErrOverflow", input, err)
+		}
+	}
+
+	c.Overflow = OverflowSaturate
+	checkEval(t, c, []evalCase{
+		{"1e308 * 1.5", "1.5e+308"},
+		{"1e308 * 10", "1.7976931348623157e+308"},
+		{"-1e308 * 10", "-1.7976931348623157e+308"},
+		{"2^1024", "1.7976931348623157e+308"},
+		{"inf + 1", "+Inf"},
+	})
+}
+
+func TestParseOverflowMode(t *testing.T) {
+	for name, want := range map[string]OverflowMode{"ieee": OverflowIEEE, "error": OverflowError, "saturate": OverflowSaturate} {
+		if got, err := ParseOverflowMode(name); err != nil || got != want {
+			t.Errorf("ParseOverflowMode(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseOverflowMode("clamp"); err == nil {
+		t.Error("ParseOverflowMode(\"clamp\") succeeded")
+	}
+}
+
+func TestTokensString(t *testing.T) {
+	c := NewCalculator()
+	tokens, err := c.tokenize("max(3, x) + 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `IDENTIFIER("max") LPAREN("(") NUMBER("3") COMMA(",") IDENTIFIER("x") RPAREN(")") OPERATOR("+") NUMBER("4")`
+	if got := Tokens(tokens).String(); got != want {
+		t.Errorf("Tokens.String() =\n%s\nwant\n%s", got, want)
+	}
+	if got := (Token{Type: 99, Value: "?"}).String(); got != `Token(99)("?")` {
+		t.Errorf("unknown token type prints as %s", got)
+	}
+}
+
+func TestIdentifierToken(t *testing.T) {
+	c := NewCalculator()
+	for input, want := range map[string]string{
+		"foo":       `IDENTIFIER("foo")`,
+		"foo_bar2":  `IDENTIFIER("foo_bar2")`,
+		"_x + 1":    `IDENTIFIER("_x") OPERATOR("+") NUMBER("1")`,
+		"abc*def":   `IDENTIFIER("abc") OPERATOR("*") IDENTIFIER("def")`,
+		"sqrt(foo)": `IDENTIFIER("sqrt") LPAREN("(") IDENTIFIER("foo") RPAREN(")")`,
+	} {
+		tokens, err := c.tokenize(input)
+		if err != nil {
+			t.Errorf("tokenize(%q) error: %v", input, err)
+			continue
+		}
+		if got := Tokens(tokens).String(); got != want {
+			t.Errorf("tokenize(%q) = %s, want %s", input, got, want)
+		}
+	}
+	checkEvalError(t, c, "foo", "unknown identifier 'foo'")
+	checkEvalError(t, c, "1 + foo * 2", "unknown identifier 'foo'")
+}
+
+func TestTokenizeWhitespace(t *testing.T) {
+	c := NewCalculator()
+	const input = "1 +  2*x\t- -3"
+	tokens, err := c.Tokenize(input, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Token{
+		{Type: NUMBER, Value: "1", Pos: 0},
+		{Type: WHITESPACE, Value: " ", Pos: 1},
+		{Type: OPERATOR, Value: "+", Pos: 2},
+		{Type: WHITESPACE, Value: "  ", Pos: 3},
+		{Type: NUMBER, Value: "2", Pos: 5},
+		{Type: OPERATOR, Value: "*", Pos: 6},
+		{Type: IDENTIFIER, Value: "x", Pos: 7},
+		{Type: WHITESPACE, Value: "\t", Pos: 8},
+		{Type: OPERATOR, Value: "-", Pos: 9},
+		{Type: WHITESPACE, Value: " ", Pos: 10},
+		// Unary, since the token before the whitespace is an operator.
+		{Type: OPERATOR, Value: "-", Pos: 11},
+		{Type: NUMBER, Value: "3", Pos: 12},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("Tokenize = %v, want %v", Tokens(tokens), Tokens(want))
+	}
+	var text strings.Builder
+	for i, token := range tokens {
+		text.WriteString(token.Value)
+		if token.Type != want[i].Type || token.Value != want[i].Value || token.Pos != want[i].Pos {
+			t.Errorf("token %d = %v at %d, want %v at %d", i, token, token.Pos, want[i], want[i].Pos)
+		}
+	}
+	if tokens[10].Op == tokens[8].Op {
+		t.Errorf("the second - has the binary operator")
+	}
+	if text.String() != input {
+		t.Errorf("tokens reproduce %q, want %q", text.String(), input)
+	}
+
+	// Without whitespace the tokens are as written, with no implied
+	// multiplication.
+	tokens, err = c.Tokenize("2x + 1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Tokens(tokens).String(), `NUMBER("2") IDENTIFIER("x") OPERATOR("+") NUMBER("1")`; got != want {
+		t.Errorf("Tokenize = %s, want %s", got, want)
+	}
+}
+
+func TestSignedLiterals(t *testing.T) {
+	c := NewCalculator()
+	c.SignedLiterals = true
+	for input, want := range map[string]string{
+		"-5":     `NUMBER("-5")`,
+		"+5":     `NUMBER("5")`,
+		"3 - 5":  `NUMBER("3") OPERATOR("-") NUMBER("5")`,
+		"3 -5":   `NUMBER("3") OPERATOR("-") NUMBER("5")`,
+		"3 * -5": `NUMBER("3") OPERATOR("*") NUMBER("-5")`,
+		"(-2)":   `LPAREN("(") NUMBER("-2") RPAREN(")")`,
+		"- 5":    `OPERATOR("-") NUMBER("5")`,
+		"-x":     `OPERATOR("-") IDENTIFIER("x")`,
+	} {
+		tokens, err := c.tokenize(input)
+		if err != nil {
+			t.Errorf("tokenize(%q) error: %v", input, err)
+			continue
+		}
+		if got := Tokens(tokens).String(); got != want {
+			t.Errorf("tokenize(%q) = %s, want %s", input, got, want)
+		}
+	}
+	checkEval(t, c, []evalCase{
+		{"-5", "-5"},
+		{"3 - 5", "-2"},
+		{"3 * -5", "-15"},
+		{"-2^2", "4"},
+		{"- 2^2", "-4"},
+		{"-1 max -2", "-1"},
+	})
+
+	c.SignedLiterals = false
+	checkEval(t, c, []evalCase{{"-2^2", "-4"}, {"3 * -5", "-15"}})
+}
+
+func TestComments(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"2 * /* rate */ 3 // per year", "6"},
+		{"8 / 2 // a comment after division", "4"},
+		{"8 /* / */ / 2", "4"},
+		{"1 + /* spans\nlines */ 2", "3"},
+		// Block comments do not nest: the first */ ends the comment.
+		{"1 + /* a /* b */ 2", "3"},
+		{"3 // /* not a block", "3"},
+	})
+	checkEvalError(t, c, "2/**/3", "invalid expression")
+	checkEvalError(t, c, "1 /* x */ + */ 2", "missing left operand for operator *")
+	_, err := c.Evaluate("1 + /* open")
+	if !errors.Is(err, ErrUnterminatedComment) {
+		t.Errorf("unterminated comment error = %v, want ErrUnterminatedComment", err)
+	}
+	checkEvalError(t, c, "1 + /* open", "unterminated comment starting at position 4")
+
+	tokens, err := c.Tokenize("1 /* a */ + 2 // b", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	comments := 0
+	for _, token := range tokens {
+		text.WriteString(token.Value)
+		if token.Type == COMMENT {
+			comments++
+		}
+	}
+	if comments != 2 || text.String() != "1 /* a */ + 2 // b" {
+		t.Errorf("Tokenize = %v, want two comments reproducing the input", Tokens(tokens))
+	}
+	if err := c.AliasOperator("//", "/"); err == nil {
+		t.Errorf("AliasOperator(//) succeeded")
+	}
+}
+
+func TestAngleLiterals(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"90deg", "1.5707963267948966"},
+		{"sin(90deg)", "1"},
+		{"1.5rad", "1.5"},
+		{"2 * 45deg", "1.5707963267948966"},
+		{"180deg - pi", "0"},
+		{"cos(60deg) + sin(30deg)", "1"},
+		{"90deg + 1.5rad", "3.0707963267948966"},
+	})
+	// Only a literal takes a unit, and only one ending the word.
+	checkEvalError(t, c, "(45)deg", "unknown identifier 'deg'")
+	checkEvalError(t, c, "3degx", "unknown identifier 'degx'")
+}
+
+func TestRadicals(t *testing.T) {
+	c := NewCalculator()
+	tokens, err := c.tokenize("√9 + ∛27")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Tokens(tokens).String(), `OPERATOR("√") NUMBER("9") OPERATOR("+") OPERATOR("∛") NUMBER("27")`; got != want {
+		t.Errorf("tokens = %s, want %s", got, want)
+	}
+	checkEval(t, c, []evalCase{
+		{"√9", "3"},
+		{"∛27", "3"},
+		{"∛-8", "-2"},
+		// The radicals bind tighter than the binary operators.
+		{"√9 + 16", "19"},
+		{"∛8 * 2", "4"},
+		{"-√4", "-2"},
+		{"√√16", "2"},
+		{"√(9 + 16)", "5"},
+		{"∛(20 + 7)", "3"},
+	})
+}
+
+func TestMaxLength(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{{"1" + strings.Repeat(" + 1", 100), "101"}})
+
+	c.MaxLength = 9
+	checkEval(t, c, []evalCase{{"1 + 2 + 3", "6"}})
+	_, err := c.Evaluate("1 + 2 + 34")
+	if !errors.Is(err, ErrInputTooLong) {
+		t.Fatalf("error = %v, want ErrInputTooLong", err)
+	}
+	if want := "expression too long: 10 characters, the limit is 9"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+	// The limit counts characters, not bytes.
+	checkEval(t, c, []evalCase{{"√9 + √16", "7"}})
+}
+
+func TestExponentOrConstantE(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"2e3", "2000"},
+		{"2E3", "2000"},
+		{"2e-3", "0.002"},
+		{"2e+3", "2000"},
+		{"1.5e2", "150"},
+		// An e not followed by digits is the constant, multiplied.
+		{"2e", "5.43656365691809"},
+		{"2 e", "5.43656365691809"},
+		{"2*e", "5.43656365691809"},
+		{"e", "2.718281828459045"},
+	})
+	// e3 is an identifier, not e times 3 or 1e3.
+	checkEvalError(t, c, "e3", "unknown identifier 'e3'")
+	checkEvalError(t, c, "2ex", "unknown identifier 'ex'")
+}
+
+func TestChainedAssignment(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"a = b = 5", "5"},
+		{"a", "5"},
+		{"b", "5"},
+		{"x = y = z = 7", "7"},
+		{"x + y + z", "21"},
+		// The value of an assignment is the value assigned.
+		{"a = b = 2 * 3", "6"},
+		{"a * b", "36"},
+	})
+	checkEvalError(t, c, "3 = 4", "cannot assign to 3")
+}
+
+func TestSequence(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"x = 3, x + 1", "4"},
+		{"x", "3"},
+		{"1, 2, 3", "3"},
+		{"a = 1, b = a + 1, a + b", "3"},
+		{"(x = 2, x * 3) + 1", "7"},
+		{"(1, 2) * 3", "6"},
+		// Inside a call the comma separates arguments.
+		{"mean(1, 5, 3)", "3"},
+		{"mean(1, 2), 5", "5"},
+		{"mean((x = 1, x + 4), 3)", "4"},
+	})
+	// Assignments made before a failing part stay made.
+	checkEvalError(t, c, "y = 9, 1/0", "division by zero")
+	checkEval(t, c, []evalCase{{"y", "9"}})
+
+	// A comma written as a thousands separator is not a sequence.
+	checkEvalError(t, c, "1,000", "comma between numbers at position 1")
+	checkEvalError(t, c, "$1,000", "comma between numbers at position 2")
+	checkEvalError(t, c, "x = 2,500", "comma between numbers at position 5")
+	checkEval(t, c, []evalCase{
+		{"1, 000", "0"},
+		{"(1),000", "0"},
+		{"1,-5", "-5"},
+	})
+
+	tokens, err := c.Tokenize("x = 3, x + 1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postfix, err := c.toPostfix(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Tokens(postfix).String(), `TARGET("x") NUMBER("3") OPERATOR("=") IDENTIFIER("x") NUMBER("1") OPERATOR("+") OPERATOR(",")`; got != want {
+		t.Errorf("postfix = %s, want %s", got, want)
+	}
+	if v, err := c.EvaluateRPN("x 3 = x 1 + ,"); err != nil || v.String() != "4" {
+		t.Errorf("EvaluateRPN(x 3 = x 1 + ,) = %v, %v, want 4", v, err)
+	}
+}
+
+func TestMaxLiteralLength(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{{strings.Repeat("0", 999) + "5", "5"}})
+
+	huge := "1 + " + strings.Repeat("9", 1001)
+	_, err := c.Evaluate(huge)
+	if !errors.Is(err, ErrLiteralTooLong) {
+		t.Fatalf("error = %v, want ErrLiteralTooLong", err)
+	}
+	if want := "number literal too long: number at position 4 exceeds 1000 characters"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+
+	c.MaxLiteralLength = 3
+	checkEval(t, c, []evalCase{{"999 + 1", "1000"}})
+	if _, err := c.Evaluate("1000"); !errors.Is(err, ErrLiteralTooLong) {
+		t.Errorf("error = %v, want ErrLiteralTooLong", err)
+	}
+}
+
+func TestUnicodeDigits(t *testing.T) {
+	c := NewCalculator()
+	checkEvalError(t, c, "٤٢", "non-ASCII digit ٤ at position 0")
+
+	c.UnicodeDigits = true
+	checkEval(t, c, []evalCase{
+		{"٤٢ + 1", "43"}, // Arabic-Indic
+		{"۴۲", "42"},     // Extended Arabic-Indic
+		{"४२", "42"},     // Devanagari
+		{"১২", "12"},     // Bengali
+		{"４２", "42"},     // fullwidth
+		{"٣.٥", "3.5"},
+		{"٢ * 21", "42"},
+	})
+}
+
+// flatCases are flat chains that evaluateFlat takes, among them ones that
+// fail or overflow.
+var flatCases = []string{
+	"1 + 2 - 3",
+	"a + b - c",
+	"2 * a / 4",
+	"10 / 4 * 2",
+	"0.1 + 0.2",
+	"a - b - c - 1",
+	"1e308 + 1e308",
+	"1e308 * 10 / 10",
+	"a - 1e308 - 1e308",
+	"inf - 1",
+	"1 / 0",
+	"a / (b - b)",
+	"1 + nothere",
+	"a * s",
+	"2 +% 50",
+	"1e3 + 2",
+}
+
+func TestEvaluateFlatMatchesGeneral(t *testing.T) {
+	for _, mode := range []OverflowMode{OverflowIEEE, OverflowError, OverflowSaturate} {
+		c := NewCalculator()
+		c.Overflow = mode
+		checkEval(t, c, []evalCase{{"a = 3", "3"}, {"b = 5", "5"}, {"c = 7", "7"}, {`s = "x"`, "x"}})
+		for _, input := range flatCases {
+			tokens, err := c.tokenize(input)
+			if err != nil {
+				t.Fatalf("tokenize(%q): %v", input, err)
+			}
+			if _, ok, _ := c.evaluateFlat(tokens); !ok && !strings.Contains(input, "(") {
+				t.Errorf("evaluateFlat(%q) took the general path", input)
+			}
+
+			// The general path, as Evaluate takes it for anything else.
+			want, wantErr := Value{}, error(nil)
+			postfix, err := c.toPostfix(tokens)
+			if err == nil {
+				want, wantErr = c.evaluatePostfix(postfix)
+			}
+			got, err := c.Evaluate(input)
+			if fmt.Sprint(err) != fmt.Sprint(wantErr) || got.String() != want.String() {
+				t.Errorf("mode %d: Evaluate(%q) = %v, %v on the flat path, %v, %v on the general one", mode, input, got, err, want, wantErr)
+			}
+		}
+	}
+}
+
+// BenchmarkEvaluateFlat compares the single pass over a flat chain with
+// converting the same tokens to postfix and evaluating that.
+func BenchmarkEvaluateFlat(b *testing.B) {
+	c := NewCalculator()
+	for _, input := range []string{"a = 3", "b = 5"} {
+		if _, err := c.Evaluate(input); err != nil {
+			b.Fatal(err)
+		}
+	}
+	tokens, err := c.tokenize("a + b - 2 + a - 1 + b")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("Flat", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, ok, err := c.evaluateFlat(tokens); !ok || err != nil {
+				b.Fatal(ok, err)
+			}
+		}
+	})
+	b.Run("General", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			postfix, err := c.toPostfix(tokens)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := c.evaluatePostfix(postfix); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestImplicitMultiply(t *testing.T) {
+	juxtapositions := []struct {
+		kind         ImplicitMultiply
+		input, value string
+		disabled     string
+	}{
+		{ImplicitNumberParen, "2(3)", "6", "implicit multiplication before ( at position 1 is disabled"},
+		{ImplicitParenParen, "(1 + 1)(3)", "6", "implicit multiplication before ( at position 7 is disabled"},
+		{ImplicitNumberIdent, "2x", "10", "implicit multiplication before x at position 1 is disabled"},
+		{ImplicitNumberIdent, "2 pi", "6.283185307179586", "implicit multiplication before pi at position 2 is disabled"},
+		{ImplicitNumberIdent, "2sqrt(4)", "4", "implicit multiplication before sqrt at position 1 is disabled"},
+	}
+	// Every combination of the three, from none to ImplicitAll.
+	for set := ImplicitMultiply(0); set <= ImplicitAll; set++ {
+		c := NewCalculator()
+		c.Implicit = set
+		checkEval(t, c, []evalCase{{"x = 5", "5"}})
+		for _, j := range juxtapositions {
+			if set&j.kind != 0 {
+				checkEval(t, c, []evalCase{{j.input, j.value}})
+			} else {
+				checkEvalError(t, c, j.input, j.disabled)
+			}
+		}
+	}
+}
+
+func TestParseImplicitMultiply(t *testing.T) {
+	for list, want := range map[string]ImplicitMultiply{
+		"all":                       ImplicitAll,
+		"none":                      0,
+		"number-paren":              ImplicitNumberParen,
+		"number-paren, paren-paren": ImplicitNumberParen | ImplicitParenParen,
+		"number-ident,paren-paren":  ImplicitNumberIdent | ImplicitParenParen,
+	} {
+		if got, err := ParseImplicitMultiply(list); err != nil || got != want {
+			t.Errorf("ParseImplicitMultiply(%q) = %v, %v, want %v", list, got, err, want)
+		}
+	}
+	if _, err := ParseImplicitMultiply("number-number"); err == nil {
+		t.Error("ParseImplicitMultiply(\"number-number\") succeeded")
+	}
+}
+
+func TestMaxTokens(t *testing.T) {
+	c := NewCalculator()
+	c.MaxTokens = 9
+	checkEval(t, c, []evalCase{
+		{"1+2+3+4+5", "15"},
+		// Whitespace is not counted, however much of it there is.
+		{"1" + strings.Repeat(" ", 100) + "+ 2", "3"},
+	})
+
+	// A token-dense input is stopped while it is scanned.
+	_, err := c.Evaluate(strings.Repeat("1+", 1000) + "1")
+	if !errors.Is(err, ErrTooManyTokens) {
+		t.Fatalf("error = %v, want ErrTooManyTokens", err)
+	}
+	if want := "too many tokens: more than 9"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+
+	// Macro expansion can take an input over the limit too.
+	if err := c.Define("f(x) = x + x + x"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Evaluate("f(1)")
+	if !errors.Is(err, ErrTooManyTokens) || err.Error() != "too many tokens: 13 tokens, the limit is 9" {
+		t.Errorf("error = %v, want ErrTooManyTokens after expansion", err)
+	}
+}
+
+func TestChainedComparison(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"x = 5", "5"},
+		{"1 < x < 10", "1"},
+		{"1 < x < 4", "0"},
+		{"6 < x < 10", "0"},
+		// Parenthesized, the truth value of 6 < x is compared: 0 < 10.
+		{"(6 < x) < 10", "1"},
+		{"1 <= x <= 5", "1"},
+		{"10 > x > 1", "1"},
+		{"1 < 3 < x < 10", "1"},
+		{"1 < 3 < x < 4", "0"},
+		{"1 < 7 < x < 10", "0"},
+		{"5 == x == 5", "1"},
+		{"5 == x == 6", "0"},
+		{"1 < 2 == 2 < 3", "1"},
+	})
+
+	// The middle operand is evaluated once.
+	checkEval(t, c, []evalCase{
+		{"n = 0", "0"},
+		{"0 < (n = n + 1) < 5", "1"},
+		{"n", "1"},
+	})
+}
+
+func TestIncomplete(t *testing.T) {
+	c := NewCalculator()
+	for input, want := range map[string]bool{
+		"3 +":            true,
+		"2 *":            true,
+		"2 ^":            true,
+		"1 ==":           true,
+		"x in":           true,
+		"3 min":          true,
+		"2 * -":          true,
+		"√":              true,
+		"fixed(3.14159,": true,
+		"(1 + 2":         true,
+		"3 + 4":          false,
+		"(1 + 2)":        false,
+		"sqrt(4)":        false,
+		"":               false,
+		"1 + 2)":         false,
+		"3 + @":          false,
+	} {
+		if got := c.incomplete(input); got != want {
+			t.Errorf("incomplete(%q) = %v, want %v", input, got, want)
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	c := NewCalculator()
+	if c.MaxDepth != DefaultMaxDepth {
+		t.Errorf("MaxDepth = %d, want %d", c.MaxDepth, DefaultMaxDepth)
+	}
+	c.MaxDepth = 5
+	for _, input := range []string{
+		"((((((1))))))",
+		"2^2^2^2^2^2^2",
+		"sqrt(sqrt(sqrt(sqrt(sqrt(sqrt(1))))))",
+		"mean(1, 2, 3, 4, 5, 6, 7)",
+	} {
+		_, err := c.Evaluate(input)
+		if !errors.Is(err, ErrMaxDepthExceeded) {
+			t.Errorf("Evaluate(%q) error = %v, want ErrMaxDepthExceeded", input, err)
+		}
+		var perr *PositionError
+		if !errors.As(err, &perr) {
+			t.Errorf("Evaluate(%q) error %v has no position", input, err)
+		}
+	}
+	checkEvalError(t, c, "((((((1))))))", "expression nested too deeply: more than 5 levels at position 6")
+	checkEval(t, c, []evalCase{
+		{"((((1))))", "1"},
+		{"2^2^2", "16"},
+		{"mean(1, 2, 3)", "2"},
+	})
+
+	c.MaxDepth = 0
+	checkEval(t, c, []evalCase{{strings.Repeat("(", 20000) + "1" + strings.Repeat(")", 20000), "1"}})
+	c.MaxDepth = DefaultMaxDepth
+	checkEvalError(t, c, strings.Repeat("(", 20000)+"1"+strings.Repeat(")", 20000), "expression nested too deeply")
+}
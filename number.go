@@ -0,0 +1,224 @@
+package main
+
+import "fmt"
+
+// Number is a value of a NumberSystem, such as a fixed-point decimal.
+type Number interface {
+	// String returns the number as a literal the system's Parse reads
+	// back, which Explain relies on to write intermediate results.
+	String() string
+	// Float64 returns the nearest float64, for the methods returning one
+	// such as Calculate.
+	Float64() float64
+}
+
+// NumberSystem is a numeric type the Calculator evaluates in instead of
+// float64, set as its Numbers field. It makes a decimal type usable, so
+// that 0.1 + 0.2 == 0.3 exactly, much as rational mode does with
+// fractions. The methods are given only Numbers the system made.
+type NumberSystem interface {
+	// Parse reads a number literal as written in the input, such as 0.1
+	// or 1e3, and the numbers String returns.
+	Parse(literal string) (Number, error)
+	// FromFloat converts a float64, the value of a constant or of a
+	// variable set outside the system. A system with no such conversion
+	// returns an error.
+	FromFloat(x float64) (Number, error)
+	Add(a, b Number) (Number, error)
+	Sub(a, b Number) (Number, error)
+	Mul(a, b Number) (Number, error)
+	// Quo returns a / b, or an error such as division by zero or, for a
+	// fixed-point type, a quotient it cannot hold.
+	Quo(a, b Number) (Number, error)
+	Neg(a Number) Number
+	// Cmp returns -1, 0 or +1 as a is less than, equal to or greater than
+	// b.
+	Cmp(a, b Number) int
+}
+
+// NumberPower is implemented by a NumberSystem that supports ^; without it
+// ^ is an error.
+type NumberPower interface {
+	Pow(a, b Number) (Number, error)
+}
+
+// Implementations of the operators over a NumberSystem, keyed by symbol.
+// Those without one are errors when Numbers is set.
+var numberBinary = map[string]func(s NumberSystem, a, b Number) (Number, error){
+	"+":  func(s NumberSystem, a, b Number) (Number, error) { return s.Add(a, b) },
+	"-":  func(s NumberSystem, a, b Number) (Number, error) { return s.Sub(a, b) },
+	"*":  func(s NumberSystem, a, b Number) (Number, error) { return s.Mul(a, b) },
+	"/":  func(s NumberSystem, a, b Number) (Number, error) { return s.Quo(a, b) },
+	"+%": func(s NumberSystem, a, b Number) (Number, error) { return percentNumber(s, a, b, s.Add) },
+	"-%": func(s NumberSystem, a, b Number) (Number, error) { return percentNumber(s, a, b, s.Sub) },
+	"^": func(s NumberSystem, a, b Number) (Number, error) {
+		p, ok := s.(NumberPower)
+		if !ok {
+			return nil, fmt.Errorf("the number system has no powers")
+		}
+		return p.Pow(a, b)
+	},
+	"min": func(s NumberSystem, a, b Number) (Number, error) {
+		if s.Cmp(a, b) <= 0 {
+			return a, nil
+		}
+		return b, nil
+	},
+	"max": func(s NumberSystem, a, b Number) (Number, error) {
+		if s.Cmp(a, b) >= 0 {
+			return a, nil
+		}
+		return b, nil
+	},
+	"==": compareNumbers(func(cmp int) bool { return cmp == 0 }),
+	"!=": compareNumbers(func(cmp int) bool { return cmp != 0 }),
+	"<>": compareNumbers(func(cmp int) bool { return cmp != 0 }),
+	"<":  compareNumbers(func(cmp int) bool { return cmp < 0 }),
+	"<=": compareNumbers(func(cmp int) bool { return cmp <= 0 }),
+	">":  compareNumbers(func(cmp int) bool { return cmp > 0 }),
+	">=": compareNumbers(func(cmp int) bool { return cmp >= 0 }),
+}
+
+var numberUnary = map[string]func(s NumberSystem, x Number) Number{
+	"-": func(s NumberSystem, x Number) Number { return s.Neg(x) },
+	"+": func(s NumberSystem, x Number) Number { return x },
+}
+
+// percentNumber is a +% b with change s.Add and a -% b with s.Sub: a
+// changed by a * b / 100.
+func percentNumber(s NumberSystem, a, b Number, change func(a, b Number) (Number, error)) (Number, error) {
+	hundred, err := s.Parse("100")
+	if err != nil {
+		return nil, err
+	}
+	x, err := s.Mul(a, b)
+	if err != nil {
+		return nil, err
+	}
+	if x, err = s.Quo(x, hundred); err != nil {
+		return nil, err
+	}
+	return change(a, x)
+}
+
+// compareNumbers adapts a test of s.Cmp(a, b) to a comparison operator,
+// whose result is the system's 1 or 0. Epsilon does not apply.
+func compareNumbers(holds func(cmp int) bool) func(s NumberSystem, a, b Number) (Number, error) {
+	return func(s NumberSystem, a, b Number) (Number, error) {
+		if holds(s.Cmp(a, b)) {
+			return s.Parse("1")
+		}
+		return s.Parse("0")
+	}
+}
+
+// evaluateNumbers is evaluatePostfix when Numbers is set, with every
+// number held by the NumberSystem. Like rational mode it offers only
+// arithmetic, comparisons, min, max and assignment.
+func (c *Calculator) evaluateNumbers(tokens []Token) (Value, error) {
+	s := c.Numbers
+	var stack []Value
+
+	for _, token := range tokens {
+		if err := c.checkStep(len(stack), token); err != nil {
+			return Value{}, err
+		}
+		switch token.Type {
+		case NUMBER:
+			x, err := s.Parse(token.Value)
+			if err != nil {
+				return Value{}, errorAt(token.Pos, "invalid number %q at position %d: %v", token.Value, token.Pos, err)
+			}
+			stack = append(stack, Value{Kind: CustomValue, Custom: x})
+		case IDENTIFIER:
+			x, err := c.lookupNumber(token.Value)
+			if err != nil {
+				return Value{}, err
+			}
+			stack = append(stack, Value{Kind: CustomValue, Custom: x})
+		case TARGET:
+			stack = append(stack, Value{Kind: targetValue, Str: token.Value})
+		case STRING:
+			return Value{}, fmt.Errorf("strings are not supported with a number system")
+		case FUNCTION:
+			return Value{}, fmt.Errorf("function %s is not supported with a number system", token.Value)
+		case OPERATOR:
+			op := c.operators[token.Op]
+			if op.unary {
+				apply, ok := numberUnary[op.symbol]
+				if !ok {
+					return Value{}, fmt.Errorf("operator %s is not supported with a number system", token.Value)
+				}
+				if len(stack) < 1 {
+					return Value{}, fmt.Errorf("not enough operands for operator %s", token.Value)
+				}
+				x := stack[len(stack)-1]
+				if x.Kind != CustomValue {
+					return Value{}, fmt.Errorf("operator %s expects a number, got assignment target %s", token.Value, x.Str)
+				}
+				stack[len(stack)-1] = Value{Kind: CustomValue, Custom: apply(s, x.Custom)}
+				continue
+			}
+			if len(stack) < 2 {
+				return Value{}, fmt.Errorf("not enough operands for operator %s", token.Value)
+			}
+			a, b := stack[len(stack)-2], stack[len(stack)-1]
+			if op.assign {
+				if err := c.assign(a, b); err != nil {
+					return Value{}, errorAt(token.Pos, "%w at position %d", err, token.Pos)
+				}
+				stack = append(stack[:len(stack)-2], b)
+				continue
+			}
+			if op.sequence {
+				stack = append(stack[:len(stack)-2], b)
+				continue
+			}
+			apply, ok := numberBinary[op.symbol]
+			if !ok || token.Chain {
+				return Value{}, fmt.Errorf("operator %s is not supported with a number system", token.Value)
+			}
+			for _, x := range []Value{a, b} {
+				if x.Kind != CustomValue {
+					return Value{}, fmt.Errorf("operator %s expects a number, got assignment target %s", token.Value, x.Str)
+				}
+			}
+			result, err := apply(s, a.Custom, b.Custom)
+			if err != nil {
+				return Value{}, errorAt(token.Pos, "%w at position %d", err, token.Pos)
+			}
+			v := Value{Kind: CustomValue, Custom: result}
+			if op.boolean {
+				v.Format = FormatBool
+			}
+			stack = append(stack[:len(stack)-2], v)
+		}
+	}
+
+	if len(stack) != 1 || stack[0].Kind != CustomValue {
+		return Value{}, fmt.Errorf("invalid expression")
+	}
+
+	return stack[0], nil
+}
+
+// lookupNumber reads a variable as a Number. The constants and variables
+// assigned without Numbers set convert from their float64 values.
+func (c *Calculator) lookupNumber(name string) (Number, error) {
+	v, err := c.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	switch v.Kind {
+	case CustomValue:
+		return v.Custom, nil
+	case NumberValue, RationalValue:
+		x, _ := v.number(name)
+		n, err := c.Numbers.FromFloat(x)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("%s is not a number", name)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// decimalSystem is a NumberSystem of fixed-point decimals with six
+// places, enough to show exact decimal arithmetic.
+type decimalSystem struct{}
+
+// decimal is units millionths.
+type decimal struct{ units *big.Int }
+
+var decimalScale = big.NewInt(1000000)
+
+func (d decimal) String() string {
+	s := new(big.Rat).SetFrac(d.units, decimalScale).FloatString(6)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+func (d decimal) Float64() float64 {
+	f, _ := new(big.Rat).SetFrac(d.units, decimalScale).Float64()
+	return f
+}
+
+func (decimalSystem) Parse(literal string) (Number, error) {
+	r, ok := new(big.Rat).SetString(literal)
+	if !ok {
+		return nil, fmt.Errorf("not a decimal")
+	}
+	r.Mul(r, new(big.Rat).SetInt(decimalScale))
+	if !r.IsInt() {
+		return nil, fmt.Errorf("more than 6 decimal places")
+	}
+	return decimal{r.Num()}, nil
+}
+
+func (s decimalSystem) FromFloat(x float64) (Number, error) {
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return nil, fmt.Errorf("%v is not a decimal", x)
+	}
+	return s.Parse(strconv.FormatFloat(x, 'f', 6, 64))
+}
+
+func (decimalSystem) Add(a, b Number) (Number, error) {
+	return decimal{new(big.Int).Add(a.(decimal).units, b.(decimal).units)}, nil
+}
+
+func (decimalSystem) Sub(a, b Number) (Number, error) {
+	return decimal{new(big.Int).Sub(a.(decimal).units, b.(decimal).units)}, nil
+}
+
+func (decimalSystem) Mul(a, b Number) (Number, error) {
+	x := new(big.Int).Mul(a.(decimal).units, b.(decimal).units)
+	return decimal{x.Quo(x, decimalScale)}, nil
+}
+
+func (decimalSystem) Quo(a, b Number) (Number, error) {
+	if b.(decimal).units.Sign() == 0 {
+		return nil, errors.New("division by zero")
+	}
+	x := new(big.Int).Mul(a.(decimal).units, decimalScale)
+	return decimal{x.Quo(x, b.(decimal).units)}, nil
+}
+
+func (decimalSystem) Neg(a Number) Number {
+	return decimal{new(big.Int).Neg(a.(decimal).units)}
+}
+
+func (decimalSystem) Cmp(a, b Number) int {
+	return a.(decimal).units.Cmp(b.(decimal).units)
+}
+
+func TestNumbers(t *testing.T) {
+	c := NewCalculator()
+	c.Numbers = decimalSystem{}
+	checkEval(t, c, []evalCase{
+		{"0.1 + 0.2 == 0.3", "1"},
+		{"0.1 + 0.2", "0.3"},
+		{"19.99 * 3", "59.97"},
+		{"1 / 3", "0.333333"},
+		{"-(2.5 - 4)", "1.5"},
+		{"1.5 max 2 min 1.75", "1.75"},
+		// Constants convert from their float64 values.
+		{"pi", "3.141593"},
+		{"x = 0.1", "0.1"},
+		{"x * 3 == 0.3", "1"},
+	})
+	v, err := c.Evaluate("0.1 + 0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Kind != CustomValue || v.Custom.(decimal).units.Int64() != 300000 {
+		t.Errorf("0.1 + 0.2 = %#v, want the CustomValue 0.3", v)
+	}
+	if f, err := c.Calculate("1 / 4"); err != nil || f != 0.25 {
+		t.Errorf("Calculate(1 / 4) = %v, %v, want 0.25", f, err)
+	}
+	checkEvalError(t, c, "1 / 0", "division by zero at position 2")
+	checkEvalError(t, c, "0.0000001", "more than 6 decimal places")
+	checkEvalError(t, c, "2 ^ 2", "the number system has no powers")
+	checkEvalError(t, c, "sqrt(4)", "not supported with a number system")
+	checkEvalError(t, c, `"text"`, "strings are not supported with a number system")
+
+	// Results in the number system are not cached.
+	c.CacheSize = 10
+	checkEval(t, c, []evalCase{{"1 + 1", "2"}})
+	if n := cacheLen(c); n != 0 {
+		t.Errorf("%d cache entries with Numbers set, want none", n)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+// physicalConstants are available as phys.NAME, so phys.c is the speed of
+// light while a variable named c stays free. Values are CODATA 2018 in SI
+// units; those that define the SI since 2019 are exact.
+var physicalConstants = map[string]float64{
+	"c":     299792458,         // speed of light in vacuum, m/s (exact)
+	"g":     9.80665,           // standard gravitational acceleration, m/s² (exact)
+	"G":     6.67430e-11,       // Newtonian constant of gravitation, m³/(kg·s²)
+	"h":     6.62607015e-34,    // Planck constant, J·s (exact)
+	"hbar":  1.054571817e-34,   // reduced Planck constant, J·s
+	"k":     1.380649e-23,      // Boltzmann constant, J/K (exact)
+	"NA":    6.02214076e23,     // Avogadro constant, 1/mol (exact)
+	"R":     8.314462618,       // molar gas constant, J/(mol·K)
+	"e":     1.602176634e-19,   // elementary charge, C (exact)
+	"me":    9.1093837015e-31,  // electron mass, kg
+	"mp":    1.67262192369e-27, // proton mass, kg
+	"eps0":  8.8541878128e-12,  // vacuum electric permittivity, F/m
+	"mu0":   1.25663706212e-6,  // vacuum magnetic permeability, N/A²
+	"sigma": 5.670374419e-8,    // Stefan–Boltzmann constant, W/(m²·K⁴)
+}
+
+// namespaces are the prefixes that, followed by a dot, form a single
+// name with the identifier after the dot, as in phys.c.
+var namespaces = map[string]bool{
+	"phys": true,
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestPhysicsConstants(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"phys.c", "2.99792458e+08"},
+		{"phys.g", "9.80665"},
+		{"phys.h", "6.62607015e-34"},
+		{"phys.NA * phys.k", "8.31446261815324"},
+		// The prefix keeps them apart from variables of the same name.
+		{"c = 3", "3"},
+		{"g = 4", "4"},
+		{"c * g", "12"},
+		{"phys.c / c", "9.993081933333333e+07"},
+	})
+	checkEvalError(t, c, "phys.nothere", "unknown identifier 'phys.nothere'")
+	checkEvalError(t, c, "phys.c = 1", "cannot assign to constant phys.c")
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// maxRationalBits bounds the size of the numerator and denominator of a
+// rational result, which exponentiation could otherwise grow without
+// limit.
+const maxRationalBits = 1 << 16
+
+// Rational-mode implementations of the operators, keyed by symbol. Those
+// without one, such as the radicals and the bitwise operators, are errors
+// in rational mode.
+var rationalBinary = map[string]func(a, b *big.Rat) (*big.Rat, error){
+	"+": func(a, b *big.Rat) (*big.Rat, error) { return new(big.Rat).Add(a, b), nil },
+	"-": func(a, b *big.Rat) (*big.Rat, error) { return new(big.Rat).Sub(a, b), nil },
+	"*": func(a, b *big.Rat) (*big.Rat, error) { return new(big.Rat).Mul(a, b), nil },
+	"/": func(a, b *big.Rat) (*big.Rat, error) {
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return new(big.Rat).Quo(a, b), nil
+	},
+	"+%": percentRational(1),
+	"-%": percentRational(-1),
+	"^":  powRational,
+	"min": func(a, b *big.Rat) (*big.Rat, error) {
+		if a.Cmp(b) <= 0 {
+			return a, nil
+		}
+		return b, nil
+	},
+	"max": func(a, b *big.Rat) (*big.Rat, error) {
+		if a.Cmp(b) >= 0 {
+			return a, nil
+		}
+		return b, nil
+	},
+	"==": compareRational(func(cmp int) bool { return cmp == 0 }),
+	"!=": compareRational(func(cmp int) bool { return cmp != 0 }),
+	"<>": compareRational(func(cmp int) bool { return cmp != 0 }),
+	"<":  compareRational(func(cmp int) bool { return cmp < 0 }),
+	"<=": compareRational(func(cmp int) bool { return cmp <= 0 }),
+	">":  compareRational(func(cmp int) bool { return cmp > 0 }),
+	">=": compareRational(func(cmp int) bool { return cmp >= 0 }),
+}
+
+var rationalUnary = map[string]func(x *big.Rat) *big.Rat{
+	"-": func(x *big.Rat) *big.Rat { return new(big.Rat).Neg(x) },
+	"+": func(x *big.Rat) *big.Rat { return x },
+}
+
+// rationalFunctions are the functions of rational mode, which take one
+// argument. The others are errors in rational mode.
+var rationalFunctions = map[string]func(x *big.Rat) (*big.Rat, error){
+	// fact(n) is n! as an exact integer, so fact(50) prints all 65 digits.
+	"fact": func(x *big.Rat) (*big.Rat, error) {
+		if !x.IsInt() || x.Sign() < 0 {
+			return nil, fmt.Errorf("expects a non-negative integer, got %s", x.RatString())
+		}
+		if !x.Num().IsInt64() {
+			return nil, fmt.Errorf("%s! exceeds %d bits", x.RatString(), maxRationalBits)
+		}
+		n, err := factorial(x.Num().Int64())
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Rat).SetInt(n), nil
+	},
+	// tofraction(x) is x, which is already a fraction.
+	"tofraction": func(x *big.Rat) (*big.Rat, error) { return x, nil },
+}
+
+// compareRational adapts a test of a.Cmp(b) to a comparison operator.
+// Rationals compare exactly; Epsilon does not apply.
+func compareRational(holds func(cmp int) bool) func(a, b *big.Rat) (*big.Rat, error) {
+	return func(a, b *big.Rat) (*big.Rat, error) {
+		if holds(a.Cmp(b)) {
+			return big.NewRat(1, 1), nil
+		}
+		return new(big.Rat), nil
+	}
+}
+
+// percentRational returns +% for a sign of 1 and -% for -1: a * (1 ±
+// b/100), exactly.
+func percentRational(sign int64) func(a, b *big.Rat) (*big.Rat, error) {
+	return func(a, b *big.Rat) (*big.Rat, error) {
+		change := new(big.Rat).Mul(b, big.NewRat(sign, 100))
+		return change.Mul(a, change.Add(change, big.NewRat(1, 1))), nil
+	}
+}
+
+// powRational raises a to an integer power b; a fractional power has no
+// rational result in general.
+func powRational(a, b *big.Rat) (*big.Rat, error) {
+	if !b.IsInt() {
+		return nil, fmt.Errorf("exponent %s is not an integer", b.RatString())
+	}
+	if !b.Num().IsInt64() {
+		return nil, fmt.Errorf("exponent %s is too large", b.RatString())
+	}
+	n := b.Num().Int64()
+	if a.Sign() == 0 && n < 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	exp := n
+	if exp < 0 {
+		exp = -exp
+	}
+	bits := int64(a.Num().BitLen())
+	if d := int64(a.Denom().BitLen()); d > bits {
+		bits = d
+	}
+	if bits > 1 && exp > maxRationalBits/bits {
+		return nil, fmt.Errorf("result exceeds %d bits", maxRationalBits)
+	}
+	e := big.NewInt(exp)
+	num := new(big.Int).Exp(a.Num(), e, nil)
+	den := new(big.Int).Exp(a.Denom(), e, nil)
+	if n < 0 {
+		num, den = den, num
+	}
+	return new(big.Rat).SetFrac(num, den), nil
+}
+
+// evaluateRational is evaluatePostfix for rational mode, where every
+// number is held exactly as a fraction, so 1/3 + 1/6 is 1/2.
+func (c *Calculator) evaluateRational(tokens []Token) (Value, error) {
+	var stack []Value
+
+	for _, token := range tokens {
+		if err := c.checkStep(len(stack), token); err != nil {
+			return Value{}, err
+		}
+		switch token.Type {
+		case NUMBER:
+			// Explain writes intermediate results back as fractions,
+			// which SetString reads too.
+			x, ok := new(big.Rat).SetString(token.Value)
+			if !ok {
+				return Value{}, errorAt(token.Pos, "invalid number %q at position %d", token.Value, token.Pos)
+			}
+			stack = append(stack, Value{Kind: RationalValue, Rat: x})
+		case IDENTIFIER:
+			x, err := c.lookupRational(token.Value)
+			if err != nil {
+				return Value{}, err
+			}
+			stack = append(stack, Value{Kind: RationalValue, Rat: x})
+		case TARGET:
+			stack = append(stack, Value{Kind: targetValue, Str: token.Value})
+		case STRING:
+			return Value{}, fmt.Errorf("strings are not supported in rational mode")
+		case FUNCTION:
+			apply, ok := rationalFunctions[token.Value]
+			if !ok {
+				return Value{}, fmt.Errorf("function %s is not supported in rational mode", token.Value)
+			}
+			if token.Args != 1 {
+				return Value{}, fmt.Errorf("%s expects 1 argument, got %d", token.Value, token.Args)
+			}
+			if len(stack) < 1 {
+				return Value{}, fmt.Errorf("not enough arguments for function %s", token.Value)
+			}
+			x := stack[len(stack)-1]
+			if x.Kind != RationalValue {
+				return Value{}, fmt.Errorf("%s expects a number, got assignment target %s", token.Value, x.Str)
+			}
+			result, err := apply(x.Rat)
+			if err != nil {
+				return Value{}, fmt.Errorf("%s: %w", token.Value, err)
+			}
+			stack[len(stack)-1] = Value{Kind: RationalValue, Rat: result}
+		case OPERATOR:
+			op := c.operators[token.Op]
+			if op.unary {
+				apply, ok := rationalUnary[op.symbol]
+				if !ok {
+					return Value{}, fmt.Errorf("operator %s is not supported in rational mode", token.Value)
+				}
+				if len(stack) < 1 {
+					return Value{}, fmt.Errorf("not enough operands for operator %s", token.Value)
+				}
+				x := stack[len(stack)-1]
+				if x.Kind != RationalValue {
+					return Value{}, fmt.Errorf("operator %s expects a number, got assignment target %s", token.Value, x.Str)
+				}
+				stack[len(stack)-1] = Value{Kind: RationalValue, Rat: apply(x.Rat)}
+				continue
+			}
+			if len(stack) < 2 {
+				return Value{}, fmt.Errorf("not enough operands for operator %s", token.Value)
+			}
+			a, b := stack[len(stack)-2], stack[len(stack)-1]
+			if op.assign {
+				if err := c.assign(a, b); err != nil {
+					return Value{}, errorAt(token.Pos, "%w at position %d", err, token.Pos)
+				}
+				stack = append(stack[:len(stack)-2], b)
+				continue
+			}
+			if op.sequence {
+				stack = append(stack[:len(stack)-2], b)
+				continue
+			}
+			apply, ok := rationalBinary[op.symbol]
+			if !ok || token.Chain {
+				return Value{}, fmt.Errorf("operator %s is not supported in rational mode", token.Value)
+			}
+			for _, x := range []Value{a, b} {
+				if x.Kind != RationalValue {
+					return Value{}, fmt.Errorf("operator %s expects a number, got assignment target %s", token.Value, x.Str)
+				}
+			}
+			result, err := apply(a.Rat, b.Rat)
+			if err != nil {
+				return Value{}, errorAt(token.Pos, "%w at position %d", err, token.Pos)
+			}
+			v := Value{Kind: RationalValue, Rat: result}
+			if op.boolean {
+				v.Format = FormatBool
+			}
+			stack = append(stack[:len(stack)-2], v)
+		}
+	}
+
+	if len(stack) != 1 || stack[0].Kind != RationalValue {
+		return Value{}, fmt.Errorf("invalid expression")
+	}
+
+	return stack[0], nil
+}
+
+// lookupRational reads a variable as a rational. A variable assigned
+// outside rational mode holds a float64, which converts exactly; the
+// constants have no exact value.
+func (c *Calculator) lookupRational(name string) (*big.Rat, error) {
+	if _, ok := c.constants[name]; ok {
+		return nil, fmt.Errorf("constant %s has no exact value in rational mode", name)
+	}
+	v, err := c.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	switch v.Kind {
+	case RationalValue:
+		return v.Rat, nil
+	case NumberValue:
+		if x := new(big.Rat); x.SetFloat64(v.Num) != nil {
+			return x, nil
+		}
+		return nil, fmt.Errorf("%s is %v, which is not a rational", name, v.Num)
+	}
+	return nil, fmt.Errorf("%s is not a number", name)
+}
+
+// toFraction returns the simplest fraction that is x as a float64: the
+// first convergent of the continued fraction of x's exact binary value
+// that rounds back to x. So 0.1 is 1/10 rather than the
+// 3602879701896397/36028797018963968 it is held as, and 1/3 is 1/3.
+func toFraction(x float64) (*big.Rat, error) {
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return nil, fmt.Errorf("expects a finite number, got %v", x)
+	}
+	exact := new(big.Rat).SetFloat64(x)
+	n := new(big.Int).Abs(exact.Num())
+	d := new(big.Int).Set(exact.Denom())
+	p0, q0, p1, q1 := big.NewInt(0), big.NewInt(1), big.NewInt(1), big.NewInt(0)
+	for {
+		// The last convergent is x exactly, so the loop ends before d
+		// reaches zero.
+		a, rem := new(big.Int).QuoRem(n, d, new(big.Int))
+		p0, p1 = p1, new(big.Int).Add(new(big.Int).Mul(a, p1), p0)
+		q0, q1 = q1, new(big.Int).Add(new(big.Int).Mul(a, q1), q0)
+		r := new(big.Rat).SetFrac(p1, q1)
+		if x < 0 {
+			r.Neg(r)
+		}
+		if f, _ := r.Float64(); f == x {
+			return r, nil
+		}
+		n, d = d, rem
+	}
+}
+
+// limitDenominator returns the fraction nearest to x whose denominator is
+// at most max, which is x itself if its denominator already is. The
+// candidates are the last convergent of x's continued fraction within the
+// bound and the best semiconvergent after it; a tie goes to the
+// convergent.
+func limitDenominator(x *big.Rat, max *big.Int) *big.Rat {
+	if x.Denom().Cmp(max) <= 0 {
+		return x
+	}
+	n := new(big.Int).Abs(x.Num())
+	d := new(big.Int).Set(x.Denom())
+	p0, q0, p1, q1 := big.NewInt(0), big.NewInt(1), big.NewInt(1), big.NewInt(0)
+	for {
+		a, rem := new(big.Int).QuoRem(n, d, new(big.Int))
+		q2 := new(big.Int).Add(new(big.Int).Mul(a, q1), q0)
+		if q2.Cmp(max) > 0 {
+			break
+		}
+		p0, p1 = p1, new(big.Int).Add(new(big.Int).Mul(a, p1), p0)
+		q0, q1 = q1, q2
+		n, d = d, rem
+	}
+	// The whole number of further steps of the last convergent that keeps
+	// the denominator within max.
+	k := new(big.Int).Quo(new(big.Int).Sub(max, q0), q1)
+	semi := new(big.Rat).SetFrac(
+		new(big.Int).Add(p0, new(big.Int).Mul(k, p1)),
+		new(big.Int).Add(q0, new(big.Int).Mul(k, q1)))
+	best := new(big.Rat).SetFrac(p1, q1)
+	abs := new(big.Rat).Abs(x)
+	if new(big.Rat).Abs(new(big.Rat).Sub(semi, abs)).Cmp(new(big.Rat).Abs(new(big.Rat).Sub(best, abs))) < 0 {
+		best = semi
+	}
+	if x.Sign() < 0 {
+		best.Neg(best)
+	}
+	return best
+}
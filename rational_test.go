@@ -0,0 +1,77 @@
+package main
+
+import "testing"
+
+func TestRational(t *testing.T) {
+	c := NewCalculator()
+	c.Rational = true
+	checkEval(t, c, []evalCase{
+		{"1/3", "1/3"},
+		{"2/4", "1/2"},
+		{"22/7", "22/7"},
+		{"1/3 + 1/6", "1/2"},
+		{"0.1 + 0.2", "3/10"},
+		{"6/3", "2"},
+		{"-4/6", "-2/3"},
+		{"(2/3)^-2", "9/4"},
+		{"1/3 < 1/2", "1"},
+	})
+	checkEvalError(t, c, "1/0", "division by zero")
+	checkEvalError(t, c, "2^(1/2)", "exponent 1/2 is not an integer")
+	checkEvalError(t, c, "sqrt(4)", "not supported in rational mode")
+}
+
+func TestRationalFlags(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--rational", "1/3"}, "Result = 1/3\n"},
+		{[]string{"--rational", "2/4"}, "Result = 1/2\n"},
+		{[]string{"--rational", "4/2"}, "Result = 2\n"},
+		{[]string{"--rational", "--approx", "1/4"}, "Result = 1/4 ≈ 0.25\n"},
+		// An integer has no decimal value to add.
+		{[]string{"--rational", "--approx", "4/2"}, "Result = 2\n"},
+	} {
+		stdout, stderr, code := run("", tc.args...)
+		if stdout != tc.want || code != 0 {
+			t.Errorf("calc %q = %q, %q, exit %d, want %q", tc.args, stdout, stderr, code, tc.want)
+		}
+	}
+}
+
+func TestToFraction(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"tofraction(0.75)", "3/4"},
+		{"tofraction(0.1)", "1/10"},
+		{"tofraction(-0.75)", "-3/4"},
+		{"tofraction(2)", "2"},
+		{"tofraction(1/3)", "1/3"},
+		{"tofraction(1/7)", "1/7"},
+		{"tofraction(2/3)", "2/3"},
+		{"tofraction(pi)", "245850922/78256779"},
+		// Arithmetic on the result is in floating point again.
+		{"tofraction(0.75) + 1", "1.75"},
+		{"rationalize(pi, 100)", "311/99"},
+		{"rationalize(pi, 1000)", "355/113"},
+		{"rationalize(0.333, 10)", "1/3"},
+		{"rationalize(0.142857, 10)", "1/7"},
+		{"rationalize(0.3333, 1)", "0"},
+		{"rationalize(0.75, 1000)", "3/4"},
+	})
+	checkEvalError(t, c, "tofraction(inf)", "tofraction: expects a finite number, got +Inf")
+	checkEvalError(t, c, "rationalize(1/3, 0)", "rationalize: expects a maximum denominator from 1 to 2^53, got 0")
+	checkEvalError(t, c, "rationalize(0.5, 1.5)", "rationalize: expects a maximum denominator from 1 to 2^53, got 1.5")
+
+	v, err := c.Evaluate("tofraction(0.75)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Kind != RationalValue || v.Rat.RatString() != "3/4" {
+		t.Errorf("tofraction(0.75) = %#v, want the RationalValue 3/4", v)
+	}
+
+	c.Rational = true
+	checkEval(t, c, []evalCase{{"tofraction(3/4)", "3/4"}})
+}
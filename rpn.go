@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// EvaluateRPN evaluates input written in Reverse Polish Notation, the form
+// Postfix returns: words separated by spaces, each a number, a "string", a
+// variable, an operator, neg for prefix minus, or a function, which takes
+// its arguments from the stack. Functions that take any number of
+// arguments need their count, as in 1 2 3 mean/3. In x 3 = the variable
+// before the value is assigned to.
+func (c *Calculator) EvaluateRPN(input string) (_ Value, err error) {
+	defer recoverPanic(&err)
+
+	tokens, err := c.scanRPN(input)
+	if err != nil {
+		return Value{}, err
+	}
+	return c.evaluatePostfix(tokens)
+}
+
+// scanRPN splits RPN input into tokens and checks that every operator and
+// function has its operands and exactly one value remains.
+func (c *Calculator) scanRPN(input string) ([]Token, error) {
+	var tokens []Token
+	// operands holds, for each value the input would leave on the stack,
+	// the index of the token that pushed it, or -1 for a computed value.
+	var operands []int
+
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(runes) && !unicode.IsSpace(runes[i]) {
+			i++
+		}
+		token, err := c.rpnToken(string(runes[start:i]), start)
+		if err != nil {
+			return nil, err
+		}
+
+		need := 0
+		switch token.Type {
+		case OPERATOR:
+			need = 2
+			if c.operators[token.Op].unary {
+				need = 1
+			}
+		case FUNCTION:
+			need = token.Args
+		}
+		if len(operands) < need {
+			return nil, errorAt(start, "%s at position %d needs %d operands, the stack has %d", token.Value, start, need, len(operands))
+		}
+		if token.Type == OPERATOR && c.operators[token.Op].assign {
+			if t := operands[len(operands)-2]; t >= 0 && tokens[t].Type == IDENTIFIER {
+				tokens[t].Type = TARGET
+			}
+		}
+		operands = operands[:len(operands)-need]
+		if need == 0 {
+			operands = append(operands, len(tokens))
+		} else {
+			operands = append(operands, -1)
+		}
+		tokens = append(tokens, token)
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	if len(operands) != 1 {
+		return nil, fmt.Errorf("malformed RPN: %d values left on the stack, want 1", len(operands))
+	}
+	return tokens, nil
+}
+
+// rpnToken classifies one word of RPN input starting at position pos.
+func (c *Calculator) rpnToken(word string, pos int) (Token, error) {
+	if word == "neg" {
+		return Token{Type: OPERATOR, Value: "-", Pos: pos, Op: c.unaryIDs["-"]}, nil
+	}
+	if id, ok := c.binarySymbols[word]; ok {
+		return Token{Type: OPERATOR, Value: c.operators[id].symbol, Pos: pos, Op: id}, nil
+	}
+	// A symbol that is also binary, such as -, is binary here; neg is
+	// the prefix minus.
+	if id, ok := c.prefixSymbols[word]; ok {
+		return Token{Type: OPERATOR, Value: c.operators[id].symbol, Pos: pos, Op: id}, nil
+	}
+	if len(word) >= 2 && word[0] == '"' && word[len(word)-1] == '"' {
+		return Token{Type: STRING, Value: word[1 : len(word)-1], Pos: pos}, nil
+	}
+
+	r := []rune(word)
+	digits := r
+	if len(r) > 1 && r[0] == '-' {
+		// A negative literal, as Postfix writes them.
+		digits = r[1:]
+	}
+	if unicode.IsDigit(digits[0]) || digits[0] == '.' {
+		// The evaluator reports malformed numbers.
+		value, err := c.asciiDigits(r, pos)
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Type: NUMBER, Value: value, Pos: pos}, nil
+	}
+
+	name, count := word, ""
+	if i := strings.LastIndexByte(word, '/'); i > 0 {
+		name, count = word[:i], word[i+1:]
+	}
+	for j, ch := range name {
+		if name == setFunction && count != "" {
+			// A set literal, as Postfix writes them: 1 2 {}/2.
+			break
+		}
+		if (j == 0 && !isIdentStart(ch)) || !isIdentPart(ch) {
+			return Token{}, errorAt(pos, "invalid RPN word %q at position %d", word, pos)
+		}
+	}
+	f, isFunc := c.functions[name]
+	if count != "" {
+		n, err := strconv.Atoi(count)
+		if !isFunc || err != nil || n < 0 {
+			return Token{}, errorAt(pos, "invalid RPN word %q at position %d", word, pos)
+		}
+		return Token{Type: FUNCTION, Value: name, Pos: pos, Args: n}, nil
+	}
+	if isFunc {
+		if f.arity < 0 {
+			return Token{}, errorAt(pos, "%s at position %d takes any number of arguments, write %s/N with the count", name, pos, name)
+		}
+		return Token{Type: FUNCTION, Value: name, Pos: pos, Args: f.arity}, nil
+	}
+	if _, ok := complexFunctions[name]; ok && c.Complex {
+		return Token{Type: FUNCTION, Value: name, Pos: pos, Args: 1}, nil
+	}
+	return Token{Type: IDENTIFIER, Value: name, Pos: pos}, nil
+}
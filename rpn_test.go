@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestEvaluateRPN(t *testing.T) {
+	c := NewCalculator()
+	for input, want := range map[string]string{
+		"3 4 +":           "7",
+		"3 4 2 * +":       "11",
+		"2 3 2 ^ ^":       "512",
+		"10 4 - 3 -":      "3",
+		"16 sqrt 2 neg +": "2",
+		"1 2 3 mean/3":    "2",
+		"x 3 = x 1 + ,":   "4",
+		"  5  ":           "5",
+	} {
+		v, err := c.EvaluateRPN(input)
+		if err != nil {
+			t.Errorf("EvaluateRPN(%q): %v", input, err)
+			continue
+		}
+		if v.String() != want {
+			t.Errorf("EvaluateRPN(%q) = %v, want %s", input, v, want)
+		}
+	}
+}
+
+func TestEvaluateRPNMalformed(t *testing.T) {
+	c := NewCalculator()
+	for input, want := range map[string]string{
+		"3 +":      "+ at position 2 needs 2 operands, the stack has 1",
+		"3 4":      "malformed RPN: 2 values left on the stack, want 1",
+		"1 2 mean": "mean at position 4 takes any number of arguments, write mean/N with the count",
+		"3 4 )":    `invalid RPN word ")" at position 4`,
+		"1 0 /":    "division by zero at position 4",
+	} {
+		if _, err := c.EvaluateRPN(input); err == nil || err.Error() != want {
+			t.Errorf("EvaluateRPN(%q) error = %v, want %q", input, err, want)
+		}
+	}
+}
+
+func TestRPNInputFlag(t *testing.T) {
+	t.Setenv("CALC_EXPR", "")
+	out, errout, _ := run("3 4 +\n3 +\n2 3 2 ^ ^\n", "--rpn-input", "--quiet")
+	if out != "7\n512\n" || errout != "+ at position 2 needs 2 operands, the stack has 1\n" {
+		t.Errorf("output = %q, errors %q", out, errout)
+	}
+}
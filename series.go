@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// maxSeriesTerms bounds the number of terms a series may add up.
+const maxSeriesTerms = 1000000
+
+// collectSeries rewrites each series(i, from, to, term) in tokens into a
+// call series(i, from, to) whose token carries term in postfix form as
+// its Body, with i as an assignment target. The term cannot be evaluated
+// as an argument, since it needs i bound to each value in turn.
+func (c *Calculator) collectSeries(tokens []Token) ([]Token, error) {
+	var out []Token
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if token.Type != IDENTIFIER || token.Value != "series" || i+1 >= len(tokens) || tokens[i+1].Type != LPAREN {
+			out = append(out, token)
+			continue
+		}
+		args, end := macroArgs(tokens, i+1)
+		if end < 0 {
+			// Unbalanced; leave it for toPostfix to report.
+			out = append(out, tokens[i:]...)
+			break
+		}
+		if len(args) != 4 || len(args[0]) != 1 || args[0][0].Type != IDENTIFIER || len(args[3]) == 0 {
+			return nil, errorAt(token.Pos, "series at position %d expects series(index, from, to, term)", token.Pos)
+		}
+
+		body, err := c.toPostfix(args[3])
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.buildAST(body); err != nil {
+			return nil, err
+		}
+		token.Body = body
+		from, err := c.collectSeries(args[1])
+		if err != nil {
+			return nil, err
+		}
+		to, err := c.collectSeries(args[2])
+		if err != nil {
+			return nil, err
+		}
+
+		index := args[0][0]
+		index.Type = TARGET
+		out = append(out, token, tokens[i+1], index, Token{Type: COMMA, Value: ",", Pos: index.Pos})
+		out = append(out, from...)
+		out = append(out, Token{Type: COMMA, Value: ",", Pos: index.Pos})
+		out = append(out, to...)
+		out = append(out, tokens[end])
+		i = end
+	}
+	return out, nil
+}
+
+// series evaluates the series call token on its index target and bounds:
+// the sum of its Body for each integer value of the index from the lower
+// bound to the upper one, or 0 if the upper bound is below the lower. The
+// index is a variable only while the series is evaluated; one of the same
+// name outside it keeps its value.
+func (c *Calculator) series(token Token, args []Value, stats *Stats) (Value, error) {
+	index := args[0]
+	var bounds [2]float64
+	for i, arg := range args[1:] {
+		b, err := arg.number("bound")
+		if err != nil {
+			return Value{}, err
+		}
+		bounds[i] = b
+	}
+	from, to := bounds[0], bounds[1]
+	if _, err := seriesTerms(from, to); err != nil {
+		return Value{}, err
+	}
+
+	previous, had := c.vars[index.Str]
+	defer func() {
+		if had {
+			c.vars[index.Str] = previous
+		} else {
+			delete(c.vars, index.Str)
+		}
+	}()
+
+	sum := 0.0
+	for k := from; k <= to; k++ {
+		if err := c.assign(index, Value{Num: k}); err != nil {
+			return Value{}, err
+		}
+		term, err := c.evaluate(token.Body, stats)
+		if err != nil {
+			return Value{}, err
+		}
+		x, err := term.number("term")
+		if err != nil {
+			return Value{}, err
+		}
+		sum, err = c.checkOverflow(sum+x, sum, x)
+		if err != nil {
+			return Value{}, err
+		}
+		if stats != nil {
+			stats.Operations++
+		}
+	}
+	return Value{Num: sum}, nil
+}
+
+// seriesTerms returns the number of terms of a series from from to to,
+// which must be integers at most maxSeriesTerms apart.
+func seriesTerms(from, to float64) (int, error) {
+	for _, b := range []float64{from, to} {
+		if b != math.Trunc(b) || math.IsInf(b, 0) {
+			return 0, fmt.Errorf("bounds must be integers, got %v", b)
+		}
+	}
+	if to-from >= maxSeriesTerms {
+		return 0, fmt.Errorf("%.0f terms, the limit is %d", to-from+1, maxSeriesTerms)
+	}
+	if to < from {
+		return 0, nil
+	}
+	return int(to-from) + 1, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestSeries(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"series(i,1,5,i)", "15"},
+		{"series(i,1,5,i^2)", "55"},
+		{"series(k, 0, 3, 2^k)", "15"},
+		{"series(i, 5, 1, i)", "0"},
+		{"series(i, 3, 3, i * 10)", "30"},
+		{"series(i, 1, 3, series(j, 1, i, j))", "10"},
+		{"2 * series(i, 1, 4, i) + 1", "21"},
+		// The index does not outlive the series.
+		{"i = 100", "100"},
+		{"series(i, 1, 2, i)", "3"},
+		{"i", "100"},
+	})
+	checkEvalError(t, c, "series(i, 1.5, 3, i)", "bounds must be integers, got 1.5")
+	checkEvalError(t, c, "series(i, 1, inf, i)", "bounds must be integers, got +Inf")
+	checkEvalError(t, c, "series(i, 1, 1000001, i)", "1000001 terms, the limit is 1000000")
+	checkEvalError(t, c, "series(i, 1, 5)", "series at position 0 expects series(index, from, to, term)")
+	checkEvalError(t, c, "series(2, 1, 5, i)", "expects series(index, from, to, term)")
+}
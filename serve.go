@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limits --serve applies to every request. The length and token limits
+// bound the input, unless --max-length or --max-tokens set tighter ones.
+// Short input can still take long to evaluate, as with nested series, so
+// input whose EstimateCost is over serveMaxCost is turned away before it
+// waits for the Calculator, and evaluation gives up after serveTimeout
+// unless --timeout sets another limit.
+const (
+	serveMaxLength = 4096
+	serveMaxTokens = 1024
+	serveMaxCost   = 10000000
+	serveTimeout   = 10 * time.Second
+)
+
+// server answers GET /calc?expr=... for --serve. Requests share one
+// Calculator, so they are evaluated one at a time, and the variables a
+// request assigns are forgotten once it is answered.
+type server struct {
+	mu      sync.Mutex
+	calc    *Calculator
+	timeout time.Duration
+	// config is a copy of calc as configured, which estimate copies
+	// again without taking mu.
+	config Calculator
+}
+
+// calcResponse is the JSON body of a /calc response. Result is a number,
+// or a string for infinities and NaN, which JSON cannot represent.
+type calcResponse struct {
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// newServer returns the handler of --serve, evaluating with c after
+// applying the request limits. A timeout other than zero replaces
+// serveTimeout as the bound on how long each request may evaluate.
+func newServer(c *Calculator, timeout time.Duration) http.Handler {
+	if c.MaxLength == 0 || c.MaxLength > serveMaxLength {
+		c.MaxLength = serveMaxLength
+	}
+	if c.MaxTokens == 0 || c.MaxTokens > serveMaxTokens {
+		c.MaxTokens = serveMaxTokens
+	}
+	if timeout == 0 {
+		timeout = serveTimeout
+	}
+	s := &server{calc: c, timeout: timeout, config: *c}
+	s.config.Trace, s.config.cache = nil, nil
+	mux := http.NewServeMux()
+	mux.HandleFunc("/calc", s.calculate)
+	return mux
+}
+
+// serve runs the --serve server on addr until it fails.
+func serve(addr string, c *Calculator, timeout time.Duration) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           newServer(c, timeout),
+		ReadHeaderTimeout: 10 * time.Second,
+		MaxHeaderBytes:    1 << 16,
+	}
+	return srv.ListenAndServe()
+}
+
+func (s *server) calculate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		reply(w, http.StatusMethodNotAllowed, calcResponse{Error: "method not allowed, use GET"})
+		return
+	}
+	expr := r.URL.Query().Get("expr")
+	if expr == "" {
+		reply(w, http.StatusBadRequest, calcResponse{Error: "missing expr parameter"})
+		return
+	}
+
+	cost, err := s.estimate(expr)
+	if err == nil && cost > serveMaxCost {
+		reply(w, http.StatusRequestEntityTooLarge, calcResponse{Error: fmt.Sprintf("expression too expensive: %d operations, the limit is %d", cost, serveMaxCost)})
+		return
+	}
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	// A request whose client goes away stops evaluating too. The timeout
+	// starts once the Calculator is free.
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	v, err := s.calc.EvaluateContext(ctx, expr)
+	var result float64
+	if err == nil {
+		result, err = v.number("result")
+	}
+	for name := range s.calc.vars {
+		delete(s.calc.vars, name)
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	reply(w, http.StatusOK, calcResponse{Result: traceValue(Value{Num: result})})
+}
+
+// estimate returns the EstimateCost of expr. It works on its own copy of
+// config, with its own variables, so requests can be estimated while
+// another is being evaluated.
+func (s *server) estimate(expr string) (int, error) {
+	c := s.config
+	c.vars = make(map[string]Value)
+	return c.EstimateCost(expr)
+}
+
+// fail replies with err and the status for it.
+func (s *server) fail(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		reply(w, http.StatusBadRequest, calcResponse{Error: fmt.Sprintf("evaluation timed out after %v", s.timeout)})
+	case errors.Is(err, ErrInputTooLong), errors.Is(err, ErrTooManyTokens), errors.Is(err, ErrLiteralTooLong):
+		reply(w, http.StatusRequestEntityTooLarge, calcResponse{Error: err.Error()})
+	case errors.Is(err, ErrInternal):
+		reply(w, http.StatusInternalServerError, calcResponse{Error: err.Error()})
+	default:
+		reply(w, http.StatusBadRequest, calcResponse{Error: err.Error()})
+	}
+}
+
+func reply(w http.ResponseWriter, status int, body calcResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
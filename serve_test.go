@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// get sends GET /calc?expr=expr to h, returning the status and body.
+func get(h http.Handler, expr string) (int, string) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/calc?expr="+url.QueryEscape(expr), nil))
+	return w.Code, strings.TrimSpace(w.Body.String())
+}
+
+func TestServe(t *testing.T) {
+	h := newServer(NewCalculator(), 0)
+	for _, tc := range []struct {
+		expr   string
+		status int
+		body   string
+	}{
+		{"3+4", http.StatusOK, `{"result":7}`},
+		{"1e308 * 10", http.StatusOK, `{"result":"+Inf"}`},
+		{"1/0", http.StatusBadRequest, `{"error":"division by zero at position 1"}`},
+		{strings.Repeat("1+", 2500) + "1", http.StatusRequestEntityTooLarge, `{"error":"expression too long`},
+		{strings.Repeat("1+", 600) + "1", http.StatusRequestEntityTooLarge, `{"error":"too many tokens`},
+		// Assignments do not outlive their request.
+		{"x = 2", http.StatusOK, `{"result":2}`},
+		{"x", http.StatusBadRequest, `{"error":"unknown identifier 'x'`},
+	} {
+		status, body := get(h, tc.expr)
+		if status != tc.status || !strings.HasPrefix(body, tc.body) {
+			t.Errorf("GET %.20q = %d %s, want %d %s", tc.expr, status, body, tc.status, tc.body)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/calc", nil))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "missing expr parameter") {
+		t.Errorf("GET with no expr = %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/calc?expr=1", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("POST = %d with Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestServeRejectsCostlyInput(t *testing.T) {
+	h := newServer(NewCalculator(), 0)
+	start := time.Now()
+	status, body := get(h, "series(i,1,1000000,series(j,1,1000000,j))")
+	if status != http.StatusRequestEntityTooLarge || !strings.Contains(body, "expression too expensive") {
+		t.Errorf("nested series = %d %s, want 413 and too expensive", status, body)
+	}
+	// The estimate does not evaluate the series.
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("refusing the nested series took %v", d)
+	}
+	// Bounds that are not constant are estimated at the most terms.
+	if status, body := get(h, "n = 5, series(i,1,n,series(j,1,n,j))"); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("series with variable bounds = %d %s, want 413", status, body)
+	}
+	if status, body := get(h, "series(i,1,1000,series(j,1,1000,j))"); status != http.StatusOK {
+		t.Errorf("series within the budget = %d %s, want 200", status, body)
+	}
+}
+
+func TestServeTimeout(t *testing.T) {
+	h := newServer(NewCalculator(), time.Nanosecond)
+	status, body := get(h, "series(i,1,1000000,i)")
+	if status != http.StatusBadRequest || !strings.Contains(body, "evaluation timed out after 1ns") {
+		t.Errorf("slow request = %d %s, want 400 and timed out", status, body)
+	}
+}
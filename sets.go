@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// setFunction names the function a set literal {a, b, c} is scanned as.
+// It is not an identifier, so no expression can call it by name.
+const setFunction = "{}"
+
+// makeSet collects the members of a set literal, which must be numbers.
+func makeSet(args []Value) (Value, error) {
+	members := make([]float64, len(args))
+	for i, arg := range args {
+		num, err := arg.number("set member " + strconv.Itoa(i+1))
+		if err != nil {
+			return Value{}, err
+		}
+		members[i] = num
+	}
+	return Value{Kind: setValue, members: members}, nil
+}
+
+// member evaluates x in set for the in operator. members compare as ==
+// does, so within Epsilon.
+func (c *Calculator) member(x, set Value) (Value, error) {
+	if set.Kind != setValue {
+		return Value{}, fmt.Errorf("operator in expects a set such as {1, 2, 3} on the right, got %v", set)
+	}
+	num, err := x.number("operator in")
+	if err != nil {
+		return Value{}, err
+	}
+	for _, m := range set.members {
+		if c.equal(num, m) {
+			return Value{Num: 1, Format: FormatBool}, nil
+		}
+	}
+	return Value{Num: 0, Format: FormatBool}, nil
+}
+
+func formatSet(members []float64) string {
+	parts := make([]string, len(members))
+	for i, m := range members {
+		parts[i] = strconv.FormatFloat(m, 'g', -1, 64)
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
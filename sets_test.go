@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestMembership(t *testing.T) {
+	c := NewCalculator()
+	checkEval(t, c, []evalCase{
+		{"3 in {1, 2, 3}", "1"},
+		{"4 in {1, 2, 3}", "0"},
+		{"2 in {}", "0"},
+		{"3 in {1 + 2}", "1"},
+		{"x = 2", "2"},
+		{"x in {1, x}", "1"},
+		{"1 + 1 in {2}", "1"},
+		{"{1, 2}", "{1, 2}"},
+		// Members compare exactly unless Epsilon is set.
+		{"0.1 + 0.2 in {0.3}", "0"},
+	})
+	checkEvalError(t, c, `"a" in {1}`, `operator in expects a number, got string "a"`)
+
+	c.Epsilon = 1e-9
+	checkEval(t, c, []evalCase{{"0.1 + 0.2 in {0.3}", "1"}, {"0.31 in {0.3}", "0"}})
+}
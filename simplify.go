@@ -0,0 +1,93 @@
+package main
+
+// Simplify returns expr with its constant subexpressions folded and the
+// identities x + 0, x - 0, x * 1, x / 1, x ^ 1, x ^ 0 and x * 0 applied,
+// so (2+3) * x is "5 * x" and y * 1 + 0 is "y". It leaves alone anything
+// it cannot fold, including divisions by zero, assignments and calls of
+// rand, ans and registered functions.
+func (c *Calculator) Simplify(expr string) (_ string, err error) {
+	defer recoverPanic(&err)
+
+	root, err := c.parse(expr)
+	if err != nil {
+		return "", err
+	}
+	return c.format(c.simplify(root)), nil
+}
+
+func (c *Calculator) simplify(n *Node) *Node {
+	if len(n.Args) == 0 {
+		return n
+	}
+	if n.Token.Chain {
+		return c.simplifyChain(n)
+	}
+	args := make([]*Node, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = c.simplify(arg)
+	}
+
+	if n.Token.Type == OPERATOR {
+		op := c.operators[n.Token.Op]
+		switch {
+		case op.assign:
+			return &Node{Token: n.Token, Args: args}
+		case op.unary && n.Token.Value == "-":
+			return c.neg(args[0])
+		case !op.unary:
+			switch n.Token.Value {
+			case "+", "-", "*", "/", "^":
+				return c.binary(n.Token.Value, args[0], args[1])
+			}
+		}
+	}
+
+	folded := &Node{Token: n.Token, Args: args}
+	if n.Token.Type == FUNCTION {
+		f, ok := c.functions[n.Token.Value]
+		if !ok || f.registered || f.volatile {
+			return folded
+		}
+	}
+	for _, arg := range args {
+		if _, ok := numberValue(arg); !ok {
+			return folded
+		}
+	}
+	return c.fold(folded)
+}
+
+// fold evaluates n, whose operands are all literals, to a literal, or
+// returns it unchanged if evaluating it fails.
+func (c *Calculator) fold(n *Node) *Node {
+	v, err := c.evaluatePostfix(n.postfix())
+	if err != nil || v.Kind != NumberValue {
+		return n
+	}
+	return number(v.Num)
+}
+
+// simplifyChain simplifies a chained comparison such as 1 < x < 2 + 3. The
+// comparisons in it fold only all together: folding 1 < 2 in 1 < 2 < x
+// would lose the 2 that x is compared with.
+func (c *Calculator) simplifyChain(n *Node) *Node {
+	var link func(n *Node) (*Node, bool)
+	link = func(n *Node) (*Node, bool) {
+		var left *Node
+		literal := true
+		if n.Token.Chain {
+			left, literal = link(n.Args[0])
+		} else {
+			left = c.simplify(n.Args[0])
+			_, literal = numberValue(left)
+		}
+		right := c.simplify(n.Args[1])
+		_, ok := numberValue(right)
+		return &Node{Token: n.Token, Args: []*Node{left, right}}, literal && ok
+	}
+	chain, literal := link(n)
+	if !literal {
+		return chain
+	}
+	return c.fold(chain)
+}
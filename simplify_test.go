@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestSimplify(t *testing.T) {
+	c := NewCalculator()
+	for _, tc := range []struct{ expr, want string }{
+		// Constant subexpressions fold.
+		{"2 + 3 * x", "2 + 3 * x"},
+		{"(2+3) * x", "5 * x"},
+		{"2 * 3 + 4", "10"},
+		{"sqrt(16) * x", "4 * x"},
+		// Only whole constant subexpressions: (x + 2) + 3 is left alone.
+		{"x + 2 + 3", "x + 2 + 3"},
+		// Identities.
+		{"x * 1", "x"},
+		{"1 * x", "x"},
+		{"x + 0", "x"},
+		{"0 + x", "x"},
+		{"x - 0", "x"},
+		{"x / 1", "x"},
+		{"x ^ 1", "x"},
+		{"x * 0", "0"},
+		{"-(-x)", "x"},
+		{"(x+0)*(1*y)", "x * y"},
+		// Calls whose result can change are not folded.
+		{"rand() * 1", "rand()"},
+	} {
+		got, err := c.Simplify(tc.expr)
+		if err != nil {
+			t.Errorf("Simplify(%q): %v", tc.expr, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Simplify(%q) = %q, want %q", tc.expr, got, tc.want)
+		}
+	}
+	if _, err := c.Simplify("1 +"); err == nil {
+		t.Error("Simplify(\"1 +\") succeeded")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// terminal reports whether f is a character device, which is as near as
+// this system comes to telling a terminal; /dev/null is one too.
+func terminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// traceRecord is one line of the trace written to Calculator.Trace.
+type traceRecord struct {
+	Step     int           `json:"step"`
+	Token    string        `json:"token"`
+	Pos      int           `json:"pos"`
+	Operands []interface{} `json:"operands"`
+	Result   interface{}   `json:"result"`
+	Stack    []interface{} `json:"stack"`
+}
+
+// traceOperands copies the values the operator or function token is
+// about to take from the stack, or returns nil for other tokens.
+func traceOperands(c *Calculator, token Token, stack []Value) []Value {
+	n := 0
+	switch token.Type {
+	case OPERATOR:
+		n = 2
+		if c.operators[token.Op].unary {
+			n = 1
+		}
+	case FUNCTION:
+		n = token.Args
+	default:
+		return nil
+	}
+	if len(stack) < n {
+		return nil
+	}
+	return append([]Value{}, stack[len(stack)-n:]...)
+}
+
+// traceStep writes the record of applying token to operands, leaving
+// stack, as a line of JSON:
+//
+//	{"step":1,"token":"+","pos":6,"operands":[3,4],"result":7,"stack":[2,7]}
+func (c *Calculator) traceStep(step int, token Token, operands, stack []Value) {
+	rec := traceRecord{
+		Step:     step,
+		Token:    token.Value,
+		Pos:      token.Pos,
+		Operands: traceValues(operands),
+		Stack:    traceValues(stack),
+	}
+	if len(stack) > 0 {
+		rec.Result = traceValue(stack[len(stack)-1])
+	}
+	// The trace is best effort; a failing writer does not fail the
+	// evaluation.
+	json.NewEncoder(c.Trace).Encode(rec)
+}
+
+func traceValues(vs []Value) []interface{} {
+	out := make([]interface{}, len(vs))
+	for i, v := range vs {
+		out[i] = traceValue(v)
+	}
+	return out
+}
+
+// traceValue is v as JSON: finite numbers as numbers, anything else, such
+// as strings, infinities and assignment targets, as its text.
+func traceValue(v Value) interface{} {
+	if v.Kind == targetValue {
+		return v.Str
+	}
+	if v.Kind == NumberValue && !math.IsInf(v.Num, 0) && !math.IsNaN(v.Num) {
+		return v.Num
+	}
+	return v.String()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	c := NewCalculator()
+	var buf bytes.Buffer
+	c.Trace = &buf
+	checkEval(t, c, []evalCase{{"2 * (3 + 4)", "14"}})
+	want := `{"step":1,"token":"+","pos":7,"operands":[3,4],"result":7,"stack":[2,7]}
+{"step":2,"token":"*","pos":2,"operands":[2,7],"result":14,"stack":[14]}
+`
+	if buf.String() != want {
+		t.Errorf("trace =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestTraceRecords(t *testing.T) {
+	c := NewCalculator()
+	var buf bytes.Buffer
+	c.Trace = &buf
+	checkEval(t, c, []evalCase{{"x = -sqrt(16)", "-4"}, {"1e308 * 10", "+Inf"}})
+
+	var records []traceRecord
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec traceRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("trace line %q: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	var tokens []string
+	for _, rec := range records {
+		tokens = append(tokens, rec.Token)
+	}
+	// Steps count from 1 in each evaluation.
+	if got := strings.Join(tokens, " "); got != "sqrt - = *" {
+		t.Fatalf("traced tokens %q, want sqrt - = *", got)
+	}
+	if rec := records[2]; rec.Operands[0] != "x" || rec.Result != -4.0 {
+		t.Errorf("assignment record %+v, want target x and result -4", rec)
+	}
+	// Values JSON cannot hold as numbers are written as text.
+	if rec := records[3]; rec.Step != 1 || rec.Result != "+Inf" {
+		t.Errorf("overflow record %+v, want step 1 and result +Inf", rec)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ValueKind distinguishes the kinds of result an expression can have.
+type ValueKind int
+
+const (
+	NumberValue ValueKind = iota
+	StringValue
+	// ComplexValue is a result in complex mode, held in Complex.
+	ComplexValue
+	// RationalValue is a result in rational mode, held in Rat.
+	RationalValue
+	// CustomValue is a result of a Calculator with Numbers set, held in
+	// Custom.
+	CustomValue
+	// TupleValue is a group of numbers returned together, such as the
+	// radius and angle from polar, held in Tuple. It can only be a result:
+	// anything that needs a number rejects it.
+	TupleValue
+	// targetValue is the left side of an assignment while it is being
+	// evaluated; Str holds the variable name.
+	targetValue
+	// setValue is a set literal such as {1, 2, 3}, the right operand of
+	// in.
+	setValue
+)
+
+// NumberFormat is a presentation hint for a numeric Value.
+type NumberFormat int
+
+const (
+	// FormatDefault prints the shortest representation, 5 or 2.5.
+	FormatDefault NumberFormat = iota
+	// FormatInt prints a whole number without an exponent, as int()
+	// results are.
+	FormatInt
+	// FormatFloat always shows a fractional part, as float() results do:
+	// 5.0 rather than 5.
+	FormatFloat
+	// FormatBool marks the 1 or 0 result of a comparison. It prints as a
+	// number; the REPL prints true or false with --bool.
+	FormatBool
+)
+
+// Value is the result of evaluating an expression. Nearly everything is a
+// number; functions such as base produce strings.
+type Value struct {
+	Kind ValueKind
+	Num  float64
+	Str  string
+	// Format only affects how Num is printed. It is set by int(), float()
+	// and comparisons and is not carried through further arithmetic.
+	Format NumberFormat
+	// Complex is the value of a ComplexValue.
+	Complex complex128
+	// Rat is the value of a RationalValue.
+	Rat *big.Rat
+	// Custom is the value of a CustomValue.
+	Custom Number
+	// Tuple holds the elements of a TupleValue.
+	Tuple []float64
+	// members holds the elements of a setValue.
+	members []float64
+	// middle is the right operand of a comparison, which the next
+	// comparison in a chain such as 1 < x < 10 compares again.
+	middle float64
+}
+
+func (v Value) String() string {
+	switch v.Kind {
+	case StringValue:
+		return v.Str
+	case ComplexValue:
+		return formatComplex(v.Complex)
+	case RationalValue:
+		// An integer has no denominator: 2 rather than 2/1.
+		return v.Rat.RatString()
+	case CustomValue:
+		return v.Custom.String()
+	case setValue:
+		return formatSet(v.members)
+	case TupleValue:
+		parts := make([]string, len(v.Tuple))
+		for i, x := range v.Tuple {
+			parts[i] = strconv.FormatFloat(x, 'g', -1, 64)
+		}
+		return "(" + strings.Join(parts, ", ") + ")"
+	}
+	switch v.Format {
+	case FormatInt:
+		if !math.IsInf(v.Num, 0) && !math.IsNaN(v.Num) {
+			return strconv.FormatFloat(v.Num, 'f', 0, 64)
+		}
+	case FormatFloat:
+		s := strconv.FormatFloat(v.Num, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eIN") {
+			s += ".0"
+		}
+		return s
+	}
+	return strconv.FormatFloat(v.Num, 'g', -1, 64)
+}
+
+// number returns v as a float64, or an error naming what needed a number.
+func (v Value) number(what string) (float64, error) {
+	switch v.Kind {
+	case StringValue:
+		return 0, fmt.Errorf("%s expects a number, got string %q", what, v.Str)
+	case targetValue:
+		return 0, fmt.Errorf("%s expects a number, got assignment target %s", what, v.Str)
+	case RationalValue:
+		f, _ := v.Rat.Float64()
+		return f, nil
+	case CustomValue:
+		return v.Custom.Float64(), nil
+	case setValue:
+		return 0, fmt.Errorf("%s expects a number, got set %s", what, formatSet(v.members))
+	case TupleValue:
+		return 0, fmt.Errorf("%s expects a number, got tuple %v", what, v)
+	case ComplexValue:
+		if imag(v.Complex) != 0 {
+			return 0, fmt.Errorf("%s expects a real number, got %s", what, formatComplex(v.Complex))
+		}
+		return real(v.Complex), nil
+	}
+	return v.Num, nil
+}
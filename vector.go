@@ -0,0 +1,50 @@
+package main
+
+import "fmt"
+
+// EvaluateVector evaluates expr once for each of values bound to the
+// variable name, returning the results in the same order, so x * 2 + 1
+// over 1, 2, 3 is 3, 5, 7. The expression is parsed once rather than per
+// value. It fails at the first value the expression fails for, saying
+// which. Other variables keep their values throughout, and name is
+// restored afterwards to what it was before.
+func (c *Calculator) EvaluateVector(expr, name string, values []float64) (_ []float64, err error) {
+	defer recoverPanic(&err)
+
+	target := Value{Kind: targetValue, Str: name}
+	previous, had := c.vars[name]
+	defer func() {
+		if had {
+			c.vars[name] = previous
+		} else {
+			delete(c.vars, name)
+		}
+	}()
+	if err := c.assign(target, Value{}); err != nil {
+		return nil, err
+	}
+
+	tokens, err := c.tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	postfix, err := c.toPostfix(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]float64, len(values))
+	for i, x := range values {
+		if err := c.assign(target, Value{Num: x}); err != nil {
+			return nil, err
+		}
+		v, err := c.evaluatePostfix(postfix)
+		if err == nil {
+			results[i], err = v.number("result")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s = %v: %w", name, x, err)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEvaluateVector(t *testing.T) {
+	c := NewCalculator()
+	got, err := c.EvaluateVector("x * 2 + 1", "x", []float64{1, 2, 3, -0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{3, 5, 7, 0}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("EvaluateVector = %v, want %v", got, want)
+	}
+	if got, err := c.EvaluateVector("x + 1", "x", nil); err != nil || len(got) != 0 {
+		t.Errorf("EvaluateVector of no values = %v, %v", got, err)
+	}
+
+	// Other variables are read as usual, and the bound one is restored.
+	checkEval(t, c, []evalCase{{"x = 10", "10"}, {"k = 3", "3"}})
+	got, err = c.EvaluateVector("k * x", "x", []float64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[3 6]" {
+		t.Errorf("EvaluateVector(k * x) = %v, want [3 6]", got)
+	}
+	checkEval(t, c, []evalCase{{"x", "10"}})
+
+	if _, err := c.EvaluateVector("1 / x", "x", []float64{1, 0, 2}); err == nil || err.Error() != "x = 0: division by zero at position 2" {
+		t.Errorf("EvaluateVector(1 / x) error = %v", err)
+	}
+	if _, err := c.EvaluateVector("x +", "x", []float64{1}); err == nil {
+		t.Errorf("EvaluateVector of a malformed expression succeeded")
+	}
+	if _, err := c.EvaluateVector("pi * 2", "pi", []float64{1}); err == nil {
+		t.Errorf("EvaluateVector binding the constant pi succeeded")
+	}
+}
+
+func BenchmarkEvaluateVector(b *testing.B) {
+	values := make([]float64, 1000)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	b.Run("Vector", func(b *testing.B) {
+		c := NewCalculator()
+		for i := 0; i < b.N; i++ {
+			if _, err := c.EvaluateVector("x * 2 + 1", "x", values); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Calculate", func(b *testing.B) {
+		c := NewCalculator()
+		for i := 0; i < b.N; i++ {
+			for _, x := range values {
+				c.vars["x"] = Value{Num: x}
+				if _, err := c.Calculate("x * 2 + 1"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,20 @@
+package main
+
+import "runtime/debug"
+
+// version is the release being built, set with
+//
+//	go build -ldflags "-X main.version=1.2.0"
+var version string
+
+// Version returns the version calc was built as: the one set with
+// -ldflags, else the module version recorded by go install, else "devel".
+func Version() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "devel"
+}
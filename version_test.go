@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestVersionFlag(t *testing.T) {
+	defer func(v string) { version = v }(version)
+
+	version = ""
+	if out, _, code := run("", "--version"); code != 0 || out != "calc "+Version()+"\n" {
+		t.Errorf("--version = %q, exit %d", out, code)
+	}
+
+	version = "1.2.0"
+	if got := Version(); got != "1.2.0" {
+		t.Errorf("Version() = %q, want the -ldflags version 1.2.0", got)
+	}
+	// The flag wins over any expression.
+	if out, _, code := run("", "--version", "1 + 1"); code != 0 || out != "calc 1.2.0\n" {
+		t.Errorf("--version = %q, exit %d, want %q", out, code, "calc 1.2.0\n")
+	}
+}